@@ -0,0 +1,85 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log"
+    "sync"
+    "time"
+
+    "aeza/internal/queue"
+
+    "github.com/google/uuid"
+    "github.com/redis/go-redis/v9"
+)
+
+// errTaskCancelled is the cause of a job context cancelled through the API.
+var errTaskCancelled = errors.New("task cancelled")
+
+// cancelMemory is how long a cancelled task is remembered, so that its jobs still
+// waiting in the queue are skipped when they come up.
+const cancelMemory = time.Hour
+
+// cancellations tracks running jobs by task so that a cancel published by the API
+// stops them.
+type cancellations struct {
+    mu        sync.Mutex
+    running   map[uuid.UUID]map[*context.CancelCauseFunc]struct{}
+    cancelled map[uuid.UUID]time.Time
+}
+
+func newCancellations() *cancellations {
+    return &cancellations{running: map[uuid.UUID]map[*context.CancelCauseFunc]struct{}{}, cancelled: map[uuid.UUID]time.Time{}}
+}
+
+// start derives the context of one job of task id; done must be called when the job ends.
+func (c *cancellations) start(ctx context.Context, id uuid.UUID) (jobCtx context.Context, done func()) {
+    jobCtx, cancel := context.WithCancelCause(ctx)
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if _, ok := c.cancelled[id]; ok {
+        cancel(errTaskCancelled)
+        return jobCtx, func() {}
+    }
+    key := &cancel
+    if c.running[id] == nil { c.running[id] = map[*context.CancelCauseFunc]struct{}{} }
+    c.running[id][key] = struct{}{}
+    return jobCtx, func() {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        delete(c.running[id], key)
+        if len(c.running[id]) == 0 { delete(c.running, id) }
+        cancel(nil)
+    }
+}
+
+// cancel stops every running job of task id and the ones that start later.
+func (c *cancellations) cancel(id uuid.UUID) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    now := time.Now()
+    for t, at := range c.cancelled {
+        if now.Sub(at) > cancelMemory { delete(c.cancelled, t) }
+    }
+    c.cancelled[id] = now
+    for cancel := range c.running[id] { (*cancel)(errTaskCancelled) }
+}
+
+// listen applies the cancellations published on queue.CancelChannel until ctx is done.
+// The subscription reconnects by itself when redis goes away.
+func (c *cancellations) listen(ctx context.Context, rdb *redis.Client) {
+    sub := rdb.Subscribe(ctx, queue.CancelChannel)
+    defer sub.Close()
+    ch := sub.Channel()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case m, ok := <-ch:
+            if !ok { return }
+            id, err := uuid.Parse(m.Payload)
+            if err != nil { log.Printf("bad cancel message %q", m.Payload); continue }
+            c.cancel(id)
+        }
+    }
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "runtime/debug"
+    "strings"
+    "sync"
+    "time"
+
+    "aeza/internal/checker"
+    "aeza/internal/queue"
+
+    "github.com/redis/go-redis/v9"
+)
+
+// targetLimiter caps how many checks run against the same host at once, across all jobs.
+type targetLimiter struct {
+    limit int
+    mu    sync.Mutex
+    slots map[string]chan struct{}
+    users map[string]int
+}
+
+func newTargetLimiter(limit int) *targetLimiter {
+    if limit < 1 { limit = 1 }
+    return &targetLimiter{limit: limit, slots: map[string]chan struct{}{}, users: map[string]int{}}
+}
+
+func (l *targetLimiter) acquire(ctx context.Context, host string) error {
+    l.mu.Lock()
+    ch, ok := l.slots[host]
+    if !ok {
+        ch = make(chan struct{}, l.limit)
+        l.slots[host] = ch
+    }
+    l.users[host]++
+    l.mu.Unlock()
+    select {
+    case ch <- struct{}{}:
+        return nil
+    case <-ctx.Done():
+        l.done(host)
+        return ctx.Err()
+    }
+}
+
+func (l *targetLimiter) release(host string) {
+    l.mu.Lock()
+    ch := l.slots[host]
+    l.mu.Unlock()
+    <-ch
+    l.done(host)
+}
+
+// done drops the host's semaphore once nobody holds or waits for it.
+func (l *targetLimiter) done(host string) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.users[host]--
+    if l.users[host] == 0 {
+        delete(l.users, host)
+        delete(l.slots, host)
+    }
+}
+
+// runJob runs the methods of one job in parallel (up to MethodWorkers) and posts
+// every result as soon as its method finishes. Work is abandoned when the task is
+// cancelled or its deadline passes: the API has closed it and takes no more results.
+func runJob(ctx context.Context, cfg AgentConfig, limiter *targetLimiter, cancels *cancellations, sp *spool, job queue.TaskJob) {
+    taskID := job.TaskID.String()
+    jobCtx, done := cancels.start(ctx, job.TaskID)
+    defer done()
+    if job.Deadline != nil {
+        var cancel context.CancelFunc
+        jobCtx, cancel = context.WithDeadline(jobCtx, *job.Deadline)
+        defer cancel()
+    }
+    if jobCtx.Err() != nil {
+        sendLog(ctx, cfg, taskID, "skip", "Задача уже закрыта: "+abandonReason(jobCtx))
+        return
+    }
+    sendLog(ctx, cfg, taskID, "start", fmt.Sprintf("Начало проверки: %v", job.Methods))
+    host := checker.Host(job.Target)
+    sem := make(chan struct{}, cfg.MethodWorkers)
+    var wg sync.WaitGroup
+    for _, m0 := range job.Methods {
+        m := strings.ToLower(m0)
+        c, found := checker.Lookup(m)
+        if !found {
+            sendLog(ctx, cfg, taskID, m, "Неизвестный метод")
+            continue
+        }
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            sem <- struct{}{}
+            defer func() { <-sem }()
+            if err := limiter.acquire(jobCtx, host); err != nil { return }
+            defer limiter.release(host)
+            if jobCtx.Err() != nil { return }
+
+            sendLog(ctx, cfg, taskID, m, "Старт метода")
+            mctx := jobCtx
+            if ms := job.Timeouts[m]; ms > 0 {
+                var cancel context.CancelFunc
+                mctx, cancel = checker.WithTimeout(jobCtx, time.Duration(ms)*time.Millisecond)
+                defer cancel()
+            }
+            res := runChecker(mctx, c, job.Target, job.Options[m])
+            if jobCtx.Err() != nil {
+                sendLog(ctx, cfg, taskID, m, "Прервано: "+abandonReason(jobCtx))
+                return
+            }
+            result := map[string]any{
+                "task_id": taskID,
+                "agent_id": cfg.AgentID,
+                "region": cfg.Region,
+                "method": c.Name(),
+                "success": res.Success,
+                "latency_ms": res.LatencyMs,
+                "status_code": res.StatusCode,
+                "message": res.Message,
+                "details": res.Details,
+                "checked_at": time.Now().UTC().Format(time.RFC3339Nano),
+            }
+            // the spool delivers the result, retrying while the API is unreachable
+            if err := sp.put(result); err != nil {
+                log.Printf("spool %s result of %s: %v", m, taskID, err)
+                b, _ := json.Marshal(result)
+                if err := postResult(ctx, cfg, b); err != nil { log.Printf("post %s result of %s: %v", m, taskID, err) }
+            }
+            sendLog(ctx, cfg, taskID, m, "Готово")
+        }()
+    }
+    wg.Wait()
+}
+
+// runChecker runs one method. A panicking checker fails its own result instead of
+// taking the agent down with every job in flight.
+func runChecker(ctx context.Context, c checker.Checker, target string, params json.RawMessage) (res checker.Result) {
+    defer func() {
+        if r := recover(); r != nil {
+            log.Printf("%s check of %s panicked: %v\n%s", c.Name(), target, r, debug.Stack())
+            res = checker.Result{Message: fmt.Sprintf("internal error: %v", r)}
+        }
+    }()
+    return c.Run(ctx, target, params)
+}
+
+func abandonReason(jobCtx context.Context) string {
+    if errors.Is(context.Cause(jobCtx), errTaskCancelled) { return "отменена" }
+    return "истёк срок"
+}
+
+// worker takes jobs off the agent's queue (or the shared one) until ctx is done.
+func worker(ctx context.Context, cfg AgentConfig, rdb *redis.Client, limiter *targetLimiter, cancels *cancellations, sp *spool) {
+    queueKey := "check_tasks:" + cfg.AgentID
+    for ctx.Err() == nil {
+        res, err := rdb.BRPop(ctx, 0, queueKey, "check_tasks").Result()
+        if err != nil { log.Printf("BRPOP error: %v", err); time.Sleep(1*time.Second); continue }
+        if len(res) != 2 { continue }
+        var job queue.TaskJob
+        if err := json.Unmarshal([]byte(res[1]), &job); err != nil { log.Printf("bad job: %v", err); continue }
+        runJob(ctx, cfg, limiter, cancels, sp, job)
+    }
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+    "context"
+    "strings"
+    "testing"
+
+    "aeza/internal/checker"
+)
+
+type none struct{}
+
+func TestRunCheckerRecoversPanic(t *testing.T) {
+    c := checker.Func("boom", func(context.Context, string, none) checker.Result { panic("index out of range") })
+    res := runChecker(context.Background(), c, "example.com", nil)
+    if res.Success || !strings.HasPrefix(res.Message, "internal error: index out of range") {
+        t.Fatalf("result = %+v", res)
+    }
+}
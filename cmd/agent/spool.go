@@ -0,0 +1,128 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "math/rand/v2"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+const (
+    spoolBackoffMin = time.Second
+    spoolBackoffMax = 5 * time.Minute
+)
+
+// spool keeps results on disk until the API has accepted them, so that neither an
+// API restart nor an agent restart loses them. Every result is one file named so
+// that the oldest sorts first.
+type spool struct {
+    dir  string
+    wake chan struct{}
+}
+
+// openSpool prepares dir and makes sure results can be written there.
+func openSpool(dir string) (*spool, error) {
+    if err := os.MkdirAll(dir, 0o700); err != nil { return nil, err }
+    probe, err := os.CreateTemp(dir, "probe-*.tmp")
+    if err != nil { return nil, err }
+    _ = probe.Close()
+    _ = os.Remove(probe.Name())
+    // a crash between write and rename leaves a temp file behind
+    tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+    for _, t := range tmps { _ = os.Remove(t) }
+    return &spool{dir: dir, wake: make(chan struct{}, 1)}, nil
+}
+
+// put stores one result durably and wakes the sender.
+func (s *spool) put(r map[string]any) error {
+    b, err := json.Marshal(r)
+    if err != nil { return err }
+    name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), uuid.NewString())
+    tmp := filepath.Join(s.dir, name+".tmp")
+    f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+    if err != nil { return err }
+    if _, err = f.Write(b); err == nil { err = f.Sync() }
+    if cerr := f.Close(); err == nil { err = cerr }
+    if err == nil { err = os.Rename(tmp, filepath.Join(s.dir, name)) }
+    if err != nil { _ = os.Remove(tmp); return err }
+    // the rename survives a crash only once the directory itself is synced
+    if err := syncDir(s.dir); err != nil { return err }
+    select {
+    case s.wake <- struct{}{}:
+    default:
+    }
+    return nil
+}
+
+func syncDir(dir string) error {
+    d, err := os.Open(dir)
+    if err != nil { return err }
+    defer d.Close()
+    return d.Sync()
+}
+
+// pending lists the spooled results, oldest first.
+func (s *spool) pending() ([]string, error) {
+    entries, err := os.ReadDir(s.dir)
+    if err != nil { return nil, err }
+    names := make([]string, 0, len(entries))
+    for _, e := range entries {
+        if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") { names = append(names, e.Name()) }
+    }
+    sort.Strings(names)
+    return names, nil
+}
+
+// backoff is the wait before retry number attempt (from 1): exponential with jitter,
+// so agents do not all come back at once after an API restart.
+func backoff(attempt int) time.Duration {
+    d := spoolBackoffMax
+    if attempt < 20 { d = min(spoolBackoffMin<<(attempt-1), spoolBackoffMax) }
+    return d/2 + rand.N(d/2+1)
+}
+
+// run delivers spooled results in order until ctx is done. A result is removed only
+// once the API answered 2xx, or refused it for good.
+func (s *spool) run(ctx context.Context, cfg AgentConfig) {
+    attempt := 0
+    for ctx.Err() == nil {
+        names, err := s.pending()
+        if err != nil { log.Printf("spool: %v", err) }
+        pending := 0
+        for i, name := range names {
+            path := filepath.Join(s.dir, name)
+            b, err := os.ReadFile(path)
+            if err != nil { log.Printf("spool: %v", err); continue }
+            err = postResult(ctx, cfg, b)
+            if err != nil && !errors.Is(err, errRejected) {
+                pending = len(names) - i
+                break
+            }
+            if err != nil { log.Printf("spool: dropping %s: %v", name, err) }
+            _ = os.Remove(path)
+            attempt = 0
+        }
+        // while backing off, new results wait for the retry too
+        wake, wait := s.wake, (<-chan time.Time)(nil)
+        if pending > 0 {
+            attempt++
+            d := backoff(attempt)
+            log.Printf("spool: %d result(s) pending, retry in %v", pending, d.Round(time.Second))
+            wake, wait = nil, time.After(d)
+        }
+        select {
+        case <-ctx.Done():
+        case <-wake:
+        case <-wait:
+        }
+    }
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestSpoolPut(t *testing.T) {
+    sp, err := openSpool(filepath.Join(t.TempDir(), "spool"))
+    if err != nil { t.Fatal(err) }
+    for i := 0; i < 2; i++ {
+        if err := sp.put(map[string]any{"n": i}); err != nil { t.Fatal(err) }
+    }
+    names, err := sp.pending()
+    if err != nil || len(names) != 2 { t.Fatalf("pending = %v, %v; want 2 results", names, err) }
+    if leftovers, _ := filepath.Glob(filepath.Join(sp.dir, "*.tmp")); len(leftovers) > 0 { t.Errorf("temp files left: %v", leftovers) }
+}
+
+func TestOpenSpoolUnusable(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "file")
+    if err := os.WriteFile(file, nil, 0o600); err != nil { t.Fatal(err) }
+    if _, err := openSpool(filepath.Join(file, "spool")); err == nil { t.Error("spool under a regular file opened") }
+}
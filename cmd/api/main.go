@@ -26,6 +26,10 @@ func main() {
         log.Fatalf("failed to init postgres: %v", err)
     }
     defer pg.Close()
+    // the handlers rely on the schema (unique result index among others): do not serve without it
+    if err := pg.EnsureSchema(); err != nil {
+        log.Fatalf("failed to ensure schema: %v", err)
+    }
 
     rds, err := queue.NewRedisClient(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
     if err != nil {
@@ -0,0 +1,159 @@
+package checker
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "aeza/internal/dnsclient"
+
+    "github.com/miekg/dns"
+)
+
+// blacklistOptions are the per-task parameters of the blacklist method.
+type blacklistOptions struct {
+    // Zones overrides the DNSBL zones configured on the agent (DNSBL_ZONES).
+    Zones     []string `json:"zones"`
+    TimeoutMs int      `json:"timeout_ms"`
+}
+
+// dnsblZones are queried when neither the task nor DNSBL_ZONES names any.
+var dnsblZones = []string{
+    "zen.spamhaus.org",
+    "b.barracudacentral.org",
+    "bl.spamcop.net",
+    "dnsbl.sorbs.net",
+    "psbl.surriel.com",
+    "bl.mailspike.net",
+    "dnsbl-1.uceprotect.net",
+}
+
+type dnsblResult struct {
+    IP     string   `json:"ip"`
+    Zone   string   `json:"zone"`
+    Listed bool     `json:"listed"`
+    Rcode  string   `json:"rcode,omitempty"`
+    // Codes are the 127.0.0.x return addresses; their meaning is list specific.
+    Codes     []string `json:"codes,omitempty"`
+    Reason    string   `json:"reason,omitempty"`
+    Error     string   `json:"error,omitempty"`
+    LatencyMs float64  `json:"latency_ms"`
+}
+
+// dnsblQueryName builds the reversed-octet (or reversed-nibble for IPv6) name of ip in zone.
+func dnsblQueryName(ip net.IP, zone string) string {
+    rev, err := dns.ReverseAddr(ip.String())
+    if err != nil { return "" }
+    rev = strings.TrimSuffix(strings.TrimSuffix(rev, "in-addr.arpa."), "ip6.arpa.")
+    return rev + dns.Fqdn(zone)
+}
+
+func dnsblLookup(ctx context.Context, client *dnsclient.Client, ip net.IP, zone string) dnsblResult {
+    r := dnsblResult{IP: ip.String(), Zone: zone}
+    name := dnsblQueryName(ip, zone)
+    start := time.Now()
+    resp, err := client.Lookup(ctx, name, dns.TypeA)
+    r.LatencyMs = durMs(time.Since(start))
+    if err != nil {
+        r.Error = err.Error()
+        return r
+    }
+    r.Rcode = dns.RcodeToString[resp.Rcode]
+    if resp.Rcode != dns.RcodeSuccess {
+        if resp.Rcode != dns.RcodeNameError { r.Error = "lookup returned " + r.Rcode }
+        return r
+    }
+    for _, rr := range resp.Answer {
+        if a, ok := rr.(*dns.A); ok { r.Codes = append(r.Codes, a.A.String()) }
+    }
+    if len(r.Codes) == 0 { return r }
+    // Spamhaus and others answer 127.255.255.x when they refuse the query (public
+    // resolver, rate limit); that says nothing about the IP.
+    for _, c := range r.Codes {
+        if !strings.HasPrefix(c, "127.255.255.") {
+            r.Listed = true
+            break
+        }
+    }
+    if !r.Listed {
+        r.Error = "query refused by the list (" + strings.Join(r.Codes, ", ") + "); the resolver may be blocked"
+        return r
+    }
+    if txt, err := client.Lookup(ctx, name, dns.TypeTXT); err == nil {
+        var reasons []string
+        for _, rr := range txt.Answer {
+            if t, ok := rr.(*dns.TXT); ok { reasons = append(reasons, strings.Join(t.Txt, "")) }
+        }
+        r.Reason = strings.Join(reasons, "; ")
+    }
+    return r
+}
+
+// blacklistCheck resolves the target and looks every address up in every DNSBL zone.
+// It fails when any address is listed.
+func blacklistCheck(ctx context.Context, target string, opts blacklistOptions) (ok bool, latency int64, msg string, details map[string]any) {
+    start := time.Now()
+    zones := opts.Zones
+    if len(zones) == 0 { zones = dnsblZones }
+    timeout := time.Duration(opts.TimeoutMs) * time.Millisecond
+    if timeout <= 0 { timeout = 3 * time.Second }
+    ctx, cancel := context.WithTimeout(ctx, checkTimeout(ctx, 60*time.Second))
+    defer cancel()
+
+    host := hostnameForDNS(target)
+    var ips []net.IP
+    if ip := net.ParseIP(host); ip != nil {
+        ips = []net.IP{ip}
+    } else {
+        addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+        if err != nil { return false, time.Since(start).Milliseconds(), err.Error(), nil }
+        for _, a := range addrs { ips = append(ips, a.IP) }
+    }
+
+    client := dnsclient.New(timeout)
+    results := make([]dnsblResult, 0, len(ips)*len(zones))
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, 16)
+    for _, ip := range ips {
+        for _, z := range zones {
+            z = strings.TrimSuffix(strings.TrimSpace(z), ".")
+            if z == "" { continue }
+            wg.Add(1)
+            sem <- struct{}{}
+            go func(ip net.IP, z string) {
+                defer func() { <-sem; wg.Done() }()
+                r := dnsblLookup(ctx, client, ip, z)
+                mu.Lock()
+                results = append(results, r)
+                mu.Unlock()
+            }(ip, z)
+        }
+    }
+    wg.Wait()
+    sort.Slice(results, func(i, j int) bool {
+        if results[i].IP != results[j].IP { return results[i].IP < results[j].IP }
+        return results[i].Zone < results[j].Zone
+    })
+
+    var listed []string
+    ipStrs := make([]string, 0, len(ips))
+    for _, ip := range ips { ipStrs = append(ipStrs, ip.String()) }
+    for _, r := range results {
+        if r.Listed { listed = append(listed, fmt.Sprintf("%s on %s (%s)", r.IP, r.Zone, strings.Join(r.Codes, ", "))) }
+    }
+    details = map[string]any{
+        "host":     host,
+        "ips":      ipStrs,
+        "zones":    zones,
+        "listed":   len(listed),
+        "results":  results,
+        "resolver": dnsclient.SystemServers()[0],
+    }
+    if len(listed) > 0 { msg = "listed: " + strings.Join(listed, "; ") }
+    return len(listed) == 0, time.Since(start).Milliseconds(), msg, details
+}
@@ -0,0 +1,86 @@
+package checker
+
+import (
+    "context"
+    "net"
+    "reflect"
+    "testing"
+    "time"
+
+    "aeza/internal/dnsclient"
+
+    "github.com/miekg/dns"
+)
+
+func TestDNSBLQueryName(t *testing.T) {
+    tests := []struct {
+        ip   string
+        zone string
+        want string
+    }{
+        {"192.0.2.1", "zen.spamhaus.org", "1.2.0.192.zen.spamhaus.org."},
+        {"198.51.100.200", "bl.spamcop.net.", "200.100.51.198.bl.spamcop.net."},
+        {"::ffff:192.0.2.1", "zen.spamhaus.org", "1.2.0.192.zen.spamhaus.org."},
+        {"2001:db8::1", "zen.spamhaus.org", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.zen.spamhaus.org."},
+        {"2a00:1450:4001:82a::200e", "psbl.surriel.com", "e.0.0.2.0.0.0.0.0.0.0.0.0.0.0.0.a.2.8.0.1.0.0.4.0.5.4.1.0.0.a.2.psbl.surriel.com."},
+    }
+    for _, tt := range tests {
+        if got := dnsblQueryName(net.ParseIP(tt.ip), tt.zone); got != tt.want { t.Errorf("dnsblQueryName(%s, %s) = %s, want %s", tt.ip, tt.zone, got, tt.want) }
+    }
+}
+
+func TestDNSBLLookup(t *testing.T) {
+    const name = "2.0.0.127.bl.example.test."
+    tests := []struct {
+        name    string
+        records []string
+        fail    map[string]int
+        rcode   string
+        listed  bool
+        codes   []string
+        reason  string
+        err     string
+    }{
+        {name: "not listed", rcode: "NXDOMAIN"},
+        {
+            name:    "listed",
+            records: []string{name + " 300 IN A 127.0.0.2", name + ` 300 IN TXT "https://bl.example.test/query/" "127.0.0.2"`},
+            rcode:   "NOERROR",
+            listed:  true,
+            codes:   []string{"127.0.0.2"},
+            reason:  "https://bl.example.test/query/127.0.0.2",
+        },
+        {
+            name:    "query refused",
+            records: []string{name + " 300 IN A 127.255.255.254"},
+            rcode:   "NOERROR",
+            codes:   []string{"127.255.255.254"},
+            err:     "query refused by the list (127.255.255.254); the resolver may be blocked",
+        },
+        {
+            name:    "listed next to a refusal code",
+            records: []string{name + " 300 IN A 127.255.255.252", name + " 300 IN A 127.0.0.4"},
+            rcode:   "NOERROR",
+            listed:  true,
+            codes:   []string{"127.255.255.252", "127.0.0.4"},
+        },
+        {
+            name:    "servfail",
+            records: []string{name + " 300 IN A 127.0.0.2"},
+            fail:    map[string]int{"A": dns.RcodeServerFailure},
+            rcode:   "SERVFAIL",
+            err:     "lookup returned SERVFAIL",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := newFakeResolver(t, tt.records...)
+            if tt.fail != nil { r.fail = tt.fail }
+            client := &dnsclient.Client{Timeout: time.Second, Servers: []string{r.serve(t)}}
+            got := dnsblLookup(context.Background(), client, net.IPv4(127, 0, 0, 2), "bl.example.test")
+            if got.Rcode != tt.rcode || got.Listed != tt.listed || !reflect.DeepEqual(got.Codes, tt.codes) || got.Reason != tt.reason || got.Error != tt.err {
+                t.Errorf("dnsblLookup = %+v, want rcode %s, listed %v, codes %q, reason %q, error %q", got, tt.rcode, tt.listed, tt.codes, tt.reason, tt.err)
+            }
+        })
+    }
+}
@@ -0,0 +1,175 @@
+package checker
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "reflect"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Result is the outcome of one check, as posted to /api/results.
+type Result struct {
+    Success    bool   `json:"success"`
+    LatencyMs  int64  `json:"latency_ms"`
+    StatusCode int    `json:"status_code"`
+    Message    string `json:"message"`
+    Details    any    `json:"details"`
+}
+
+// Param describes one field of a method's options object.
+type Param struct {
+    Name string `json:"name"`
+    // Type is the JSON type: string, integer, number, boolean, array or object.
+    Type string `json:"type"`
+}
+
+// Checker is one check method.
+type Checker interface {
+    // Name is the method name used in requests, jobs and results.
+    Name() string
+    // Params describes the options the method accepts.
+    Params() []Param
+    // Run checks target; params is the raw JSON options object and may be empty.
+    Run(ctx context.Context, target string, params json.RawMessage) Result
+}
+
+// registry holds every known method; the agent dispatches jobs through it and the
+// API accepts only the methods it contains.
+var (
+    mu       sync.RWMutex
+    registry = map[string]Checker{}
+)
+
+// Register adds a method to the registry. Registering a name twice panics, like
+// registering the same route twice would.
+func Register(c Checker) {
+    mu.Lock()
+    defer mu.Unlock()
+    name := strings.ToLower(c.Name())
+    if _, dup := registry[name]; dup { panic("checker: method registered twice: " + name) }
+    registry[name] = c
+}
+
+// Lookup returns the method registered under name.
+func Lookup(name string) (Checker, bool) {
+    mu.RLock()
+    defer mu.RUnlock()
+    c, ok := registry[strings.ToLower(name)]
+    return c, ok
+}
+
+// All returns the registered methods sorted by name.
+func All() []Checker {
+    mu.RLock()
+    defer mu.RUnlock()
+    out := make([]Checker, 0, len(registry))
+    for _, c := range registry { out = append(out, c) }
+    sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+    return out
+}
+
+// funcChecker adapts a check function with typed options to Checker.
+type funcChecker[O any] struct {
+    name string
+    run  func(ctx context.Context, target string, opts O) Result
+}
+
+// Func builds a Checker from a function taking its options as a struct; the options
+// are decoded from the job and described by ParamsOf.
+func Func[O any](name string, run func(ctx context.Context, target string, opts O) Result) Checker {
+    return funcChecker[O]{name: name, run: run}
+}
+
+func (f funcChecker[O]) Name() string { return f.name }
+
+func (f funcChecker[O]) Params() []Param {
+    var zero O
+    return ParamsOf(zero)
+}
+
+func (f funcChecker[O]) Run(ctx context.Context, target string, params json.RawMessage) Result {
+    var opts O
+    if len(params) > 0 {
+        if err := json.Unmarshal(params, &opts); err != nil {
+            return Result{Message: fmt.Sprintf("invalid %s options: %v", f.name, err)}
+        }
+    }
+    res := f.run(ctx, target, opts)
+    // a check cut short fails with whatever error its closed socket gave; name the reason
+    if err := ctx.Err(); err != nil && !res.Success {
+        res.Message = "cancelled"
+        if errors.Is(err, context.DeadlineExceeded) { res.Message = "timed out" }
+    }
+    return res
+}
+
+type timeoutKey struct{}
+
+// WithTimeout bounds ctx by d and makes d the check's overall timeout in place of its
+// built-in default (10s for http and tls, for example). A plain deadline on ctx, like
+// the job's, only cuts a check short.
+func WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+    return context.WithTimeout(context.WithValue(ctx, timeoutKey{}, d), d)
+}
+
+// checkTimeout is how long a check may take overall: the timeout set with WithTimeout,
+// or def. An earlier deadline on ctx still applies through the context itself.
+func checkTimeout(ctx context.Context, def time.Duration) time.Duration {
+    if d, ok := ctx.Value(timeoutKey{}).(time.Duration); ok { return d }
+    return def
+}
+
+// timeoutMs resolves a timeout_ms option: an explicit value wins, otherwise checkTimeout(ctx, def).
+func timeoutMs(ctx context.Context, ms int, def time.Duration) int {
+    if ms > 0 { return ms }
+    return int(checkTimeout(ctx, def).Milliseconds())
+}
+
+// sleepCtx waits for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) {
+    t := time.NewTimer(d)
+    defer t.Stop()
+    select {
+    case <-t.C:
+    case <-ctx.Done():
+    }
+}
+
+// ParamsOf lists the JSON fields of an options struct.
+func ParamsOf(v any) []Param {
+    t := reflect.TypeOf(v)
+    for t != nil && t.Kind() == reflect.Pointer { t = t.Elem() }
+    if t == nil || t.Kind() != reflect.Struct { return []Param{} }
+    out := []Param{}
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+        if !f.IsExported() || name == "-" { continue }
+        if name == "" { name = f.Name }
+        out = append(out, Param{Name: name, Type: jsonType(f.Type)})
+    }
+    return out
+}
+
+func jsonType(t reflect.Type) string {
+    for t.Kind() == reflect.Pointer { t = t.Elem() }
+    switch t.Kind() {
+    case reflect.String:
+        return "string"
+    case reflect.Bool:
+        return "boolean"
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return "integer"
+    case reflect.Float32, reflect.Float64:
+        return "number"
+    case reflect.Slice, reflect.Array:
+        return "array"
+    }
+    return "object"
+}
@@ -0,0 +1,600 @@
+package checker
+
+import (
+    "bufio"
+    "context"
+    "crypto/tls"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "net"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// dbOptions are the per-task parameters of the postgres, mysql, redis and mongodb methods.
+type dbOptions struct {
+    Port int `json:"port"`
+    // TLS is "auto" (default), "tls" or "none". PostgreSQL and MySQL negotiate TLS in
+    // band; Redis and MongoDB use it from the first byte, so "auto" retries over TLS
+    // when the plain connection does not answer.
+    TLS string `json:"tls"`
+    // RequireTLS fails the check when the server cannot be reached over TLS.
+    RequireTLS bool `json:"require_tls"`
+    // User and Database go into the PostgreSQL startup message; no password is ever sent.
+    User      string `json:"user"`
+    Database  string `json:"database"`
+    TimeoutMs int    `json:"timeout_ms"`
+}
+
+// dbSession is the state shared by the stages of one database probe.
+type dbSession struct {
+    ctx        context.Context
+    host, addr string
+    opts       dbOptions
+    deadline   time.Time
+    timings    map[string]float64
+    details    map[string]any
+    warnings   []string
+    // dialContext replaces the TCP dialer when set (tests serve the protocol in memory)
+    dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// ctxConn is a connection closed when its context is done. Close also releases the
+// context.AfterFunc registration, so probes that dial several times do not pile them up.
+type ctxConn struct {
+    net.Conn
+    stop func() bool
+}
+
+func (c *ctxConn) Close() error {
+    c.stop()
+    return c.Conn.Close()
+}
+
+func (s *dbSession) dial() (net.Conn, error) {
+    stage := time.Now()
+    dial := s.dialContext
+    if dial == nil { d := net.Dialer{Deadline: s.deadline}; dial = d.DialContext }
+    conn, err := dial(s.ctx, "tcp", s.addr)
+    if err != nil { return nil, err }
+    _ = conn.SetDeadline(s.deadline)
+    stop := context.AfterFunc(s.ctx, func() { _ = conn.Close() })
+    if _, done := s.timings["connect_ms"]; !done {
+        s.timings["connect_ms"] = durMs(time.Since(stage))
+        s.details["remote_addr"] = conn.RemoteAddr().String()
+    }
+    return &ctxConn{Conn: conn, stop: stop}, nil
+}
+
+func (s *dbSession) startTLS(conn net.Conn) (net.Conn, error) {
+    stage := time.Now()
+    tc := tls.Client(conn, &tls.Config{ServerName: s.host, InsecureSkipVerify: true})
+    if err := tc.Handshake(); err != nil { return nil, fmt.Errorf("TLS handshake: %w", err) }
+    s.timings["tls_ms"] = durMs(time.Since(stage))
+    tlsDet, tlsMsg := describeTLS(tc.ConnectionState(), s.host)
+    s.details["tls"] = tlsDet
+    if tlsMsg != "" { s.warnings = append(s.warnings, "TLS: "+tlsMsg) }
+    return tc, nil
+}
+
+// dialMaybeTLS connects for protocols that have no in-band TLS upgrade: plain first,
+// then over TLS when the plain attempt gets no protocol answer.
+func (s *dbSession) dialMaybeTLS(talk func(net.Conn) error) error {
+    mode := strings.ToLower(s.opts.TLS)
+    var plainErr error
+    if mode != "tls" {
+        conn, err := s.dial()
+        if err != nil { return err }
+        plainErr = talk(conn)
+        _ = conn.Close()
+        if plainErr == nil {
+            s.details["plaintext"] = true
+            return nil
+        }
+        if mode == "none" || s.details["speaks_protocol"] == true { return plainErr }
+    }
+    conn, err := s.dial()
+    if err != nil { return err }
+    defer func() { _ = conn.Close() }()
+    tc, err := s.startTLS(conn)
+    if err != nil {
+        // neither way worked; what the plain connection got is the more telling error
+        if plainErr != nil { return plainErr }
+        return err
+    }
+    s.details["tls_supported"] = true
+    return talk(tc)
+}
+
+type dbProtocol struct {
+    name  string
+    port  int
+    probe func(s *dbSession) (string, error)
+}
+
+var dbProtocols = map[string]dbProtocol{
+    "postgres": {"PostgreSQL", 5432, postgresProbe},
+    "mysql":    {"MySQL", 3306, mysqlProbe},
+    "redis":    {"Redis", 6379, redisProbe},
+    "mongodb":  {"MongoDB", 27017, mongoProbe},
+}
+
+// dbCheck checks that a database port really speaks the database protocol and reports
+// its version, TLS support and whether it answers without authentication. It never
+// sends credentials.
+func dbCheck(ctx context.Context, method, target string, opts dbOptions) (ok bool, latency int64, msg string, details map[string]any) {
+    proto, found := dbProtocols[method]
+    if !found { return false, 0, "unsupported database " + method, nil }
+    opts.TimeoutMs = timeoutMs(ctx, opts.TimeoutMs, 10*time.Second)
+    host, _, _ := net.SplitHostPort(tcpAddress(target))
+    port := opts.Port
+    if port == 0 { port, _ = strconv.Atoi(explicitPort(target)) }
+    if port == 0 { port = proto.port }
+
+    start := time.Now()
+    s := &dbSession{
+        ctx: ctx, host: host, addr: net.JoinHostPort(host, strconv.Itoa(port)), opts: opts,
+        deadline: start.Add(time.Duration(opts.TimeoutMs) * time.Millisecond),
+        timings:  map[string]float64{},
+        warnings: []string{},
+    }
+    s.details = map[string]any{"host": host, "port": port, "protocol": proto.name, "speaks_protocol": false, "timings": s.timings}
+    summary, err := proto.probe(s)
+    s.timings["total_ms"] = durMs(time.Since(start))
+    latency = time.Since(start).Milliseconds()
+
+    if s.details["plaintext"] == true && s.details["tls_supported"] != true { s.warnings = append(s.warnings, "reachable without TLS") }
+    if s.details["auth_required"] == false { s.warnings = append(s.warnings, "no authentication required") }
+    s.details["warnings"] = s.warnings
+    if err != nil {
+        if s.details["speaks_protocol"] != true { return false, latency, err.Error(), s.details }
+        // the server spoke the protocol and refused us, which is still a working endpoint
+        s.details["error"] = err.Error()
+        if summary == "" { summary = proto.name + ": " + err.Error() }
+    }
+    if opts.RequireTLS && s.details["tls_supported"] != true { return false, latency, "TLS is not supported", s.details }
+    msg = summary
+    if len(s.warnings) > 0 { msg += "; " + strings.Join(s.warnings, "; ") }
+    return true, latency, msg, s.details
+}
+
+// ---- PostgreSQL ----
+
+// postgresProbe sends an SSLRequest, then a startup message over TLS (when offered)
+// and over a plain connection, and reads which authentication the server asks for.
+func postgresProbe(s *dbSession) (string, error) {
+    conn, err := s.dial()
+    if err != nil { return "", err }
+    defer func() { _ = conn.Close() }()
+    mode := strings.ToLower(s.opts.TLS)
+
+    var tlsOffered bool
+    if mode != "none" {
+        req := make([]byte, 8)
+        binary.BigEndian.PutUint32(req, 8)
+        binary.BigEndian.PutUint32(req[4:], 80877103)
+        if _, err := conn.Write(req); err != nil { return "", err }
+        b := make([]byte, 1)
+        if _, err := io.ReadFull(conn, b); err != nil { return "", fmt.Errorf("SSLRequest: %w", err) }
+        switch b[0] {
+        case 'S':
+            tlsOffered = true
+        case 'N':
+        default:
+            return "", fmt.Errorf("not a PostgreSQL server (SSLRequest answered %q)", b[0])
+        }
+        s.details["speaks_protocol"] = true
+        s.details["tls_supported"] = tlsOffered
+    }
+
+    var auth string
+    if tlsOffered {
+        tc, err := s.startTLS(conn)
+        if err != nil { return "", err }
+        auth, err = s.postgresStartup(tc)
+        if err != nil { return "", err }
+        // ask again without TLS: the answer tells whether plaintext logins are possible
+        plain, err := s.dial()
+        if err == nil {
+            plainAuth, err := s.postgresStartup(plain)
+            _ = plain.Close()
+            if err == nil {
+                s.details["plaintext"] = true
+                s.details["plaintext_auth"] = plainAuth
+                s.warnings = append(s.warnings, "plaintext logins accepted")
+            } else {
+                s.details["plaintext"] = false
+                s.details["plaintext_error"] = err.Error()
+            }
+        }
+    } else {
+        auth, err = s.postgresStartup(conn)
+        if err != nil { return "", err }
+        s.details["plaintext"] = true
+    }
+    s.details["auth"] = auth
+    s.details["auth_required"] = auth != "trust"
+    summary := "PostgreSQL"
+    if v, _ := s.details["version"].(string); v != "" { summary += " " + v }
+    return summary + ", auth " + auth, nil
+}
+
+// postgresStartup sends a protocol 3.0 startup message and returns the requested
+// authentication method, or the server's error. With trust authentication the
+// reported server parameters (version among them) are recorded as well.
+func (s *dbSession) postgresStartup(conn net.Conn) (string, error) {
+    user := s.opts.User
+    if user == "" { user = "postgres" }
+    db := s.opts.Database
+    if db == "" { db = user }
+    body := []byte{0, 3, 0, 0}
+    for _, kv := range []string{"user", user, "database", db, "application_name", "syharik-check"} { body = append(append(body, kv...), 0) }
+    body = append(body, 0)
+    msg := binary.BigEndian.AppendUint32(nil, uint32(len(body)+4))
+    if _, err := conn.Write(append(msg, body...)); err != nil { return "", err }
+
+    r := bufio.NewReader(conn)
+    auth := ""
+    for {
+        hdr := make([]byte, 5)
+        if _, err := io.ReadFull(r, hdr); err != nil { return "", fmt.Errorf("startup: %w", err) }
+        n := binary.BigEndian.Uint32(hdr[1:])
+        if n < 4 || n > 1<<20 { return "", fmt.Errorf("startup: bad message length %d", n) }
+        payload := make([]byte, n-4)
+        if _, err := io.ReadFull(r, payload); err != nil { return "", fmt.Errorf("startup: %w", err) }
+        s.details["speaks_protocol"] = true
+        switch hdr[0] {
+        case 'R':
+            if len(payload) < 4 { return "", errors.New("startup: short authentication request") }
+            switch code := binary.BigEndian.Uint32(payload); code {
+            case 0:
+                auth = "trust"
+                continue // parameters follow until ReadyForQuery
+            case 3:
+                return "password", nil
+            case 5:
+                return "md5", nil
+            case 7:
+                return "gss", nil
+            case 9:
+                return "sspi", nil
+            case 10:
+                mechs := strings.Split(strings.Trim(string(payload[4:]), "\x00"), "\x00")
+                return "SASL " + strings.Join(mechs, ","), nil
+            default:
+                return fmt.Sprintf("method %d", code), nil
+            }
+        case 'E':
+            return "", errors.New(postgresError(payload))
+        case 'S':
+            if kv := strings.SplitN(strings.TrimRight(string(payload), "\x00"), "\x00", 2); len(kv) == 2 && kv[0] == "server_version" { s.details["version"] = kv[1] }
+        case 'Z':
+            _, _ = conn.Write([]byte{'X', 0, 0, 0, 4})
+            return auth, nil
+        }
+    }
+}
+
+// postgresError formats an ErrorResponse as "SEVERITY CODE: message".
+func postgresError(payload []byte) string {
+    fields := map[byte]string{}
+    for _, f := range strings.Split(string(payload), "\x00") {
+        if len(f) > 1 { fields[f[0]] = f[1:] }
+    }
+    return fmt.Sprintf("%s %s: %s", fields['S'], fields['C'], fields['M'])
+}
+
+// ---- MySQL ----
+
+const (
+    mysqlClientSSL        = 0x800
+    mysqlClientProtocol41 = 0x200
+)
+
+// mysqlProbe reads the initial handshake packet and, when the server supports it,
+// upgrades the connection to TLS the way a client would before authenticating.
+func mysqlProbe(s *dbSession) (string, error) {
+    conn, err := s.dial()
+    if err != nil { return "", err }
+    defer func() { _ = conn.Close() }()
+
+    hdr := make([]byte, 4)
+    if _, err := io.ReadFull(conn, hdr); err != nil { return "", fmt.Errorf("handshake: %w", err) }
+    n := int(hdr[0]) | int(hdr[1])<<8 | int(hdr[2])<<16
+    if n == 0 || n > 1<<16 { return "", errors.New("not a MySQL server (bad handshake length)") }
+    p := make([]byte, n)
+    if _, err := io.ReadFull(conn, p); err != nil { return "", fmt.Errorf("handshake: %w", err) }
+
+    if p[0] == 0xff {
+        // the server refuses this client before the handshake, e.g. a host not allowed to connect
+        if len(p) < 3 { return "", errors.New("not a MySQL server") }
+        s.details["speaks_protocol"] = true
+        text := string(p[3:])
+        if strings.HasPrefix(text, "#") && len(text) > 6 { text = text[6:] }
+        return "", fmt.Errorf("error %d: %s", binary.LittleEndian.Uint16(p[1:]), text)
+    }
+    if p[0] != 10 && p[0] != 9 { return "", fmt.Errorf("not a MySQL server (protocol %d)", p[0]) }
+    end := strings.IndexByte(string(p[1:]), 0)
+    if end < 0 { return "", errors.New("not a MySQL server (no version)") }
+    s.details["speaks_protocol"] = true
+    s.details["protocol_version"] = int(p[0])
+    version := string(p[1 : 1+end])
+    // MariaDB prefixes its version with 5.5.5- for old replication clients
+    version = strings.TrimPrefix(version, "5.5.5-")
+    s.details["version"] = version
+    s.details["plaintext"] = true
+
+    rest := p[1+end+1:]
+    var caps uint32
+    if len(rest) >= 4+8+1+2 {
+        caps = uint32(binary.LittleEndian.Uint16(rest[13:]))
+        if len(rest) >= 4+8+1+2+1+2+2+1+10 {
+            caps |= uint32(binary.LittleEndian.Uint16(rest[18:])) << 16
+            authLen := int(rest[20])
+            tail := rest[31:]
+            skip := authLen - 8
+            if skip < 13 { skip = 13 }
+            if len(tail) > skip {
+                if plugin := strings.TrimRight(string(tail[skip:]), "\x00"); plugin != "" { s.details["auth"] = plugin }
+            }
+        }
+    }
+    tlsSupported := caps&mysqlClientSSL != 0
+    s.details["tls_supported"] = tlsSupported
+
+    if tlsSupported && strings.ToLower(s.opts.TLS) != "none" {
+        // SSLRequest: capability flags, max packet size, charset, 23 zero bytes
+        req := make([]byte, 4+32)
+        req[0], req[3] = 32, 1
+        binary.LittleEndian.PutUint32(req[4:], mysqlClientSSL|mysqlClientProtocol41)
+        binary.LittleEndian.PutUint32(req[8:], 1<<24)
+        req[12] = 45 // utf8mb4_general_ci
+        if _, err := conn.Write(req); err != nil { return "", err }
+        if _, err := s.startTLS(conn); err != nil { return "", err }
+    }
+    summary := "MySQL " + version
+    if strings.Contains(strings.ToLower(version), "mariadb") {
+        v, _, _ := strings.Cut(version, "-")
+        summary = "MariaDB " + v
+    }
+    if a, _ := s.details["auth"].(string); a != "" { summary += ", auth " + a }
+    return summary, nil
+}
+
+// ---- Redis ----
+
+func redisReadLine(r *bufio.Reader) (string, error) {
+    line, err := r.ReadString('\n')
+    if err != nil { return "", err }
+    return strings.TrimRight(line, "\r\n"), nil
+}
+
+// redisProbe sends PING; a +PONG means the server answers without authentication,
+// in which case INFO server gives the version.
+func redisProbe(s *dbSession) (string, error) {
+    var summary string
+    err := s.dialMaybeTLS(func(conn net.Conn) error {
+        r := bufio.NewReader(conn)
+        if _, err := conn.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil { return err }
+        line, err := redisReadLine(r)
+        if err != nil { return fmt.Errorf("PING: %w", err) }
+        if line == "" || !strings.ContainsRune("+-", rune(line[0])) { return fmt.Errorf("not a Redis server (PING answered %q)", truncate(line, 64)) }
+        s.details["speaks_protocol"] = true
+        s.details["ping"] = line
+        switch {
+        case line == "+PONG":
+            s.details["auth_required"] = false
+        case strings.HasPrefix(line, "-NOAUTH"), strings.HasPrefix(line, "-WRONGPASS"):
+            s.details["auth_required"] = true
+            summary = "Redis, auth required"
+            return nil
+        case strings.HasPrefix(line, "-DENIED"):
+            // protected mode: no password set, but only loopback clients are served
+            s.details["auth_required"] = true
+            s.details["protected_mode"] = true
+            summary = "Redis, protected mode"
+            return nil
+        default:
+            summary = "Redis, PING: " + strings.TrimPrefix(line, "-")
+            return nil
+        }
+
+        if _, err := conn.Write([]byte("*2\r\n$4\r\nINFO\r\n$6\r\nserver\r\n")); err != nil { return err }
+        head, err := redisReadLine(r)
+        if err != nil { return fmt.Errorf("INFO: %w", err) }
+        summary = "Redis"
+        if n, perr := strconv.Atoi(strings.TrimPrefix(head, "$")); perr == nil && strings.HasPrefix(head, "$") && n > 0 && n < 1<<20 {
+            buf := make([]byte, n+2)
+            if _, err := io.ReadFull(r, buf); err != nil { return fmt.Errorf("INFO: %w", err) }
+            for _, l := range strings.Split(string(buf), "\r\n") {
+                k, v, found := strings.Cut(l, ":")
+                if !found { continue }
+                switch k {
+                case "redis_version":
+                    s.details["version"] = v
+                    summary += " " + v
+                case "redis_mode", "os":
+                    s.details[k] = v
+                }
+            }
+        }
+        return nil
+    })
+    return summary, err
+}
+
+func truncate(s string, n int) string {
+    if len(s) > n { return s[:n] + "…" }
+    return s
+}
+
+// ---- MongoDB ----
+
+// mongoCommand runs one command through OP_MSG (MongoDB 3.6+) and returns the reply document.
+func mongoCommand(conn net.Conn, id int32, cmd []byte) (map[string]any, error) {
+    msg := make([]byte, 16+4+1, 16+4+1+len(cmd))
+    binary.LittleEndian.PutUint32(msg[4:], uint32(id))
+    binary.LittleEndian.PutUint32(msg[12:], 2013) // OP_MSG
+    msg = append(msg, cmd...)
+    binary.LittleEndian.PutUint32(msg, uint32(len(msg)))
+    if _, err := conn.Write(msg); err != nil { return nil, err }
+
+    hdr := make([]byte, 16)
+    if _, err := io.ReadFull(conn, hdr); err != nil { return nil, err }
+    n := binary.LittleEndian.Uint32(hdr)
+    if n < 16+5 || n > 48<<20 || binary.LittleEndian.Uint32(hdr[12:]) != 2013 { return nil, errors.New("not a MongoDB reply") }
+    body := make([]byte, n-16)
+    if _, err := io.ReadFull(conn, body); err != nil { return nil, err }
+    if body[4] != 0 { return nil, errors.New("unexpected OP_MSG section") }
+    doc, _, err := bsonDecode(body[5:], 0)
+    return doc, err
+}
+
+// mongoProbe runs hello, buildInfo and listDatabases; the last one succeeds only
+// when the server does not require authentication.
+func mongoProbe(s *dbSession) (string, error) {
+    var summary string
+    err := s.dialMaybeTLS(func(conn net.Conn) error {
+        hello, err := mongoCommand(conn, 1, bsonDoc("hello", int32(1), "$db", "admin"))
+        if err == nil && hello["ok"] != float64(1) {
+            // servers before 4.4.2 only know isMaster
+            hello, err = mongoCommand(conn, 2, bsonDoc("isMaster", int32(1), "$db", "admin"))
+        }
+        if err != nil { return fmt.Errorf("hello: %w", err) }
+        s.details["speaks_protocol"] = true
+        h := map[string]any{}
+        for _, k := range []string{"isWritablePrimary", "ismaster", "secondary", "setName", "msg", "maxWireVersion", "minWireVersion"} {
+            if v, found := hello[k]; found { h[k] = v }
+        }
+        s.details["hello"] = h
+        summary = "MongoDB"
+        if info, err := mongoCommand(conn, 3, bsonDoc("buildInfo", int32(1), "$db", "admin")); err == nil {
+            if v, _ := info["version"].(string); v != "" {
+                s.details["version"] = v
+                summary += " " + v
+            }
+        }
+        if dbs, err := mongoCommand(conn, 4, bsonDoc("listDatabases", int32(1), "nameOnly", true, "$db", "admin")); err == nil {
+            s.details["auth_required"] = dbs["ok"] != float64(1)
+            if dbs["ok"] != float64(1) {
+                summary += ", auth required"
+            } else if list, ok := dbs["databases"].([]any); ok {
+                s.details["databases"] = len(list)
+            }
+        }
+        if h["msg"] == "isdbgrid" { summary += " (mongos)" } else if set, _ := h["setName"].(string); set != "" { summary += ", replica set " + set }
+        return nil
+    })
+    return summary, err
+}
+
+// bsonDoc encodes key/value pairs (string, int32, bool) as a BSON document.
+func bsonDoc(kv ...any) []byte {
+    out := []byte{0, 0, 0, 0}
+    for i := 0; i+1 < len(kv); i += 2 {
+        key := kv[i].(string)
+        switch v := kv[i+1].(type) {
+        case string:
+            out = append(append(append(out, 0x02), key...), 0)
+            out = binary.LittleEndian.AppendUint32(out, uint32(len(v)+1))
+            out = append(append(out, v...), 0)
+        case int32:
+            out = append(append(append(out, 0x10), key...), 0)
+            out = binary.LittleEndian.AppendUint32(out, uint32(v))
+        case bool:
+            b := byte(0)
+            if v { b = 1 }
+            out = append(append(append(out, 0x08), key...), 0, b)
+        }
+    }
+    out = append(out, 0)
+    binary.LittleEndian.PutUint32(out, uint32(len(out)))
+    return out
+}
+
+// bsonMaxDepth bounds document nesting, as MongoDB itself does, so that a hostile
+// reply cannot recurse the decoder into a stack overflow.
+const bsonMaxDepth = 100
+
+// bsonDecode decodes the BSON types a server reply can contain, depth being the nesting
+// level of b. Numbers become float64 (as with JSON), arrays []any; opaque values are left out.
+func bsonDecode(b []byte, depth int) (map[string]any, int, error) {
+    if depth > bsonMaxDepth { return nil, 0, errors.New("BSON document nested too deeply") }
+    errShort := errors.New("truncated BSON document")
+    if len(b) < 5 { return nil, 0, errShort }
+    size := int(binary.LittleEndian.Uint32(b))
+    if size < 5 || size > len(b) { return nil, 0, errShort }
+    doc := map[string]any{}
+    p := b[4 : size-1]
+    for len(p) > 0 {
+        typ := p[0]
+        end := strings.IndexByte(string(p[1:]), 0)
+        if end < 0 { return nil, 0, errShort }
+        key := string(p[1 : 1+end])
+        p = p[2+end:]
+        need := func(n int) bool { return n >= 0 && len(p) >= n }
+        var v any
+        var n int
+        switch typ {
+        case 0x01: // double
+            if !need(8) { return nil, 0, errShort }
+            v, n = math.Float64frombits(binary.LittleEndian.Uint64(p)), 8
+        case 0x02, 0x0D, 0x0E: // string, JavaScript code, symbol
+            if !need(4) { return nil, 0, errShort }
+            l := int(binary.LittleEndian.Uint32(p))
+            if l < 1 || !need(4+l) { return nil, 0, errShort }
+            v, n = string(p[4:4+l-1]), 4+l
+        case 0x03, 0x04: // document, array
+            sub, l, err := bsonDecode(p, depth+1)
+            if err != nil { return nil, 0, err }
+            v, n = sub, l
+            if typ == 0x04 {
+                arr := make([]any, 0, len(sub))
+                for i := 0; ; i++ {
+                    e, found := sub[strconv.Itoa(i)]
+                    if !found { break }
+                    arr = append(arr, e)
+                }
+                v = arr
+            }
+        case 0x05: // binary
+            if !need(5) { return nil, 0, errShort }
+            n = 5 + int(binary.LittleEndian.Uint32(p))
+        case 0x07: // ObjectId
+            n = 12
+        case 0x08: // bool
+            if !need(1) { return nil, 0, errShort }
+            v, n = p[0] == 1, 1
+        case 0x09: // UTC datetime
+            if !need(8) { return nil, 0, errShort }
+            v, n = time.UnixMilli(int64(binary.LittleEndian.Uint64(p))).UTC(), 8
+        case 0x0A, 0x06, 0x7F, 0xFF: // null, undefined, max/min key
+            n = 0
+        case 0x0B: // regex: two cstrings
+            i := strings.IndexByte(string(p), 0)
+            j := -1
+            if i >= 0 { j = strings.IndexByte(string(p[i+1:]), 0) }
+            if j < 0 { return nil, 0, errShort }
+            n = i + 1 + j + 1
+        case 0x10: // int32
+            if !need(4) { return nil, 0, errShort }
+            v, n = float64(int32(binary.LittleEndian.Uint32(p))), 4
+        case 0x11, 0x12: // timestamp, int64
+            if !need(8) { return nil, 0, errShort }
+            v, n = float64(int64(binary.LittleEndian.Uint64(p))), 8
+            if typ == 0x11 { v = nil }
+        case 0x13: // decimal128
+            n = 16
+        default:
+            return nil, 0, fmt.Errorf("unsupported BSON type 0x%02x", typ)
+        }
+        if !need(n) { return nil, 0, errShort }
+        if v != nil { doc[key] = v }
+        p = p[n:]
+    }
+    return doc, size, nil
+}
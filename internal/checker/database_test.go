@@ -0,0 +1,382 @@
+package checker
+
+import (
+    "bufio"
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/binary"
+    "io"
+    "math/big"
+    "net"
+    "strconv"
+    "testing"
+    "time"
+)
+
+// testCertificate is a throwaway self-signed certificate for fake TLS servers.
+func testCertificate(t *testing.T) tls.Certificate {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil { t.Fatal(err) }
+    tmpl := &x509.Certificate{
+        SerialNumber: big.NewInt(1),
+        Subject:      pkix.Name{CommonName: "db.test"},
+        DNSNames:     []string{"db.test"},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil { t.Fatal(err) }
+    return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// expect reads exactly want from c, failing the test on anything else.
+func expect(t *testing.T, r io.Reader, want string) bool {
+    got := make([]byte, len(want))
+    if _, err := io.ReadFull(r, got); err != nil || string(got) != want {
+        t.Errorf("server read %q (%v), want %q", got, err, want)
+        return false
+    }
+    return true
+}
+
+// pipeSession is a database session whose connections are served in memory by serve,
+// called with the number of the connection (from 0).
+func pipeSession(t *testing.T, opts dbOptions, serve func(n int, c net.Conn)) *dbSession {
+    s := &dbSession{
+        ctx: context.Background(), host: "db.test", addr: "db.test:1", opts: opts,
+        deadline: time.Now().Add(5 * time.Second),
+        timings:  map[string]float64{},
+        warnings: []string{},
+    }
+    s.details = map[string]any{"speaks_protocol": false}
+    dials := 0
+    s.dialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+        client, server := net.Pipe()
+        _ = server.SetDeadline(s.deadline)
+        go func(n int) {
+            defer server.Close()
+            serve(n, server)
+        }(dials)
+        dials++
+        return client, nil
+    }
+    return s
+}
+
+// ---- BSON ----
+
+func bsonElem(typ byte, key string, value []byte) []byte {
+    return append(append(append([]byte{typ}, key...), 0), value...)
+}
+
+func bsonWrap(elems ...[]byte) []byte {
+    out := []byte{0, 0, 0, 0}
+    for _, e := range elems { out = append(out, e...) }
+    out = append(out, 0)
+    binary.LittleEndian.PutUint32(out, uint32(len(out)))
+    return out
+}
+
+func bsonInt32(v int32) []byte { return binary.LittleEndian.AppendUint32(nil, uint32(v)) }
+
+func TestBSONDecode(t *testing.T) {
+    nested := bsonWrap(
+        bsonElem(0x04, "databases", bsonWrap(
+            bsonElem(0x03, "0", bsonWrap(bsonElem(0x02, "name", append(bsonInt32(6), "admin\x00"...)))),
+            bsonElem(0x04, "1", bsonWrap(bsonElem(0x10, "0", bsonInt32(1)), bsonElem(0x08, "1", []byte{1}))),
+        )),
+        bsonElem(0x01, "ok", binary.LittleEndian.AppendUint64(nil, 0x3ff0000000000000)),
+    )
+    longString := bsonWrap(bsonElem(0x02, "s", append(bsonInt32(100), "abc\x00"...)))
+    longBinary := bsonWrap(bsonElem(0x05, "b", append(bsonInt32(1000), 0, 1, 2)))
+    // the inner document claims more than the outer one holds
+    longInner := bsonWrap(bsonElem(0x03, "d", append(bsonInt32(64), 0)))
+    oversized := bsonWrap(bsonElem(0x10, "n", bsonInt32(1)))
+    binary.LittleEndian.PutUint32(oversized, uint32(len(oversized)+10))
+    deep := func(levels int) []byte {
+        d := bsonWrap()
+        for i := 0; i < levels; i++ { d = bsonWrap(bsonElem(0x03, "d", d)) }
+        return d
+    }
+
+    tests := []struct {
+        name    string
+        in      []byte
+        wantErr bool
+        check   func(map[string]any) bool
+    }{
+        {"nested arrays", nested, false, func(d map[string]any) bool {
+            dbs, _ := d["databases"].([]any)
+            if len(dbs) != 2 || d["ok"] != float64(1) { return false }
+            first, _ := dbs[0].(map[string]any)
+            inner, _ := dbs[1].([]any)
+            return first["name"] == "admin" && len(inner) == 2 && inner[0] == float64(1) && inner[1] == true
+        }},
+        {"empty", nil, true, nil},
+        {"truncated document", nested[:len(nested)/2], true, nil},
+        {"truncated key", bsonWrap([]byte{0x10, 'k', 'e', 'y'}), true, nil},
+        {"size past the buffer", oversized, true, nil},
+        {"string past the end", longString, true, nil},
+        {"binary past the end", longBinary, true, nil},
+        {"inner document past the end", longInner, true, nil},
+        {"unknown type", bsonWrap(bsonElem(0x42, "x", nil)), true, nil},
+        {"nested to the limit", deep(bsonMaxDepth), false, func(d map[string]any) bool { return d["d"] != nil }},
+        {"nested too deeply", deep(bsonMaxDepth + 1), true, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            doc, _, err := bsonDecode(tt.in, 0)
+            if (err != nil) != tt.wantErr { t.Fatalf("err = %v, want error %v", err, tt.wantErr) }
+            if tt.check != nil && !tt.check(doc) { t.Errorf("decoded %#v", doc) }
+        })
+    }
+}
+
+// ---- MySQL ----
+
+// mysqlHandshake builds a protocol 10 initial handshake packet (sequence 0).
+func mysqlHandshake(version string, caps uint32, plugin string) []byte {
+    p := append([]byte{10}, version...)
+    p = append(p, 0)
+    p = append(p, 1, 0, 0, 0)             // connection id
+    p = append(p, "12345678"...)          // auth data, part 1
+    p = append(p, 0)                      // filler
+    p = binary.LittleEndian.AppendUint16(p, uint16(caps))
+    p = append(p, 45)                     // charset
+    p = append(p, 2, 0)                   // status
+    p = binary.LittleEndian.AppendUint16(p, uint16(caps>>16))
+    p = append(p, 21)                     // auth data length
+    p = append(p, make([]byte, 10)...)
+    p = append(p, "123456789012\x00"...)  // auth data, part 2
+    return mysqlPacket(0, append(append(p, plugin...), 0))
+}
+
+func mysqlPacket(seq byte, p []byte) []byte {
+    return append([]byte{byte(len(p)), byte(len(p) >> 8), byte(len(p) >> 16), seq}, p...)
+}
+
+func TestMySQLProbe(t *testing.T) {
+    cert := testCertificate(t)
+    tests := []struct {
+        name    string
+        opts    dbOptions
+        serve   func(t *testing.T, c net.Conn)
+        summary string
+        err     string
+        details map[string]any
+    }{
+        {
+            name:  "mysql 8 without tls",
+            serve: func(t *testing.T, c net.Conn) { _, _ = c.Write(mysqlHandshake("8.0.36", mysqlClientProtocol41, "caching_sha2_password")) },
+            summary: "MySQL 8.0.36, auth caching_sha2_password",
+            details: map[string]any{"speaks_protocol": true, "tls_supported": false, "version": "8.0.36", "plaintext": true},
+        },
+        {
+            name: "mariadb with tls",
+            serve: func(t *testing.T, c net.Conn) {
+                _, _ = c.Write(mysqlHandshake("5.5.5-10.11.6-MariaDB-log", mysqlClientProtocol41|mysqlClientSSL, "mysql_native_password"))
+                hdr := make([]byte, 4+32)
+                if _, err := io.ReadFull(c, hdr); err != nil || hdr[3] != 1 || binary.LittleEndian.Uint32(hdr[4:])&mysqlClientSSL == 0 {
+                    t.Errorf("SSLRequest %x (%v)", hdr, err)
+                    return
+                }
+                _ = tls.Server(c, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+            },
+            summary: "MariaDB 10.11.6, auth mysql_native_password",
+            details: map[string]any{"speaks_protocol": true, "tls_supported": true, "version": "10.11.6-MariaDB-log"},
+        },
+        {
+            name:    "tls advertised but not wanted",
+            opts:    dbOptions{TLS: "none"},
+            serve:   func(t *testing.T, c net.Conn) { _, _ = c.Write(mysqlHandshake("8.4.0", mysqlClientProtocol41|mysqlClientSSL, "")) },
+            summary: "MySQL 8.4.0",
+            details: map[string]any{"tls_supported": true},
+        },
+        {
+            name: "host not allowed",
+            serve: func(t *testing.T, c net.Conn) {
+                _, _ = c.Write(mysqlPacket(0, append([]byte{0xff, 0x6a, 0x04}, "Host '192.0.2.7' is not allowed to connect"...)))
+            },
+            err:     "error 1130: Host '192.0.2.7' is not allowed to connect",
+            details: map[string]any{"speaks_protocol": true},
+        },
+        {
+            name:    "not mysql",
+            serve:   func(t *testing.T, c net.Conn) { _, _ = c.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n")) },
+            err:     "not a MySQL server (bad handshake length)",
+            details: map[string]any{"speaks_protocol": false},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s := pipeSession(t, tt.opts, func(_ int, c net.Conn) { tt.serve(t, c) })
+            summary, err := mysqlProbe(s)
+            checkProbe(t, s, summary, err, tt.summary, tt.err, tt.details)
+        })
+    }
+}
+
+// checkProbe compares a probe outcome with the expected summary, error and details.
+func checkProbe(t *testing.T, s *dbSession, summary string, err error, wantSummary, wantErr string, details map[string]any) {
+    t.Helper()
+    if wantErr != "" {
+        if err == nil || err.Error() != wantErr { t.Errorf("err = %v, want %q", err, wantErr) }
+    } else if err != nil {
+        t.Fatalf("probe: %v", err)
+    }
+    if summary != wantSummary { t.Errorf("summary = %q, want %q", summary, wantSummary) }
+    for k, want := range details {
+        if got := s.details[k]; got != want { t.Errorf("details[%s] = %v, want %v", k, got, want) }
+    }
+}
+
+// ---- PostgreSQL ----
+
+const pgSSLRequest = "\x00\x00\x00\x08\x04\xd2\x16\x2f"
+
+// pgReadStartup consumes a startup message and reports whether it was one.
+func pgReadStartup(t *testing.T, r io.Reader) bool {
+    hdr := make([]byte, 8)
+    if _, err := io.ReadFull(r, hdr); err != nil { t.Errorf("startup: %v", err); return false }
+    if binary.BigEndian.Uint32(hdr[4:]) != 3<<16 { t.Errorf("startup protocol %x", hdr[4:]); return false }
+    _, err := io.ReadFull(r, make([]byte, binary.BigEndian.Uint32(hdr)-8))
+    return err == nil
+}
+
+func pgMessage(typ byte, payload string) []byte {
+    return append(binary.BigEndian.AppendUint32([]byte{typ}, uint32(len(payload)+4)), payload...)
+}
+
+func pgAuth(code uint32, extra string) []byte {
+    return pgMessage('R', string(binary.BigEndian.AppendUint32(nil, code))+extra)
+}
+
+func TestPostgresProbe(t *testing.T) {
+    cert := testCertificate(t)
+    refused := pgMessage('E', "SFATAL\x00C28000\x00Mno pg_hba.conf entry for host \"192.0.2.7\", no encryption\x00\x00")
+    tests := []struct {
+        name    string
+        opts    dbOptions
+        serve   func(t *testing.T, n int, c net.Conn)
+        summary string
+        err     string
+        details map[string]any
+    }{
+        {
+            name: "ssl refused, md5",
+            serve: func(t *testing.T, n int, c net.Conn) {
+                if !expect(t, c, pgSSLRequest) { return }
+                _, _ = c.Write([]byte{'N'})
+                if pgReadStartup(t, c) { _, _ = c.Write(pgAuth(5, "salt")) }
+            },
+            summary: "PostgreSQL, auth md5",
+            details: map[string]any{"speaks_protocol": true, "tls_supported": false, "plaintext": true, "auth_required": true},
+        },
+        {
+            name: "ssl accepted, scram, plaintext refused",
+            serve: func(t *testing.T, n int, c net.Conn) {
+                if n == 1 {
+                    // the second connection asks again without TLS
+                    if pgReadStartup(t, c) { _, _ = c.Write(refused) }
+                    return
+                }
+                if !expect(t, c, pgSSLRequest) { return }
+                _, _ = c.Write([]byte{'S'})
+                tc := tls.Server(c, &tls.Config{Certificates: []tls.Certificate{cert}})
+                if pgReadStartup(t, tc) { _, _ = tc.Write(pgAuth(10, "SCRAM-SHA-256-PLUS\x00SCRAM-SHA-256\x00\x00")) }
+            },
+            summary: "PostgreSQL, auth SASL SCRAM-SHA-256-PLUS,SCRAM-SHA-256",
+            details: map[string]any{"tls_supported": true, "plaintext": false, "plaintext_error": "FATAL 28000: no pg_hba.conf entry for host \"192.0.2.7\", no encryption"},
+        },
+        {
+            name: "trust reports the version",
+            opts: dbOptions{TLS: "none"},
+            serve: func(t *testing.T, n int, c net.Conn) {
+                r := bufio.NewReader(c)
+                if !pgReadStartup(t, r) { return }
+                _, _ = c.Write(append(append(pgAuth(0, ""), pgMessage('S', "server_version\x0016.2\x00")...), pgMessage('Z', "I")...))
+                expect(t, r, "X\x00\x00\x00\x04")
+            },
+            summary: "PostgreSQL 16.2, auth trust",
+            details: map[string]any{"auth_required": false, "version": "16.2"},
+        },
+        {
+            name:    "not postgres",
+            serve: func(t *testing.T, n int, c net.Conn) {
+                if expect(t, c, pgSSLRequest) { _, _ = c.Write([]byte("HTTP/1.0 400 Bad Request\r\n")) }
+            },
+            err:     "not a PostgreSQL server (SSLRequest answered 'H')",
+            details: map[string]any{"speaks_protocol": false},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s := pipeSession(t, tt.opts, func(n int, c net.Conn) { tt.serve(t, n, c) })
+            summary, err := postgresProbe(s)
+            checkProbe(t, s, summary, err, tt.summary, tt.err, tt.details)
+        })
+    }
+}
+
+// ---- Redis ----
+
+const (
+    redisPing = "*1\r\n$4\r\nPING\r\n"
+    redisInfo = "*2\r\n$4\r\nINFO\r\n$6\r\nserver\r\n"
+)
+
+func TestRedisProbe(t *testing.T) {
+    info := "# Server\r\nredis_version:7.2.4\r\nredis_mode:standalone\r\nos:Linux 6.1.0 x86_64\r\n"
+    reply := func(lines ...string) func(t *testing.T, c net.Conn) {
+        return func(t *testing.T, c net.Conn) {
+            if !expect(t, c, redisPing) { return }
+            _, _ = c.Write([]byte(lines[0]))
+            if len(lines) > 1 && expect(t, c, redisInfo) { _, _ = c.Write([]byte(lines[1])) }
+        }
+    }
+    tests := []struct {
+        name    string
+        serve   func(t *testing.T, c net.Conn)
+        summary string
+        err     string
+        details map[string]any
+    }{
+        {
+            name:    "pong",
+            serve:   reply("+PONG\r\n", "$"+strconv.Itoa(len(info))+"\r\n"+info+"\r\n"),
+            summary: "Redis 7.2.4",
+            details: map[string]any{"speaks_protocol": true, "auth_required": false, "version": "7.2.4", "redis_mode": "standalone", "plaintext": true},
+        },
+        {
+            name:    "noauth",
+            serve:   reply("-NOAUTH Authentication required.\r\n"),
+            summary: "Redis, auth required",
+            details: map[string]any{"speaks_protocol": true, "auth_required": true, "plaintext": true},
+        },
+        {
+            name:    "protected mode",
+            serve:   reply("-DENIED Redis is running in protected mode because protected mode is enabled\r\n"),
+            summary: "Redis, protected mode",
+            details: map[string]any{"auth_required": true, "protected_mode": true},
+        },
+        {
+            name:    "not redis",
+            serve:   reply("HTTP/1.1 400 Bad Request\r\n"),
+            err:     `not a Redis server (PING answered "HTTP/1.1 400 Bad Request")`,
+            details: map[string]any{"speaks_protocol": false},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s := pipeSession(t, dbOptions{TLS: "none"}, func(_ int, c net.Conn) { tt.serve(t, c) })
+            summary, err := redisProbe(s)
+            checkProbe(t, s, summary, err, tt.summary, tt.err, tt.details)
+        })
+    }
+}
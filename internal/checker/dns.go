@@ -0,0 +1,114 @@
+package checker
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "aeza/internal/dnsclient"
+)
+
+// dnsOptions are the per-task parameters of the dns method.
+type dnsOptions struct {
+    // Resolvers are "system", "auth" (the zone's authoritative NSes) or server addresses like "1.1.1.1".
+    Resolvers []string `json:"resolvers"`
+    // Types are record types to query, e.g. ["A", "CAA", "SOA"].
+    Types     []string `json:"types"`
+    TimeoutMs int      `json:"timeout_ms"`
+    // DNSSEC validates every requested type from the root trust anchor.
+    DNSSEC bool `json:"dnssec"`
+}
+
+var dnsDefaultTypes = []string{"A", "AAAA", "MX", "NS", "TXT"}
+
+// legacyDNSValue keeps the flat per-type lists the frontend renders.
+func legacyDNSValue(a dnsclient.Answer) string {
+    if a.Type == "MX" {
+        // "10 mx.example.com." -> "mx.example.com 10"
+        if f := strings.Fields(a.Data); len(f) == 2 { return strings.TrimSuffix(f[1], ".") + " " + f[0] }
+    }
+    if a.Type == "TXT" { return strings.Join(strings.Fields(strings.ReplaceAll(a.Data, "\"", "")), " ") }
+    return strings.TrimSuffix(a.Data, ".")
+}
+
+// dnsCheck queries every requested type against every requested resolver and fails
+// when any query did not come back with NOERROR.
+func dnsCheck(ctx context.Context, target string, opts dnsOptions) (ok bool, latency int64, msg string, details map[string]any) {
+    start := time.Now()
+    host := hostnameForDNS(target)
+    if len(opts.Resolvers) == 0 { opts.Resolvers = []string{"system"} }
+    if len(opts.Types) == 0 { opts.Types = dnsDefaultTypes }
+    timeout := time.Duration(opts.TimeoutMs) * time.Millisecond
+    if timeout <= 0 { timeout = 3 * time.Second }
+    client := dnsclient.New(timeout)
+    ctx, cancel := context.WithTimeout(ctx, checkTimeout(ctx, 30*time.Second))
+    defer cancel()
+
+    qtypes := make([]uint16, 0, len(opts.Types))
+    for _, t := range opts.Types {
+        qt, err := dnsclient.ParseType(t)
+        if err != nil { return false, 0, err.Error(), nil }
+        qtypes = append(qtypes, qt)
+    }
+
+    var servers []dnsclient.Server
+    var failures []string
+    for _, spec := range opts.Resolvers {
+        ss, err := client.ResolveServers(ctx, spec, host)
+        if err != nil {
+            failures = append(failures, spec+": "+err.Error())
+            continue
+        }
+        servers = append(servers, ss...)
+    }
+
+    details = map[string]any{"name": host}
+    legacy := map[string][]string{}
+    queries := make([]dnsclient.Result, 0, len(servers)*len(qtypes))
+    for _, s := range servers {
+        for _, qt := range qtypes {
+            r := client.Query(ctx, s, host, qt)
+            queries = append(queries, r)
+            if !r.OK() {
+                reason := r.Rcode
+                if r.Error != "" { reason = r.Error }
+                failures = append(failures, fmt.Sprintf("%s %s: %s", s.Label, r.Type, reason))
+                continue
+            }
+            for _, a := range r.Answers {
+                if a.Type != r.Type { continue }
+                v := legacyDNSValue(a)
+                if !containsFold(legacy[a.Type], v) { legacy[a.Type] = append(legacy[a.Type], v) }
+            }
+        }
+    }
+    for k, v := range legacy {
+        sort.Strings(v)
+        details[k] = v
+    }
+    details["queries"] = queries
+    if opts.DNSSEC {
+        // validation goes through the first recursive resolver; authoritative servers cannot chase the chain
+        server := dnsclient.SystemServers()[0]
+        for _, s := range servers {
+            if s.Recursive {
+                server = s.Addr
+                break
+            }
+        }
+        v := dnsclient.NewValidator(client, server, nil)
+        validations := make([]dnsclient.ValidationResult, 0, len(qtypes))
+        for _, qt := range qtypes {
+            r := v.Validate(ctx, host, qt)
+            validations = append(validations, r)
+            if r.Status == dnsclient.Bogus || r.Status == dnsclient.Indeterminate {
+                failures = append(failures, fmt.Sprintf("dnssec %s: %s: %s", r.Type, r.Status, r.Reason))
+            }
+        }
+        details["dnssec"] = validations
+    }
+    if len(queries) == 0 && len(failures) == 0 { failures = append(failures, "no resolvers") }
+    return len(failures) == 0, time.Since(start).Milliseconds(), strings.Join(failures, "; "), details
+}
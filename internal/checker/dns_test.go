@@ -0,0 +1,172 @@
+package checker
+
+import (
+    "context"
+    "net"
+    "reflect"
+    "strings"
+    "testing"
+
+    "aeza/internal/dnsclient"
+
+    "github.com/miekg/dns"
+)
+
+// fakeResolver answers from zone-file records; queries for a type in fail get that rcode.
+type fakeResolver struct {
+    records map[string][]dns.RR
+    fail    map[string]int
+}
+
+func newFakeResolver(t *testing.T, records ...string) *fakeResolver {
+    r := &fakeResolver{records: map[string][]dns.RR{}, fail: map[string]int{}}
+    for _, s := range records {
+        rr, err := dns.NewRR(s)
+        if err != nil { t.Fatalf("%q: %v", s, err) }
+        r.records[rr.Header().Name] = append(r.records[rr.Header().Name], rr)
+    }
+    return r
+}
+
+func (r *fakeResolver) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+    q := req.Question[0]
+    m := new(dns.Msg).SetReply(req)
+    rrs, exists := r.records[q.Name]
+    switch {
+    case r.fail[dns.TypeToString[q.Qtype]] != 0:
+        m.Rcode = r.fail[dns.TypeToString[q.Qtype]]
+    case !exists:
+        m.Rcode = dns.RcodeNameError
+    }
+    for _, rr := range rrs {
+        if rr.Header().Rrtype == q.Qtype || rr.Header().Rrtype == dns.TypeCNAME { m.Answer = append(m.Answer, rr) }
+    }
+    _ = w.WriteMsg(m)
+}
+
+// serve runs r on a loopback UDP port and returns its address.
+func (r *fakeResolver) serve(t *testing.T) string {
+    pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+    started := make(chan struct{})
+    srv := &dns.Server{PacketConn: pc, Handler: r, NotifyStartedFunc: func() { close(started) }}
+    go func() { _ = srv.ActivateAndServe() }()
+    <-started
+    t.Cleanup(func() { _ = srv.Shutdown() })
+    return pc.LocalAddr().String()
+}
+
+var exampleZone = []string{
+    "www.example.test. 300 IN A 192.0.2.2",
+    "www.example.test. 300 IN A 192.0.2.1",
+    "www.example.test. 300 IN MX 10 mx.example.test.",
+    `www.example.test. 300 IN TXT "v=spf1" " -all"`,
+    "alias.example.test. 300 IN CNAME www.example.test.",
+    "alias.example.test. 300 IN A 192.0.2.1",
+}
+
+func TestDNSCheck(t *testing.T) {
+    tests := []struct {
+        name    string
+        target  string
+        types   []string
+        fail    map[string]int
+        ok      bool
+        msg     string
+        queries int
+        legacy  map[string][]string
+    }{
+        {
+            name:    "all answered",
+            target:  "www.example.test",
+            types:   []string{"A", "mx", "TXT"},
+            ok:      true,
+            queries: 3,
+            legacy:  map[string][]string{"A": {"192.0.2.1", "192.0.2.2"}, "MX": {"mx.example.test 10"}, "TXT": {"v=spf1 -all"}},
+        },
+        {
+            name:    "default types, empty answers are fine",
+            target:  "https://www.example.test/path",
+            ok:      true,
+            queries: 5,
+            legacy:  map[string][]string{"A": {"192.0.2.1", "192.0.2.2"}, "MX": {"mx.example.test 10"}, "TXT": {"v=spf1 -all"}},
+        },
+        {
+            name:    "cname is left out of the flat list",
+            target:  "alias.example.test",
+            types:   []string{"A"},
+            ok:      true,
+            queries: 1,
+            legacy:  map[string][]string{"A": {"192.0.2.1"}},
+        },
+        {
+            name:    "nxdomain",
+            target:  "nope.example.test",
+            types:   []string{"A", "AAAA"},
+            msg:     "$ A: NXDOMAIN; $ AAAA: NXDOMAIN",
+            queries: 2,
+        },
+        {
+            name:    "one type fails",
+            target:  "www.example.test",
+            types:   []string{"A", "CAA"},
+            fail:    map[string]int{"CAA": dns.RcodeServerFailure},
+            msg:     "$ CAA: SERVFAIL",
+            queries: 2,
+            legacy:  map[string][]string{"A": {"192.0.2.1", "192.0.2.2"}},
+        },
+        {
+            name:   "unknown type",
+            target: "www.example.test",
+            types:  []string{"A", "BOGUS"},
+            msg:    `unknown record type "BOGUS"`,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := newFakeResolver(t, exampleZone...)
+            if tt.fail != nil { r.fail = tt.fail }
+            addr := r.serve(t)
+            ok, _, msg, details := dnsCheck(context.Background(), tt.target, dnsOptions{Resolvers: []string{addr}, Types: tt.types, TimeoutMs: 1000})
+            if want := strings.ReplaceAll(tt.msg, "$", addr); ok != tt.ok || msg != want { t.Fatalf("dnsCheck = %v, %q; want %v, %q", ok, msg, tt.ok, want) }
+            if tt.queries == 0 { return }
+            if q, _ := details["queries"].([]dnsclient.Result); len(q) != tt.queries { t.Errorf("%d queries, want %d", len(q), tt.queries) }
+            for _, k := range []string{"A", "AAAA", "MX", "NS", "TXT", "CAA"} {
+                got, _ := details[k].([]string)
+                if !reflect.DeepEqual(got, tt.legacy[k]) { t.Errorf("details[%s] = %q, want %q", k, got, tt.legacy[k]) }
+            }
+        })
+    }
+}
+
+func TestDNSCheckEveryResolverMustAnswer(t *testing.T) {
+    good := newFakeResolver(t, exampleZone...).serve(t)
+    refusing := newFakeResolver(t, exampleZone...)
+    refusing.fail = map[string]int{"A": dns.RcodeRefused}
+    bad := refusing.serve(t)
+    ok, _, msg, details := dnsCheck(context.Background(), "www.example.test", dnsOptions{Resolvers: []string{good, bad}, Types: []string{"A"}, TimeoutMs: 1000})
+    if ok || msg != bad+" A: REFUSED" { t.Errorf("dnsCheck = %v, %q", ok, msg) }
+    queries, _ := details["queries"].([]dnsclient.Result)
+    if len(queries) != 2 || !queries[0].OK() || queries[1].Rcode != "REFUSED" { t.Errorf("queries = %+v", queries) }
+    // the answering resolver still fills the flat list
+    if got := details["A"]; !reflect.DeepEqual(got, []string{"192.0.2.1", "192.0.2.2"}) { t.Errorf("details[A] = %v", got) }
+}
+
+func TestDNSOptions(t *testing.T) {
+    c, found := Lookup("dns")
+    if !found { t.Fatal("dns is not registered") }
+    var names []string
+    for _, p := range c.Params() { names = append(names, p.Name+":"+p.Type) }
+    if want := []string{"resolvers:array", "types:array", "timeout_ms:integer", "dnssec:boolean"}; !reflect.DeepEqual(names, want) {
+        t.Errorf("params = %q, want %q", names, want)
+    }
+    addr := newFakeResolver(t, exampleZone...).serve(t)
+    res := c.Run(context.Background(), "www.example.test", []byte(`{"resolvers": ["`+addr+`"], "types": ["MX"], "timeout_ms": 1000}`))
+    details, _ := res.Details.(map[string]any)
+    if !res.Success || !reflect.DeepEqual(details["MX"], []string{"mx.example.test 10"}) || details["A"] != nil {
+        t.Errorf("Run = %+v", res)
+    }
+    if res := c.Run(context.Background(), "www.example.test", []byte(`{"types": "A"}`)); res.Success || !strings.HasPrefix(res.Message, "invalid dns options") {
+        t.Errorf("Run with a bad types option = %+v", res)
+    }
+}
@@ -0,0 +1,300 @@
+package checker
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "fmt"
+    "io"
+    "math"
+    "net"
+    "net/http"
+    "net/http/httptrace"
+    "net/url"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// httpMaxBody caps how much of the response body is downloaded for the transfer timing.
+const httpMaxBody = 10 << 20
+
+const httpDefaultMaxRedirects = 10
+
+func durMs(d time.Duration) float64 {
+    return math.Round(float64(d.Microseconds())/10) / 100
+}
+
+// httpTimings collects per-phase timestamps of a single request via httptrace.
+type httpTimings struct {
+    start, dnsStart, dnsDone, connStart, connDone, tlsStart, tlsDone, wrote, firstByte, done time.Time
+    remoteAddr string
+    reused     bool
+}
+
+func (t *httpTimings) trace() *httptrace.ClientTrace {
+    return &httptrace.ClientTrace{
+        DNSStart:             func(httptrace.DNSStartInfo) { t.dnsStart = time.Now() },
+        DNSDone:              func(httptrace.DNSDoneInfo) { t.dnsDone = time.Now() },
+        ConnectStart:         func(string, string) { if t.connStart.IsZero() { t.connStart = time.Now() } },
+        ConnectDone:          func(string, string, error) { t.connDone = time.Now() },
+        TLSHandshakeStart:    func() { t.tlsStart = time.Now() },
+        TLSHandshakeDone:     func(tls.ConnectionState, error) { t.tlsDone = time.Now() },
+        GotConn: func(info httptrace.GotConnInfo) {
+            t.reused = info.Reused
+            if info.Conn != nil { t.remoteAddr = info.Conn.RemoteAddr().String() }
+        },
+        WroteRequest:         func(httptrace.WroteRequestInfo) { t.wrote = time.Now() },
+        GotFirstResponseByte: func() { t.firstByte = time.Now() },
+    }
+}
+
+func span(from, to time.Time) float64 {
+    if from.IsZero() || to.IsZero() { return 0 }
+    return durMs(to.Sub(from))
+}
+
+func (t *httpTimings) asMap() map[string]any {
+    // TTFB is measured from the moment the request was fully written
+    ttfbFrom := t.wrote
+    if ttfbFrom.IsZero() { ttfbFrom = t.start }
+    return map[string]any{
+        "dns_ms":      span(t.dnsStart, t.dnsDone),
+        "connect_ms":  span(t.connStart, t.connDone),
+        "tls_ms":      span(t.tlsStart, t.tlsDone),
+        "ttfb_ms":     span(ttfbFrom, t.firstByte),
+        "transfer_ms": span(t.firstByte, t.done),
+        "total_ms":    span(t.start, t.done),
+    }
+}
+
+// httpOptions are the per-task parameters of the http method.
+type httpOptions struct {
+    Method          string            `json:"method"`
+    Headers         map[string]string `json:"headers"`
+    Body            string            `json:"body"`
+    Host            string            `json:"host"`
+    FollowRedirects *bool             `json:"follow_redirects"`
+    MaxRedirects    *int              `json:"max_redirects"`
+    // ExpectedStatus accepts exact codes ("200"), classes ("2xx") and ranges ("200-204").
+    ExpectedStatus  []string          `json:"expected_status"`
+    BodyContains    string            `json:"body_contains"`
+    BodyNotContains string            `json:"body_not_contains"`
+    BodyMatches     string            `json:"body_matches"`
+    BodyNotMatches  string            `json:"body_not_matches"`
+}
+
+func (o httpOptions) needsBody() bool {
+    return o.BodyContains != "" || o.BodyNotContains != "" || o.BodyMatches != "" || o.BodyNotMatches != ""
+}
+
+// statusMatches reports whether code satisfies one of the expected status patterns.
+// Without patterns any 2xx/3xx response is accepted.
+func statusMatches(code int, expected []string) (bool, error) {
+    if len(expected) == 0 { return code >= 200 && code < 400, nil }
+    for _, e := range expected {
+        e = strings.ToLower(strings.TrimSpace(e))
+        switch {
+        case len(e) == 3 && strings.HasSuffix(e, "xx"):
+            if e[0] < '1' || e[0] > '5' { return false, fmt.Errorf("bad status pattern %q", e) }
+            if code/100 == int(e[0]-'0') { return true, nil }
+        case strings.Contains(e, "-"):
+            lo, hi, _ := strings.Cut(e, "-")
+            l, err1 := strconv.Atoi(strings.TrimSpace(lo))
+            h, err2 := strconv.Atoi(strings.TrimSpace(hi))
+            if err1 != nil || err2 != nil { return false, fmt.Errorf("bad status range %q", e) }
+            if code >= l && code <= h { return true, nil }
+        default:
+            n, err := strconv.Atoi(e)
+            if err != nil { return false, fmt.Errorf("bad status %q", e) }
+            if code == n { return true, nil }
+        }
+    }
+    return false, nil
+}
+
+// bodyAssertions evaluates body checks and returns per-assertion outcomes and the first failure.
+func bodyAssertions(body []byte, o httpOptions) ([]map[string]any, string, error) {
+    var out []map[string]any
+    failed := ""
+    add := func(kind, value string, passed bool, failMsg string) {
+        out = append(out, map[string]any{"type": kind, "value": value, "passed": passed})
+        if !passed && failed == "" { failed = failMsg }
+    }
+    if o.BodyContains != "" {
+        add("body_contains", o.BodyContains, bytes.Contains(body, []byte(o.BodyContains)), fmt.Sprintf("body does not contain %q", o.BodyContains))
+    }
+    if o.BodyNotContains != "" {
+        add("body_not_contains", o.BodyNotContains, !bytes.Contains(body, []byte(o.BodyNotContains)), fmt.Sprintf("body contains %q", o.BodyNotContains))
+    }
+    if o.BodyMatches != "" {
+        re, err := regexp.Compile(o.BodyMatches)
+        if err != nil { return nil, "", fmt.Errorf("invalid body_matches: %w", err) }
+        add("body_matches", o.BodyMatches, re.Match(body), fmt.Sprintf("body does not match /%s/", o.BodyMatches))
+    }
+    if o.BodyNotMatches != "" {
+        re, err := regexp.Compile(o.BodyNotMatches)
+        if err != nil { return nil, "", fmt.Errorf("invalid body_not_matches: %w", err) }
+        add("body_not_matches", o.BodyNotMatches, !re.Match(body), fmt.Sprintf("body matches /%s/", o.BodyNotMatches))
+    }
+    return out, failed, nil
+}
+
+func isRedirectStatus(code int) bool {
+    switch code {
+    case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+        return true
+    }
+    return false
+}
+
+// newHTTPRequest builds one hop of the check. Credentials and the Host override are
+// only sent while the chain stays on the original host.
+func newHTTPRequest(ctx context.Context, method string, u *url.URL, body string, origin *url.URL, opts httpOptions) (*http.Request, error) {
+    var r io.Reader
+    if body != "" { r = strings.NewReader(body) }
+    req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+    if err != nil { return nil, err }
+    sameHost := strings.EqualFold(u.Host, origin.Host)
+    for k, v := range opts.Headers {
+        if !sameHost && (strings.EqualFold(k, "Authorization") || strings.EqualFold(k, "Cookie")) { continue }
+        req.Header.Set(k, v)
+    }
+    if opts.Host != "" && sameHost { req.Host = opts.Host }
+    return req, nil
+}
+
+// sensitiveHeaders carry session secrets that must not end up in stored results.
+var sensitiveHeaders = []string{"Set-Cookie", "Set-Cookie2", "Authorization", "Proxy-Authorization", "Cookie"}
+
+// redactHeaders returns a copy of h with secret values masked. Set-Cookie keeps the cookie name.
+func redactHeaders(h http.Header) http.Header {
+    out := h.Clone()
+    for _, k := range sensitiveHeaders {
+        vs := out[k]
+        for i, v := range vs {
+            vs[i] = "[redacted]"
+            if name, _, found := strings.Cut(v, "="); found && strings.HasPrefix(k, "Set-Cookie") { vs[i] = strings.TrimSpace(name) + "=[redacted]" }
+        }
+    }
+    return out
+}
+
+func httpCheck(ctx context.Context, target string, opts httpOptions) (ok bool, code int, latency int64, msg string, details map[string]any) {
+    u := ensureHTTPURL(target)
+    method := strings.ToUpper(strings.TrimSpace(opts.Method))
+    if method == "" { method = http.MethodGet }
+    if _, err := statusMatches(0, opts.ExpectedStatus); err != nil { return false, 0, 0, err.Error(), nil }
+
+    tlsCfg := &tls.Config{InsecureSkipVerify: true}
+    if opts.Host != "" { tlsCfg.ServerName = hostnameForDNS(opts.Host) }
+    tr := &http.Transport{TLSClientConfig: tlsCfg}
+    // the transport lives for one check; redirect hops may still reuse its connections
+    defer tr.CloseIdleConnections()
+    client := &http.Client{Transport: tr}
+    // redirects are followed manually so that every hop can be recorded
+    client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+    follow := opts.FollowRedirects == nil || *opts.FollowRedirects
+
+    maxRedirects := httpDefaultMaxRedirects
+    if opts.MaxRedirects != nil { maxRedirects = *opts.MaxRedirects }
+    if maxRedirects < 0 { return false, 0, 0, "max_redirects must not be negative", nil }
+    origin, err := url.Parse(u)
+    if err != nil { return false, 0, 0, err.Error(), nil }
+
+    // one budget for the whole redirect chain and the body: client.Timeout would start over on every hop
+    ctx, cancel := context.WithTimeout(ctx, checkTimeout(ctx, 10*time.Second))
+    defer cancel()
+    start := time.Now()
+    redirects := []map[string]any{}
+    seen := map[string]bool{}
+    cur, curMethod, curBody := origin, method, opts.Body
+    var resp *http.Response
+    var tm *httpTimings
+    for {
+        req, err := newHTTPRequest(ctx, curMethod, cur, curBody, origin, opts)
+        if err != nil { return false, 0, time.Since(start).Milliseconds(), err.Error(), nil }
+        tm = &httpTimings{}
+        req = req.WithContext(httptrace.WithClientTrace(req.Context(), tm.trace()))
+        tm.start = time.Now()
+        resp, err = client.Do(req)
+        if err != nil {
+            tm.done = time.Now()
+            return false, 0, time.Since(start).Milliseconds(), err.Error(), map[string]any{"timings": tm.asMap(), "remote_ip": hostOnly(tm.remoteAddr), "redirects": redirects}
+        }
+        loc := resp.Header.Get("Location")
+        if !follow || !isRedirectStatus(resp.StatusCode) || loc == "" { break }
+        redirects = append(redirects, map[string]any{
+            "url":        cur.String(),
+            "status":     resp.StatusCode,
+            "location":   loc,
+            "latency_ms": durMs(time.Since(tm.start)),
+            "remote_ip":  hostOnly(tm.remoteAddr),
+        })
+        _, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+        _ = resp.Body.Close()
+        next, err := cur.Parse(loc)
+        if err != nil {
+            return false, resp.StatusCode, time.Since(start).Milliseconds(), "invalid redirect location: " + loc, map[string]any{"redirects": redirects}
+        }
+        seen[curMethod+" "+cur.String()] = true
+        // 301/302/303 switch to GET without body like browsers and net/http do
+        if resp.StatusCode != http.StatusTemporaryRedirect && resp.StatusCode != http.StatusPermanentRedirect {
+            if curMethod != http.MethodHead { curMethod = http.MethodGet }
+            curBody = ""
+        }
+        if seen[curMethod+" "+next.String()] {
+            return false, resp.StatusCode, time.Since(start).Milliseconds(), fmt.Sprintf("redirect loop detected: %s -> %s", cur, next), map[string]any{"redirects": redirects}
+        }
+        if len(redirects) > maxRedirects {
+            return false, resp.StatusCode, time.Since(start).Milliseconds(), fmt.Sprintf("stopped after %d redirects", maxRedirects), map[string]any{"redirects": redirects}
+        }
+        cur = next
+    }
+    defer resp.Body.Close()
+    var n int64
+    var buf []byte
+    var rerr error
+    if opts.needsBody() {
+        buf, rerr = io.ReadAll(io.LimitReader(resp.Body, httpMaxBody))
+        n = int64(len(buf))
+    } else {
+        n, rerr = io.Copy(io.Discard, io.LimitReader(resp.Body, httpMaxBody))
+    }
+    tm.done = time.Now()
+    latency = time.Since(start).Milliseconds()
+    details = map[string]any{
+        "request_method":   method,
+        "final_url":        cur.String(),
+        "redirects":        redirects,
+        "headers":          redactHeaders(resp.Header),
+        "timings":          tm.asMap(),
+        "remote_ip":        hostOnly(tm.remoteAddr),
+        "conn_reused":      tm.reused,
+        "bytes_downloaded": n,
+        "body_truncated":   n >= httpMaxBody,
+        "proto":            resp.Proto,
+    }
+    if rerr != nil { return false, resp.StatusCode, latency, "body read: " + rerr.Error(), details }
+
+    statusOK, _ := statusMatches(resp.StatusCode, opts.ExpectedStatus)
+    if !statusOK {
+        expected := "2xx,3xx"
+        if len(opts.ExpectedStatus) > 0 { expected = strings.Join(opts.ExpectedStatus, ",") }
+        msg = fmt.Sprintf("unexpected status %d (expected %s)", resp.StatusCode, expected)
+    }
+    if opts.needsBody() {
+        assertions, failed, err := bodyAssertions(buf, opts)
+        if err != nil { return false, resp.StatusCode, latency, err.Error(), details }
+        details["assertions"] = assertions
+        if msg == "" { msg = failed }
+    }
+    return msg == "", resp.StatusCode, latency, msg, details
+}
+
+// hostOnly strips the port from a host:port address.
+func hostOnly(addr string) string {
+    if h, _, err := net.SplitHostPort(addr); err == nil { return h }
+    return addr
+}
@@ -0,0 +1,52 @@
+package checker
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "testing"
+)
+
+func TestHTTPCheckMaxRedirects(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/end" { return }
+        http.Redirect(w, r, "/end", http.StatusFound)
+    }))
+    defer srv.Close()
+    intp := func(v int) *int { return &v }
+    tests := []struct {
+        name string
+        max  *int
+        ok   bool
+        msg  string
+    }{
+        {"default", nil, true, ""},
+        {"one", intp(1), true, ""},
+        {"none", intp(0), false, "stopped after 0 redirects"},
+        {"negative", intp(-1), false, "max_redirects must not be negative"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ok, _, _, msg, _ := httpCheck(context.Background(), srv.URL+"/start", httpOptions{MaxRedirects: tt.max})
+            if ok != tt.ok || msg != tt.msg { t.Errorf("httpCheck = %v %q, want %v %q", ok, msg, tt.ok, tt.msg) }
+        })
+    }
+}
+
+func TestHTTPCheckRedactsHeaders(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cret", HttpOnly: true})
+        w.Header().Add("Set-Cookie", "opaque")
+        w.Header().Set("Authorization", "Bearer s3cret")
+        w.Header().Set("X-Request-Id", "42")
+    }))
+    defer srv.Close()
+    ok, _, _, msg, details := httpCheck(context.Background(), srv.URL, httpOptions{})
+    if !ok { t.Fatalf("httpCheck failed: %s", msg) }
+    h, _ := details["headers"].(http.Header)
+    want := http.Header{"Set-Cookie": {"session=[redacted]", "[redacted]"}, "Authorization": {"[redacted]"}, "X-Request-Id": {"42"}}
+    for k, v := range want {
+        if !reflect.DeepEqual(h[k], v) { t.Errorf("headers[%s] = %q, want %q", k, h[k], v) }
+    }
+}
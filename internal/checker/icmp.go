@@ -0,0 +1,238 @@
+package checker
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "math"
+    "net"
+    "os"
+    "sync/atomic"
+    "time"
+
+    "golang.org/x/net/icmp"
+    "golang.org/x/net/ipv4"
+    "golang.org/x/net/ipv6"
+)
+
+// icmpOptions are the per-task parameters of the icmp method.
+type icmpOptions struct {
+    Count      int `json:"count"`
+    IntervalMs int `json:"interval_ms"`
+    Size       int `json:"size"`
+    TimeoutMs  int `json:"timeout_ms"`
+    // IPVersion forces 4 or 6; 0 uses whatever the name resolves to first.
+    IPVersion  int `json:"ip_version"`
+}
+
+func (o *icmpOptions) normalize() {
+    if o.Count <= 0 { o.Count = 4 }
+    if o.Count > 50 { o.Count = 50 }
+    if o.IntervalMs <= 0 { o.IntervalMs = 500 }
+    if o.Size <= 0 { o.Size = 56 }
+    if o.Size > 8192 { o.Size = 8192 }
+    if o.TimeoutMs <= 0 { o.TimeoutMs = 2000 }
+}
+
+// resolveIP picks an address of host for the requested IP version (0 = any, v4 preferred).
+func resolveIP(ctx context.Context, host string, version int) (net.IP, error) {
+    if ip := net.ParseIP(host); ip != nil {
+        if version == 4 && ip.To4() == nil || version == 6 && ip.To4() != nil {
+            return nil, fmt.Errorf("%s is not an IPv%d address", host, version)
+        }
+        return ip, nil
+    }
+    ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+    if err != nil { return nil, err }
+    var v4, v6 net.IP
+    for _, ip := range ips {
+        if ip.To4() != nil && v4 == nil { v4 = ip }
+        if ip.To4() == nil && v6 == nil { v6 = ip }
+    }
+    switch {
+    case version == 6 && v6 != nil:
+        return v6, nil
+    case version != 6 && v4 != nil:
+        return v4, nil
+    case version == 0 && v6 != nil:
+        return v6, nil
+    }
+    if version == 0 { return nil, fmt.Errorf("no address for %s", host) }
+    return nil, fmt.Errorf("no IPv%d address for %s", version, host)
+}
+
+// icmpSeq hands out echo identifiers: pingers and tracers running side by side in one
+// agent must not take each other's replies for their own.
+var icmpSeq atomic.Uint32
+
+func nextICMPID() int { return int((uint32(os.Getpid()) + icmpSeq.Add(1)) & 0xffff) }
+
+// pinger wraps an ICMP socket. Unprivileged datagram sockets are tried first,
+// raw sockets (CAP_NET_RAW) are the fallback.
+type pinger struct {
+    conn       *icmp.PacketConn
+    v6         bool
+    privileged bool
+    id         int
+}
+
+func newPinger(v6 bool) (*pinger, error) {
+    type variant struct { network, addr string; raw bool }
+    variants := []variant{{"udp4", "0.0.0.0", false}, {"ip4:icmp", "0.0.0.0", true}}
+    if v6 { variants = []variant{{"udp6", "::", false}, {"ip6:ipv6-icmp", "::", true}} }
+    var lastErr error
+    for _, v := range variants {
+        c, err := icmp.ListenPacket(v.network, v.addr)
+        if err != nil { lastErr = err; continue }
+        p := &pinger{conn: c, v6: v6, privileged: v.raw, id: nextICMPID()}
+        if v6 {
+            _ = c.IPv6PacketConn().SetControlMessage(ipv6.FlagHopLimit, true)
+        } else {
+            _ = c.IPv4PacketConn().SetControlMessage(ipv4.FlagTTL, true)
+        }
+        return p, nil
+    }
+    return nil, lastErr
+}
+
+func (p *pinger) Close() error { return p.conn.Close() }
+
+func (p *pinger) dst(ip net.IP) net.Addr {
+    if p.privileged { return &net.IPAddr{IP: ip} }
+    return &net.UDPAddr{IP: ip}
+}
+
+// readFrom returns the next packet together with its TTL/hop limit (-1 if unknown).
+func (p *pinger) readFrom(buf []byte) (int, int, net.Addr, error) {
+    if p.v6 {
+        n, cm, src, err := p.conn.IPv6PacketConn().ReadFrom(buf)
+        ttl := -1
+        if cm != nil { ttl = cm.HopLimit }
+        return n, ttl, src, err
+    }
+    n, cm, src, err := p.conn.IPv4PacketConn().ReadFrom(buf)
+    ttl := -1
+    if cm != nil { ttl = cm.TTL }
+    return n, ttl, src, err
+}
+
+func (p *pinger) proto() int {
+    if p.v6 { return 58 }
+    return 1
+}
+
+// echo sends one echo request and waits for the matching reply.
+// Datagram sockets get their ID rewritten by the kernel, so replies are matched by seq and payload.
+func (p *pinger) echo(ip net.IP, seq int, payload []byte, timeout time.Duration) (time.Duration, int, error) {
+    var typ icmp.Type = ipv4.ICMPTypeEcho
+    if p.v6 { typ = ipv6.ICMPTypeEchoRequest }
+    msg := icmp.Message{Type: typ, Body: &icmp.Echo{ID: p.id, Seq: seq, Data: payload}}
+    b, err := msg.Marshal(nil)
+    if err != nil { return 0, 0, err }
+    deadline := time.Now().Add(timeout)
+    _ = p.conn.SetReadDeadline(deadline)
+    sent := time.Now()
+    if _, err := p.conn.WriteTo(b, p.dst(ip)); err != nil { return 0, 0, err }
+    buf := make([]byte, len(b)+512)
+    for {
+        n, ttl, src, err := p.readFrom(buf)
+        if err != nil { return 0, 0, err }
+        rtt := time.Since(sent)
+        rm, err := icmp.ParseMessage(p.proto(), buf[:n])
+        if err != nil { continue }
+        switch rm.Type {
+        case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply:
+            e, ok := rm.Body.(*icmp.Echo)
+            if !ok || e.Seq != seq || !bytes.Equal(e.Data, payload) { continue }
+            if from := net.ParseIP(hostOnly(src.String())); !from.Equal(ip) { continue }
+            if p.privileged && e.ID != p.id { continue }
+            return rtt, ttl, nil
+        case ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable:
+            // on a raw socket every unreachable reaches every pinger; only ours fails the probe
+            if u, ok := rm.Body.(*icmp.DstUnreach); ok && p.quotesEcho(u.Data, ip, seq) {
+                return 0, 0, errors.New("destination unreachable")
+            }
+        }
+    }
+}
+
+// quotesEcho reports whether the original packet quoted in an ICMP error is the echo
+// request seq sent to ip by this pinger.
+func (p *pinger) quotesEcho(data []byte, ip net.IP, seq int) bool {
+    proto, dst, l4, ok := quotedProbe(data, p.v6)
+    if !ok || proto != p.proto() || !dst.Equal(ip) || len(l4) < 8 { return false }
+    if p.privileged && int(binary.BigEndian.Uint16(l4[4:6])) != p.id { return false }
+    return int(binary.BigEndian.Uint16(l4[6:8])) == seq
+}
+
+func roundMs(d float64) float64 { return math.Round(d*100) / 100 }
+
+// icmpCheck sends a series of native ICMP echo requests and reports RTT statistics and loss.
+func icmpCheck(ctx context.Context, target string, opts icmpOptions) (ok bool, latency int64, msg string, details map[string]any) {
+    opts.normalize()
+    host := hostnameForDNS(target)
+    start := time.Now()
+    ip, err := resolveIP(ctx, host, opts.IPVersion)
+    if err != nil { return false, time.Since(start).Milliseconds(), err.Error(), nil }
+    v6 := ip.To4() == nil
+    p, err := newPinger(v6)
+    if err != nil { return false, time.Since(start).Milliseconds(), "icmp socket: " + err.Error(), nil }
+    defer p.Close()
+    stop := context.AfterFunc(ctx, func() { _ = p.Close() })
+    defer stop()
+
+    payload := make([]byte, opts.Size)
+    _, _ = rand.Read(payload)
+    var rtts []float64
+    ttl := -1
+    var lastErr error
+    sent := 0
+    for i := 0; i < opts.Count; i++ {
+        if i > 0 { sleepCtx(ctx, time.Duration(opts.IntervalMs)*time.Millisecond) }
+        if ctx.Err() != nil { lastErr = ctx.Err(); break }
+        sent++
+        rtt, t, err := p.echo(ip, i+1, payload, time.Duration(opts.TimeoutMs)*time.Millisecond)
+        if err != nil { lastErr = err; continue }
+        rtts = append(rtts, durMs(rtt))
+        if t >= 0 { ttl = t }
+    }
+
+    version := 4
+    if v6 { version = 6 }
+    received := len(rtts)
+    loss := 100.0
+    if sent > 0 { loss = roundMs(float64(sent-received) * 100 / float64(sent)) }
+    details = map[string]any{
+        "ip":         ip.String(),
+        "ip_version": version,
+        "sent":       sent,
+        "received":   received,
+        "loss_pct":   loss,
+        "size":       opts.Size,
+        "rtts_ms":    rtts,
+        "privileged": p.privileged,
+    }
+    if ttl >= 0 { details["ttl"] = ttl }
+    if received == 0 {
+        msg = "100% packet loss"
+        if lastErr != nil { msg += ": " + lastErr.Error() }
+        return false, time.Since(start).Milliseconds(), msg, details
+    }
+    lo, hi, sum, sum2 := rtts[0], rtts[0], 0.0, 0.0
+    for _, r := range rtts {
+        if r < lo { lo = r }
+        if r > hi { hi = r }
+        sum += r
+        sum2 += r * r
+    }
+    avg := sum / float64(received)
+    details["min_ms"] = lo
+    details["avg_ms"] = roundMs(avg)
+    details["max_ms"] = hi
+    details["mdev_ms"] = roundMs(math.Sqrt(math.Max(sum2/float64(received)-avg*avg, 0)))
+    if loss > 0 { msg = fmt.Sprintf("%.0f%% packet loss", loss) }
+    return true, int64(math.Round(avg)), msg, details
+}
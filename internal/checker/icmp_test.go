@@ -0,0 +1,41 @@
+package checker
+
+import (
+    "net"
+    "testing"
+
+    "golang.org/x/net/icmp"
+    "golang.org/x/net/ipv4"
+)
+
+// quotedEcho builds the IPv4 header and echo request an ICMP error quotes.
+func quotedEcho(t *testing.T, dst net.IP, id, seq int) []byte {
+    b, err := (&icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("ping")}}).Marshal(nil)
+    if err != nil { t.Fatal(err) }
+    h := make([]byte, 20, 20+len(b))
+    h[0], h[9] = 0x45, 1
+    copy(h[12:16], net.IPv4(192, 0, 2, 10).To4())
+    copy(h[16:20], dst.To4())
+    return append(h, b...)
+}
+
+func TestPingerQuotesEcho(t *testing.T) {
+    p := &pinger{privileged: true, id: 0x1234}
+    dst, other := net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2)
+    tests := []struct {
+        name   string
+        quoted []byte
+        want   bool
+    }{
+        {"our probe", quotedEcho(t, dst, 0x1234, 3), true},
+        {"other destination", quotedEcho(t, other, 0x1234, 3), false},
+        {"other pinger", quotedEcho(t, dst, 0x4321, 3), false},
+        {"other seq", quotedEcho(t, dst, 0x1234, 4), false},
+        {"truncated", quotedEcho(t, dst, 0x1234, 3)[:24], false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := p.quotesEcho(tt.quoted, dst, 3); got != tt.want { t.Errorf("quotesEcho = %v, want %v", got, tt.want) }
+        })
+    }
+}
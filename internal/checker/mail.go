@@ -0,0 +1,219 @@
+package checker
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "net"
+    "net/textproto"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// mailOptions are the per-task parameters of the smtp, imap and pop3 methods.
+type mailOptions struct {
+    Port int `json:"port"`
+    // TLS is "starttls", "implicit" or "none". By default implicit TLS is used on
+    // 465/993/995 and STARTTLS everywhere else when the server offers it.
+    TLS string `json:"tls"`
+    // RequireTLS fails the check when the server does not offer STARTTLS.
+    RequireTLS bool `json:"require_tls"`
+    // EHLO is the name the agent introduces itself with over SMTP.
+    EHLO      string `json:"ehlo"`
+    TimeoutMs int    `json:"timeout_ms"`
+}
+
+// mailProtocol describes the dialogue of one mail protocol.
+type mailProtocol struct {
+    port, tlsPort int
+    // tlsCapability is how the server advertises STARTTLS.
+    tlsCapability string
+    greeting      func(tp *textproto.Conn) (string, error)
+    capabilities  func(tp *textproto.Conn, opts mailOptions) ([]string, error)
+    startTLS      func(tp *textproto.Conn) error
+    quit          func(tp *textproto.Conn)
+}
+
+var mailProtocols = map[string]mailProtocol{
+    "smtp": {
+        port: 25, tlsPort: 465, tlsCapability: "STARTTLS",
+        greeting: func(tp *textproto.Conn) (string, error) {
+            _, msg, err := tp.ReadResponse(220)
+            return msg, err
+        },
+        capabilities: func(tp *textproto.Conn, opts mailOptions) ([]string, error) {
+            id, err := tp.Cmd("EHLO %s", opts.EHLO)
+            if err != nil { return nil, err }
+            tp.StartResponse(id)
+            defer tp.EndResponse(id)
+            _, msg, err := tp.ReadResponse(250)
+            if err != nil { return nil, err }
+            lines := strings.Split(msg, "\n")
+            return lines[1:], nil
+        },
+        startTLS: func(tp *textproto.Conn) error {
+            id, err := tp.Cmd("STARTTLS")
+            if err != nil { return err }
+            tp.StartResponse(id)
+            defer tp.EndResponse(id)
+            _, _, err = tp.ReadResponse(220)
+            return err
+        },
+        quit: func(tp *textproto.Conn) { _ = tp.PrintfLine("QUIT") },
+    },
+    "imap": {
+        port: 143, tlsPort: 993, tlsCapability: "STARTTLS",
+        greeting: func(tp *textproto.Conn) (string, error) {
+            line, err := tp.ReadLine()
+            if err != nil { return "", err }
+            if !strings.HasPrefix(line, "* OK") && !strings.HasPrefix(line, "* PREAUTH") { return line, fmt.Errorf("unexpected greeting: %s", line) }
+            return line, nil
+        },
+        capabilities: func(tp *textproto.Conn, _ mailOptions) ([]string, error) {
+            var caps []string
+            err := imapCommand(tp, "CAPABILITY", func(line string) {
+                if f := strings.Fields(line); len(f) > 2 && strings.EqualFold(f[1], "CAPABILITY") { caps = append(caps, f[2:]...) }
+            })
+            return caps, err
+        },
+        startTLS: func(tp *textproto.Conn) error { return imapCommand(tp, "STARTTLS", nil) },
+        quit:     func(tp *textproto.Conn) { _ = tp.PrintfLine("z LOGOUT") },
+    },
+    "pop3": {
+        port: 110, tlsPort: 995, tlsCapability: "STLS",
+        greeting: func(tp *textproto.Conn) (string, error) { return pop3Command(tp, "") },
+        capabilities: func(tp *textproto.Conn, _ mailOptions) ([]string, error) {
+            if _, err := pop3Command(tp, "CAPA"); err != nil { return nil, err }
+            return tp.ReadDotLines()
+        },
+        startTLS: func(tp *textproto.Conn) error {
+            _, err := pop3Command(tp, "STLS")
+            return err
+        },
+        quit: func(tp *textproto.Conn) { _ = tp.PrintfLine("QUIT") },
+    },
+}
+
+// imapCommand sends a tagged command and reads untagged lines until the tagged status.
+func imapCommand(tp *textproto.Conn, cmd string, untagged func(string)) error {
+    tag := "a" + strconv.FormatUint(uint64(tp.Next()), 10)
+    if err := tp.PrintfLine("%s %s", tag, cmd); err != nil { return err }
+    for {
+        line, err := tp.ReadLine()
+        if err != nil { return err }
+        if strings.HasPrefix(line, tag+" ") {
+            if f := strings.Fields(line); len(f) < 2 || !strings.EqualFold(f[1], "OK") { return fmt.Errorf("%s: %s", cmd, line) }
+            return nil
+        }
+        if untagged != nil { untagged(line) }
+    }
+}
+
+// pop3Command sends cmd (nothing for the greeting) and expects a +OK status line.
+func pop3Command(tp *textproto.Conn, cmd string) (string, error) {
+    if cmd != "" {
+        if err := tp.PrintfLine("%s", cmd); err != nil { return "", err }
+    }
+    line, err := tp.ReadLine()
+    if err != nil { return "", err }
+    if !strings.HasPrefix(line, "+OK") {
+        if cmd == "" { cmd = "greeting" }
+        return line, fmt.Errorf("%s: %s", cmd, line)
+    }
+    return line, nil
+}
+
+func hasCapability(caps []string, name string) bool {
+    for _, c := range caps {
+        if f := strings.Fields(c); len(f) > 0 && strings.EqualFold(f[0], name) { return true }
+    }
+    return false
+}
+
+// mailCheck talks to an SMTP, IMAP or POP3 server: greeting, capabilities and TLS
+// (implicit or STARTTLS), recording how long every stage took.
+func mailCheck(ctx context.Context, method, target string, opts mailOptions) (ok bool, latency int64, msg string, details map[string]any) {
+    proto, found := mailProtocols[method]
+    if !found { return false, 0, "unsupported mail protocol " + method, nil }
+    opts.TimeoutMs = timeoutMs(ctx, opts.TimeoutMs, 15*time.Second)
+    if opts.EHLO == "" { opts.EHLO = "syharikcheck.local" }
+    host, _, _ := net.SplitHostPort(tcpAddress(target))
+    port := opts.Port
+    if port == 0 { port, _ = strconv.Atoi(explicitPort(target)) }
+    if port == 0 {
+        port = proto.port
+        if strings.EqualFold(opts.TLS, "implicit") { port = proto.tlsPort }
+    }
+    mode := strings.ToLower(opts.TLS)
+    if mode == "" {
+        mode = "starttls"
+        if port == proto.tlsPort { mode = "implicit" }
+    }
+
+    start := time.Now()
+    timings := map[string]float64{}
+    details = map[string]any{"host": host, "port": port, "tls_mode": mode, "timings": timings}
+    finish := func(err error) (bool, int64, string, map[string]any) {
+        timings["total_ms"] = durMs(time.Since(start))
+        if err != nil { return false, time.Since(start).Milliseconds(), err.Error(), details }
+        return msg == "", time.Since(start).Milliseconds(), msg, details
+    }
+
+    stage := time.Now()
+    d := net.Dialer{Timeout: time.Duration(opts.TimeoutMs) * time.Millisecond}
+    conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+    if err != nil { return finish(err) }
+    defer func() { _ = conn.Close() }()
+    stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+    defer stop()
+    _ = conn.SetDeadline(start.Add(time.Duration(opts.TimeoutMs) * time.Millisecond))
+    timings["connect_ms"] = durMs(time.Since(stage))
+    details["remote_addr"] = conn.RemoteAddr().String()
+
+    handshake := func() error {
+        stage := time.Now()
+        tc := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+        if err := tc.Handshake(); err != nil { return fmt.Errorf("TLS handshake: %w", err) }
+        timings["tls_ms"] = durMs(time.Since(stage))
+        conn = tc
+        tlsDet, tlsMsg := describeTLS(tc.ConnectionState(), host)
+        details["tls"] = tlsDet
+        msg = tlsMsg
+        return nil
+    }
+    if mode == "implicit" {
+        if err := handshake(); err != nil { return finish(err) }
+    }
+    tp := textproto.NewConn(conn)
+
+    stage = time.Now()
+    banner, err := proto.greeting(tp)
+    details["banner"] = banner
+    if err != nil { return finish(fmt.Errorf("greeting: %w", err)) }
+    timings["greeting_ms"] = durMs(time.Since(stage))
+
+    stage = time.Now()
+    caps, err := proto.capabilities(tp, opts)
+    if err != nil { return finish(fmt.Errorf("capabilities: %w", err)) }
+    timings["capabilities_ms"] = durMs(time.Since(stage))
+    details["capabilities"] = caps
+
+    if mode == "starttls" {
+        offered := hasCapability(caps, proto.tlsCapability)
+        details["starttls_offered"] = offered
+        if !offered {
+            if opts.RequireTLS { return finish(fmt.Errorf("server does not offer %s", proto.tlsCapability)) }
+        } else {
+            stage = time.Now()
+            if err := proto.startTLS(tp); err != nil { return finish(fmt.Errorf("%s: %w", proto.tlsCapability, err)) }
+            timings["starttls_ms"] = durMs(time.Since(stage))
+            if err := handshake(); err != nil { return finish(err) }
+            tp = textproto.NewConn(conn)
+            // capabilities may change once the channel is encrypted
+            if caps, err := proto.capabilities(tp, opts); err == nil { details["capabilities_tls"] = caps }
+        }
+    }
+    proto.quit(tp)
+    return finish(nil)
+}
@@ -0,0 +1,177 @@
+package checker
+
+import (
+    "context"
+    "crypto/tls"
+    "net"
+    "net/textproto"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// fakeMailServer serves one connection on a loopback port with serve and returns the
+// target to check.
+func fakeMailServer(t *testing.T, serve func(t *testing.T, c net.Conn, tp *textproto.Conn)) string {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+    t.Cleanup(func() { _ = ln.Close() })
+    go func() {
+        c, err := ln.Accept()
+        if err != nil { return }
+        defer c.Close()
+        serve(t, c, textproto.NewConn(c))
+    }()
+    return ln.Addr().String()
+}
+
+// reply reads one command, checks that it starts with want and answers with lines.
+func reply(t *testing.T, tp *textproto.Conn, want string, lines ...string) bool {
+    cmd, err := tp.ReadLine()
+    if err != nil || !strings.HasPrefix(cmd, want) {
+        t.Errorf("server read %q (%v), want %q", cmd, err, want)
+        return false
+    }
+    for _, l := range lines { _ = tp.PrintfLine("%s", l) }
+    return true
+}
+
+// imapReply answers an IMAP command with untagged lines and the tagged status.
+func imapReply(t *testing.T, tp *textproto.Conn, want, status string, untagged ...string) bool {
+    cmd, err := tp.ReadLine()
+    tag, rest, _ := strings.Cut(cmd, " ")
+    if err != nil || rest != want {
+        t.Errorf("server read %q (%v), want %q", cmd, err, want)
+        return false
+    }
+    for _, l := range untagged { _ = tp.PrintfLine("%s", l) }
+    _ = tp.PrintfLine("%s %s", tag, status)
+    return true
+}
+
+// upgrade completes the server side of STARTTLS.
+func upgrade(t *testing.T, c net.Conn) *textproto.Conn {
+    return textproto.NewConn(tls.Server(c, &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}))
+}
+
+var ehloCaps = []string{"250-mx.test Hello", "250-PIPELINING", "250-SIZE 10240000", "250-STARTTLS", "250 8BITMIME"}
+
+func TestMailCheck(t *testing.T) {
+    tests := []struct {
+        name    string
+        method  string
+        opts    mailOptions
+        serve   func(t *testing.T, c net.Conn, tp *textproto.Conn)
+        err     string
+        details map[string]any
+    }{
+        {
+            name:   "smtp multi-line ehlo",
+            method: "smtp",
+            serve: func(t *testing.T, c net.Conn, tp *textproto.Conn) {
+                _ = tp.PrintfLine("220 mx.test ESMTP")
+                if reply(t, tp, "EHLO syharikcheck.local", "250-mx.test Hello", "250-PIPELINING", "250-SIZE 10240000", "250 8BITMIME") {
+                    reply(t, tp, "QUIT", "221 bye")
+                }
+            },
+            details: map[string]any{
+                "banner":           "mx.test ESMTP",
+                "capabilities":     []string{"PIPELINING", "SIZE 10240000", "8BITMIME"},
+                "starttls_offered": false,
+            },
+        },
+        {
+            name:   "smtp starttls required but not offered",
+            method: "smtp",
+            opts:   mailOptions{RequireTLS: true, EHLO: "probe.test"},
+            serve: func(t *testing.T, c net.Conn, tp *textproto.Conn) {
+                _ = tp.PrintfLine("220 mx.test ESMTP")
+                reply(t, tp, "EHLO probe.test", "250 mx.test")
+            },
+            err: "server does not offer STARTTLS",
+        },
+        {
+            name:   "smtp starttls refused",
+            method: "smtp",
+            serve: func(t *testing.T, c net.Conn, tp *textproto.Conn) {
+                _ = tp.PrintfLine("220 mx.test ESMTP")
+                if reply(t, tp, "EHLO", ehloCaps...) { reply(t, tp, "STARTTLS", "454 4.7.0 TLS not available") }
+            },
+            err:     `STARTTLS: 454 "4.7.0 TLS not available"`,
+            details: map[string]any{"starttls_offered": true},
+        },
+        {
+            name:   "smtp starttls accepted",
+            method: "smtp",
+            serve: func(t *testing.T, c net.Conn, tp *textproto.Conn) {
+                _ = tp.PrintfLine("220 mx.test ESMTP")
+                if !reply(t, tp, "EHLO", ehloCaps...) || !reply(t, tp, "STARTTLS", "220 2.0.0 Ready") { return }
+                tp = upgrade(t, c)
+                if reply(t, tp, "EHLO", "250-mx.test Hello", "250 AUTH PLAIN LOGIN") { reply(t, tp, "QUIT", "221 bye") }
+            },
+            details: map[string]any{"starttls_offered": true, "capabilities_tls": []string{"AUTH PLAIN LOGIN"}},
+        },
+        {
+            name:   "smtp greeting refused",
+            method: "smtp",
+            serve: func(t *testing.T, c net.Conn, tp *textproto.Conn) {
+                _ = tp.PrintfLine("554 5.7.1 no service for you")
+            },
+            err: `greeting: 554 "5.7.1 no service for you"`,
+        },
+        {
+            name:   "imap bye",
+            method: "imap",
+            serve: func(t *testing.T, c net.Conn, tp *textproto.Conn) {
+                _ = tp.PrintfLine("* BYE too many connections")
+            },
+            err:     "greeting: unexpected greeting: * BYE too many connections",
+            details: map[string]any{"banner": "* BYE too many connections"},
+        },
+        {
+            name:   "imap starttls refused",
+            method: "imap",
+            serve: func(t *testing.T, c net.Conn, tp *textproto.Conn) {
+                _ = tp.PrintfLine("* OK IMAP ready")
+                if imapReply(t, tp, "CAPABILITY", "OK done", "* CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED") {
+                    imapReply(t, tp, "STARTTLS", "BAD not now")
+                }
+            },
+            err:     "STARTTLS: STARTTLS: a1 BAD not now",
+            details: map[string]any{"capabilities": []string{"IMAP4rev1", "STARTTLS", "LOGINDISABLED"}, "starttls_offered": true},
+        },
+        {
+            name:   "pop3 greeting error",
+            method: "pop3",
+            serve: func(t *testing.T, c net.Conn, tp *textproto.Conn) {
+                _ = tp.PrintfLine("-ERR server busy")
+            },
+            err:     "greeting: greeting: -ERR server busy",
+            details: map[string]any{"banner": "-ERR server busy"},
+        },
+        {
+            name:   "pop3 without capa",
+            method: "pop3",
+            serve: func(t *testing.T, c net.Conn, tp *textproto.Conn) {
+                _ = tp.PrintfLine("+OK POP3 ready")
+                reply(t, tp, "CAPA", "-ERR unknown command")
+            },
+            err: "capabilities: CAPA: -ERR unknown command",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            target := fakeMailServer(t, tt.serve)
+            ok, _, msg, details := mailCheck(context.Background(), tt.method, target, tt.opts)
+            if tt.err != "" {
+                if ok || msg != tt.err { t.Errorf("ok = %v, msg = %q, want error %q", ok, msg, tt.err) }
+            } else if _, upgraded := details["tls"]; !upgraded && !ok {
+                // a self-signed certificate fails the check with a TLS warning only
+                t.Errorf("check failed: %s", msg)
+            }
+            for k, want := range tt.details {
+                if got := details[k]; !reflect.DeepEqual(got, want) { t.Errorf("details[%s] = %#v, want %#v", k, got, want) }
+            }
+        })
+    }
+}
@@ -0,0 +1,475 @@
+package checker
+
+import (
+    "context"
+    "crypto/ed25519"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/base64"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "aeza/internal/dnsclient"
+
+    "github.com/miekg/dns"
+    "golang.org/x/net/publicsuffix"
+)
+
+// mailAuthOptions are the per-task parameters of the mailauth method.
+type mailAuthOptions struct {
+    // DKIMSelectors are checked at <selector>._domainkey.<domain>; DKIM selectors cannot be discovered.
+    DKIMSelectors []string `json:"dkim_selectors"`
+    TimeoutMs     int      `json:"timeout_ms"`
+}
+
+// mailFinding is one problem (or notable fact) found by the audit.
+type mailFinding struct {
+    Check    string `json:"check"`
+    // Severity is error, warning or info; errors fail the check.
+    Severity string `json:"severity"`
+    Message  string `json:"message"`
+}
+
+type mailAuditor struct {
+    ctx      context.Context
+    // lookup sends a recursive query, normally dnsclient.Client.Lookup
+    lookup   func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error)
+    findings []mailFinding
+}
+
+func (a *mailAuditor) add(check, severity, format string, args ...any) {
+    a.findings = append(a.findings, mailFinding{Check: check, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// txt returns the TXT strings at name (multi-string records joined) and whether the name exists.
+func (a *mailAuditor) txt(name string) ([]string, bool, error) {
+    resp, err := a.lookup(a.ctx, name, dns.TypeTXT)
+    if err != nil { return nil, false, err }
+    if resp.Rcode == dns.RcodeNameError { return nil, false, nil }
+    if resp.Rcode != dns.RcodeSuccess { return nil, false, fmt.Errorf("%s TXT: %s", name, dns.RcodeToString[resp.Rcode]) }
+    var out []string
+    for _, rr := range resp.Answer {
+        if t, ok := rr.(*dns.TXT); ok { out = append(out, strings.Join(t.Txt, "")) }
+    }
+    return out, true, nil
+}
+
+// prefixed picks the records that start with a version tag such as "v=spf1".
+func prefixed(records []string, tag string) []string {
+    var out []string
+    for _, r := range records {
+        l := strings.ToLower(strings.TrimSpace(r))
+        if l == tag || strings.HasPrefix(l, tag+" ") || strings.HasPrefix(l, tag+";") { out = append(out, strings.TrimSpace(r)) }
+    }
+    return out
+}
+
+// parseTags splits "k=v; k2=v2" records (DMARC, DKIM, MTA-STS, TLS-RPT, BIMI).
+func parseTags(record string) map[string]string {
+    out := map[string]string{}
+    for _, part := range strings.Split(record, ";") {
+        k, v, ok := strings.Cut(part, "=")
+        if !ok { continue }
+        out[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+    }
+    return out
+}
+
+// spfNode is one record of the SPF include tree.
+type spfNode struct {
+    Domain   string     `json:"domain"`
+    Record   string     `json:"record,omitempty"`
+    Lookups  int        `json:"lookups"`
+    Includes []*spfNode `json:"includes,omitempty"`
+    Error    string     `json:"error,omitempty"`
+}
+
+// spfWalker counts DNS-querying terms across includes and redirects (RFC 7208 section 4.6.4).
+type spfWalker struct {
+    *mailAuditor
+    lookups, voids int
+    seen           map[string]bool
+}
+
+func (w *spfWalker) walk(domain string, depth int) *spfNode {
+    node := &spfNode{Domain: domain}
+    key := strings.ToLower(strings.TrimSuffix(domain, "."))
+    if w.seen[key] {
+        node.Error = "include loop"
+        w.add("spf", "error", "SPF include loop at %s", domain)
+        return node
+    }
+    if depth > 10 {
+        node.Error = "too deeply nested"
+        w.add("spf", "error", "SPF includes are nested too deeply at %s", domain)
+        return node
+    }
+    w.seen[key] = true
+    defer delete(w.seen, key)
+
+    records, exists, err := w.txt(domain)
+    if err != nil {
+        node.Error = err.Error()
+        w.add("spf", "error", "SPF lookup for %s failed: %v", domain, err)
+        return node
+    }
+    spf := prefixed(records, "v=spf1")
+    switch {
+    case len(spf) == 0:
+        node.Error = "no SPF record"
+        if depth == 0 {
+            w.add("spf", "error", "no SPF record at %s", domain)
+            return node
+        }
+        if !exists || len(records) == 0 { w.voids++ }
+        w.add("spf", "error", "included domain %s has no SPF record (permerror)", domain)
+        return node
+    case len(spf) > 1:
+        node.Error = "multiple SPF records"
+        w.add("spf", "error", "%s publishes %d SPF records (permerror)", domain, len(spf))
+    }
+    node.Record = spf[0]
+
+    hasAll := false
+    var redirect string
+    for _, term := range strings.Fields(spf[0])[1:] {
+        t := strings.ToLower(term)
+        qualifier := "+"
+        if strings.ContainsAny(t[:1], "+-~?") {
+            qualifier, t = t[:1], t[1:]
+        }
+        name, arg := t, ""
+        if i := strings.IndexAny(t, ":=/"); i >= 0 { name, arg = t[:i], strings.TrimLeft(t[i:], ":=") }
+        switch name {
+        case "include":
+            node.Lookups++
+            if arg == "" {
+                w.add("spf", "error", "%s in %s has no domain (permerror)", term, domain)
+                continue
+            }
+            if strings.Contains(arg, "%") { continue } // macros are expanded per message
+            node.Includes = append(node.Includes, w.walk(arg, depth+1))
+        case "redirect":
+            node.Lookups++
+            if arg == "" { w.add("spf", "error", "%s in %s has no domain (permerror)", term, domain) }
+            redirect = arg
+        case "a", "mx", "exists":
+            node.Lookups++
+        case "ptr":
+            node.Lookups++
+            w.add("spf", "warning", "%s uses the ptr mechanism, which is deprecated and slow", domain)
+        case "all":
+            hasAll = true
+            if depth == 0 {
+                switch qualifier {
+                case "+":
+                    w.add("spf", "error", "+all allows any server to send mail for %s", domain)
+                case "?":
+                    w.add("spf", "warning", "?all is neutral and gives no protection")
+                }
+            }
+        case "ip4", "ip6":
+            cidr := arg
+            if !strings.Contains(cidr, "/") { cidr += map[string]string{"ip4": "/32", "ip6": "/128"}[name] }
+            if _, _, err := net.ParseCIDR(cidr); err != nil { w.add("spf", "error", "invalid %s in %s", term, domain) }
+        case "exp":
+        default:
+            if !strings.Contains(t, "=") { w.add("spf", "error", "unknown SPF mechanism %q in %s (permerror)", term, domain) }
+        }
+    }
+    w.lookups += node.Lookups
+    if redirect != "" && !hasAll && !strings.Contains(redirect, "%") {
+        node.Includes = append(node.Includes, w.walk(redirect, depth+1))
+    }
+    if depth == 0 && !hasAll && redirect == "" {
+        w.add("spf", "warning", "SPF record has no all mechanism; unmatched senders get a neutral result")
+    }
+    return node
+}
+
+func (a *mailAuditor) spf(domain string) map[string]any {
+    w := &spfWalker{mailAuditor: a, seen: map[string]bool{}}
+    tree := w.walk(domain, 0)
+    if tree.Record == "" { return nil }
+    if w.lookups > 10 { a.add("spf", "error", "SPF needs %d DNS lookups, more than the limit of 10 (permerror)", w.lookups) }
+    if w.voids > 2 { a.add("spf", "error", "SPF has %d void lookups, more than the limit of 2", w.voids) }
+    return map[string]any{"record": tree.Record, "lookups": w.lookups, "void_lookups": w.voids, "tree": tree}
+}
+
+func (a *mailAuditor) dmarc(domain string) map[string]string {
+    name := "_dmarc." + domain
+    records, _, err := a.txt(name)
+    if err != nil {
+        a.add("dmarc", "error", "DMARC lookup failed: %v", err)
+        return nil
+    }
+    dm := prefixed(records, "v=dmarc1")
+    // without a record of its own the organizational domain's policy applies
+    if len(dm) == 0 {
+        if org, err := publicsuffix.EffectiveTLDPlusOne(domain); err == nil && org != domain {
+            name = "_dmarc." + org
+            if records, _, err = a.txt(name); err == nil { dm = prefixed(records, "v=dmarc1") }
+        }
+    }
+    if len(dm) == 0 {
+        a.add("dmarc", "error", "no DMARC record at _dmarc.%s", domain)
+        return nil
+    }
+    if len(dm) > 1 { a.add("dmarc", "error", "%s has %d DMARC records; receivers ignore all of them", name, len(dm)) }
+    tags := parseTags(dm[0])
+    tags["record"], tags["source"] = dm[0], name
+    switch strings.ToLower(tags["p"]) {
+    case "reject", "quarantine":
+    case "none":
+        a.add("dmarc", "warning", "DMARC policy is p=none: failing mail is only reported, not rejected")
+    case "":
+        a.add("dmarc", "error", "DMARC record has no p= policy")
+    default:
+        a.add("dmarc", "error", "invalid DMARC policy p=%s", tags["p"])
+    }
+    if pct, err := strconv.Atoi(tags["pct"]); err == nil && pct < 100 {
+        a.add("dmarc", "info", "DMARC policy applies to %d%% of failing mail", pct)
+    }
+    if tags["rua"] == "" { a.add("dmarc", "info", "no rua= address: you will not receive DMARC aggregate reports") }
+    return tags
+}
+
+func (a *mailAuditor) dkim(domain string, selectors []string) []map[string]any {
+    if len(selectors) == 0 {
+        a.add("dkim", "info", "no DKIM selectors given; DKIM keys cannot be discovered from DNS")
+        return nil
+    }
+    out := make([]map[string]any, 0, len(selectors))
+    for _, sel := range selectors {
+        sel = strings.TrimSpace(sel)
+        if sel == "" { continue }
+        name := sel + "._domainkey." + domain
+        res := map[string]any{"selector": sel, "name": name}
+        out = append(out, res)
+        records, _, err := a.txt(name)
+        if err != nil {
+            res["error"] = err.Error()
+            a.add("dkim", "error", "DKIM lookup for selector %s failed: %v", sel, err)
+            continue
+        }
+        var rec string
+        for _, r := range records {
+            if strings.Contains(r, "p=") { rec = r }
+        }
+        if rec == "" {
+            res["error"] = "no DKIM key"
+            a.add("dkim", "error", "no DKIM key at %s", name)
+            continue
+        }
+        tags := parseTags(rec)
+        res["record"] = rec
+        keyType := strings.ToLower(tags["k"])
+        if keyType == "" { keyType = "rsa" }
+        res["key_type"] = keyType
+        p := strings.Join(strings.Fields(tags["p"]), "")
+        if p == "" {
+            a.add("dkim", "error", "DKIM key for selector %s is revoked (empty p=)", sel)
+            continue
+        }
+        der, err := base64.StdEncoding.DecodeString(p)
+        if err != nil {
+            a.add("dkim", "error", "DKIM key for selector %s is not valid base64", sel)
+            continue
+        }
+        if keyType == "ed25519" {
+            if len(der) != ed25519.PublicKeySize { a.add("dkim", "error", "DKIM ed25519 key for selector %s has wrong size", sel) }
+            res["key_bits"] = 256
+            continue
+        }
+        pub, err := x509.ParsePKIXPublicKey(der)
+        if err != nil {
+            // some signers publish a bare PKCS#1 key
+            pub, err = x509.ParsePKCS1PublicKey(der)
+        }
+        rk, ok := pub.(*rsa.PublicKey)
+        if err != nil || !ok {
+            a.add("dkim", "error", "DKIM key for selector %s cannot be parsed", sel)
+            continue
+        }
+        bits := rk.N.BitLen()
+        res["key_bits"] = bits
+        switch {
+        case bits < 1024:
+            a.add("dkim", "error", "DKIM key for selector %s is only %d bits", sel, bits)
+        case bits < 2048:
+            a.add("dkim", "warning", "DKIM key for selector %s is %d bits; 2048 is recommended", sel, bits)
+        }
+        if strings.Contains(tags["t"], "y") { a.add("dkim", "info", "selector %s is in testing mode (t=y)", sel) }
+    }
+    return out
+}
+
+// mxMatches applies an MTA-STS mx pattern, where "*." matches exactly one label.
+func mxMatches(pattern, host string) bool {
+    pattern, host = strings.ToLower(strings.TrimSuffix(pattern, ".")), strings.ToLower(strings.TrimSuffix(host, "."))
+    if strings.HasPrefix(pattern, "*.") {
+        _, rest, ok := strings.Cut(host, ".")
+        return ok && rest == pattern[2:]
+    }
+    return pattern == host
+}
+
+func (a *mailAuditor) mtaSTS(domain string) map[string]any {
+    records, _, err := a.txt("_mta-sts." + domain)
+    if err != nil {
+        a.add("mta-sts", "error", "MTA-STS lookup failed: %v", err)
+        return nil
+    }
+    sts := prefixed(records, "v=stsv1")
+    if len(sts) == 0 {
+        a.add("mta-sts", "info", "MTA-STS is not configured; STARTTLS to your MX can be downgraded")
+        return nil
+    }
+    res := map[string]any{"record": sts[0], "id": parseTags(sts[0])["id"]}
+    policyURL := "https://mta-sts." + domain + "/.well-known/mta-sts.txt"
+    res["policy_url"] = policyURL
+    client := &http.Client{
+        Timeout: 10 * time.Second,
+        // RFC 8461: the policy must not be fetched through redirects
+        CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+    }
+    req, _ := http.NewRequestWithContext(a.ctx, http.MethodGet, policyURL, nil)
+    resp, err := client.Do(req)
+    if err != nil {
+        a.add("mta-sts", "error", "MTA-STS policy cannot be fetched: %v", err)
+        return res
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        a.add("mta-sts", "error", "MTA-STS policy returned HTTP %d", resp.StatusCode)
+        return res
+    }
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+    res["policy"] = a.stsPolicy(domain, body)
+    return res
+}
+
+// stsPolicy parses an MTA-STS policy file, checks its fields and that it covers every MX of domain.
+func (a *mailAuditor) stsPolicy(domain string, body []byte) map[string]any {
+    policy := map[string]any{}
+    var mx []string
+    for _, line := range strings.Split(string(body), "\n") {
+        k, v, ok := strings.Cut(line, ":")
+        if !ok { continue }
+        k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+        if k == "mx" {
+            mx = append(mx, v)
+        } else {
+            policy[k] = v
+        }
+    }
+    policy["mx"] = mx
+    switch policy["mode"] {
+    case "enforce":
+    case "testing":
+        a.add("mta-sts", "info", "MTA-STS is in testing mode: failures are reported but not enforced")
+    case "none":
+        a.add("mta-sts", "warning", "MTA-STS mode is none")
+    default:
+        a.add("mta-sts", "error", "MTA-STS policy has an invalid mode %v", policy["mode"])
+    }
+    if policy["version"] != "STSv1" { a.add("mta-sts", "error", "MTA-STS policy version is %v, expected STSv1", policy["version"]) }
+    if age, err := strconv.Atoi(fmt.Sprint(policy["max_age"])); err != nil || age <= 0 {
+        a.add("mta-sts", "error", "MTA-STS policy has no valid max_age")
+    } else if age < 86400 {
+        a.add("mta-sts", "warning", "MTA-STS max_age is only %d seconds", age)
+    }
+    if resp, err := a.lookup(a.ctx, domain, dns.TypeMX); err == nil {
+        for _, rr := range resp.Answer {
+            m, ok := rr.(*dns.MX)
+            if !ok { continue }
+            covered := false
+            for _, p := range mx {
+                if mxMatches(p, m.Mx) { covered = true }
+            }
+            if !covered { a.add("mta-sts", "error", "MX %s is not listed in the MTA-STS policy", strings.TrimSuffix(m.Mx, ".")) }
+        }
+    }
+    return policy
+}
+
+func (a *mailAuditor) tlsRPT(domain string, mtaSTS bool) map[string]string {
+    records, _, err := a.txt("_smtp._tls." + domain)
+    if err != nil {
+        a.add("tls-rpt", "error", "TLS-RPT lookup failed: %v", err)
+        return nil
+    }
+    rpt := prefixed(records, "v=tlsrptv1")
+    if len(rpt) == 0 {
+        sev := "info"
+        if mtaSTS { sev = "warning" }
+        a.add("tls-rpt", sev, "TLS-RPT is not configured; you will not hear about TLS delivery failures")
+        return nil
+    }
+    tags := parseTags(rpt[0])
+    tags["record"] = rpt[0]
+    if tags["rua"] == "" { a.add("tls-rpt", "error", "TLS-RPT record has no rua= destination") }
+    return tags
+}
+
+func (a *mailAuditor) bimi(domain string, dmarc map[string]string) map[string]string {
+    records, _, err := a.txt("default._bimi." + domain)
+    if err != nil {
+        a.add("bimi", "error", "BIMI lookup failed: %v", err)
+        return nil
+    }
+    b := prefixed(records, "v=bimi1")
+    if len(b) == 0 {
+        a.add("bimi", "info", "BIMI is not configured")
+        return nil
+    }
+    tags := parseTags(b[0])
+    tags["record"] = b[0]
+    if l := tags["l"]; l != "" && !strings.HasPrefix(strings.ToLower(l), "https://") {
+        a.add("bimi", "error", "BIMI logo location must be an https URL")
+    }
+    if tags["a"] == "" { a.add("bimi", "info", "BIMI record has no VMC (a=); Gmail and Apple Mail require one") }
+    p := strings.ToLower(dmarc["p"])
+    if p != "quarantine" && p != "reject" {
+        a.add("bimi", "warning", "BIMI requires a DMARC policy of quarantine or reject")
+    } else if pct, err := strconv.Atoi(dmarc["pct"]); err == nil && pct < 100 {
+        a.add("bimi", "warning", "BIMI requires DMARC pct=100")
+    }
+    return tags
+}
+
+// mailAuthCheck audits the email authentication setup of a domain. It fails when any
+// finding has error severity.
+func mailAuthCheck(ctx context.Context, target string, opts mailAuthOptions) (ok bool, latency int64, msg string, details map[string]any) {
+    start := time.Now()
+    domain := strings.ToLower(strings.TrimSuffix(hostnameForDNS(target), "."))
+    if domain == "" { return false, 0, "empty domain", nil }
+    timeout := time.Duration(opts.TimeoutMs) * time.Millisecond
+    if timeout <= 0 { timeout = 3 * time.Second }
+    ctx, cancel := context.WithTimeout(ctx, checkTimeout(ctx, 60*time.Second))
+    defer cancel()
+    a := &mailAuditor{ctx: ctx, lookup: dnsclient.New(timeout).Lookup}
+
+    details = map[string]any{"domain": domain}
+    details["spf"] = a.spf(domain)
+    dmarc := a.dmarc(domain)
+    details["dmarc"] = dmarc
+    details["dkim"] = a.dkim(domain, opts.DKIMSelectors)
+    sts := a.mtaSTS(domain)
+    details["mta_sts"] = sts
+    details["tls_rpt"] = a.tlsRPT(domain, sts != nil)
+    details["bimi"] = a.bimi(domain, dmarc)
+    if a.findings == nil { a.findings = []mailFinding{} }
+    details["findings"] = a.findings
+
+    var errs []string
+    for _, f := range a.findings {
+        if f.Severity == "error" { errs = append(errs, f.Message) }
+    }
+    return len(errs) == 0, time.Since(start).Milliseconds(), strings.Join(errs, "; "), details
+}
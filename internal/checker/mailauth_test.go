@@ -0,0 +1,282 @@
+package checker
+
+import (
+    "context"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/miekg/dns"
+)
+
+// fakeLookup answers from zone-file records: NXDOMAIN for names without any record,
+// an empty NOERROR answer for names that only have other types.
+func fakeLookup(t *testing.T, records ...string) func(context.Context, string, uint16) (*dns.Msg, error) {
+    zone := map[string][]dns.RR{}
+    for _, s := range records {
+        rr, err := dns.NewRR(s)
+        if err != nil { t.Fatalf("%q: %v", s, err) }
+        name := strings.ToLower(rr.Header().Name)
+        zone[name] = append(zone[name], rr)
+    }
+    return func(_ context.Context, name string, qtype uint16) (*dns.Msg, error) {
+        m := new(dns.Msg).SetQuestion(dns.Fqdn(name), qtype)
+        rrs, ok := zone[strings.ToLower(dns.Fqdn(name))]
+        if !ok {
+            m.Rcode = dns.RcodeNameError
+            return m, nil
+        }
+        for _, rr := range rrs {
+            if rr.Header().Rrtype == qtype { m.Answer = append(m.Answer, rr) }
+        }
+        return m, nil
+    }
+}
+
+// messages lists the findings as "severity: message".
+func messages(a *mailAuditor) []string {
+    var out []string
+    for _, f := range a.findings { out = append(out, f.Severity+": "+f.Message) }
+    return out
+}
+
+func TestSPF(t *testing.T) {
+    tests := []struct {
+        name     string
+        records  []string
+        lookups  int
+        voids    int
+        includes []string
+        findings []string
+    }{
+        {
+            name:    "addresses only",
+            records: []string{`example.com. TXT "v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 -all"`},
+        },
+        {
+            name: "include",
+            records: []string{
+                `example.com. TXT "v=spf1 include:_SPF.Example.NET -all"`,
+                `_spf.example.net. TXT "v=spf1 a mx ~all"`,
+            },
+            lookups:  3,
+            includes: []string{"_spf.example.net"},
+        },
+        {
+            name:     "include without a domain",
+            records:  []string{`example.com. TXT "v=spf1 include -all"`},
+            lookups:  1,
+            findings: []string{"error: include in example.com has no domain (permerror)"},
+        },
+        {
+            name: "redirect",
+            records: []string{
+                `example.com. TXT "v=spf1 redirect=_spf.example.com"`,
+                `_spf.example.com. TXT "v=spf1 ip4:192.0.2.1 -all"`,
+            },
+            lookups:  1,
+            includes: []string{"_spf.example.com"},
+        },
+        {
+            name:    "redirect without a domain",
+            records: []string{`example.com. TXT "v=spf1 redirect="`},
+            lookups: 1,
+            findings: []string{
+                "error: redirect= in example.com has no domain (permerror)",
+                "warning: SPF record has no all mechanism; unmatched senders get a neutral result",
+            },
+        },
+        {
+            name: "lookup limit",
+            records: []string{
+                `example.com. TXT "v=spf1 include:a.example.com include:b.example.com -all"`,
+                `a.example.com. TXT "v=spf1 a mx exists:%{i}.x.example.com a:one.example.com mx:two.example.com -all"`,
+                `b.example.com. TXT "v=spf1 a mx a:three.example.com mx:four.example.com -all"`,
+            },
+            lookups:  11,
+            includes: []string{"a.example.com", "b.example.com"},
+            findings: []string{"error: SPF needs 11 DNS lookups, more than the limit of 10 (permerror)"},
+        },
+        {
+            name: "void limit",
+            records: []string{
+                `example.com. TXT "v=spf1 include:gone1.example.com include:gone2.example.com include:gone3.example.com -all"`,
+                `gone3.example.com. A 192.0.2.1`,
+            },
+            lookups:  3,
+            voids:    3,
+            includes: []string{"gone1.example.com", "gone2.example.com", "gone3.example.com"},
+            findings: []string{
+                "error: included domain gone1.example.com has no SPF record (permerror)",
+                "error: included domain gone2.example.com has no SPF record (permerror)",
+                "error: included domain gone3.example.com has no SPF record (permerror)",
+                "error: SPF has 3 void lookups, more than the limit of 2",
+            },
+        },
+        {
+            name: "include loop",
+            records: []string{
+                `example.com. TXT "v=spf1 include:a.example.com -all"`,
+                `a.example.com. TXT "v=spf1 include:example.com -all"`,
+            },
+            lookups:  2,
+            includes: []string{"a.example.com"},
+            findings: []string{"error: SPF include loop at example.com"},
+        },
+        {
+            name:     "pass all",
+            records:  []string{`example.com. TXT "v=spf1 mx +all"`},
+            lookups:  1,
+            findings: []string{"error: +all allows any server to send mail for example.com"},
+        },
+        {
+            name:    "two records",
+            records: []string{`example.com. TXT "v=spf1 -all"`, `example.com. TXT "v=spf1 mx -all"`},
+            findings: []string{"error: example.com publishes 2 SPF records (permerror)"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            a := &mailAuditor{ctx: context.Background(), lookup: fakeLookup(t, tt.records...)}
+            spf := a.spf("example.com")
+            if spf == nil { t.Fatalf("no SPF result, findings %q", messages(a)) }
+            if spf["lookups"] != tt.lookups || spf["void_lookups"] != tt.voids {
+                t.Errorf("lookups = %v, voids = %v, want %d, %d", spf["lookups"], spf["void_lookups"], tt.lookups, tt.voids)
+            }
+            var includes []string
+            for _, n := range spf["tree"].(*spfNode).Includes { includes = append(includes, n.Domain) }
+            if !reflect.DeepEqual(includes, tt.includes) { t.Errorf("includes = %q, want %q", includes, tt.includes) }
+            if got := messages(a); !reflect.DeepEqual(got, tt.findings) { t.Errorf("findings = %q, want %q", got, tt.findings) }
+        })
+    }
+}
+
+func TestSPFMissing(t *testing.T) {
+    a := &mailAuditor{ctx: context.Background(), lookup: fakeLookup(t, `example.com. TXT "google-site-verification=abc"`)}
+    if spf := a.spf("example.com"); spf != nil { t.Errorf("spf = %v, want nil", spf) }
+    if got, want := messages(a), []string{"error: no SPF record at example.com"}; !reflect.DeepEqual(got, want) { t.Errorf("findings = %q, want %q", got, want) }
+}
+
+func TestParseTags(t *testing.T) {
+    tests := []struct {
+        record string
+        want   map[string]string
+    }{
+        {"v=DMARC1; p=reject; rua=mailto:dmarc@example.com", map[string]string{"v": "DMARC1", "p": "reject", "rua": "mailto:dmarc@example.com"}},
+        {" V = DMARC1 ;P=none;;", map[string]string{"v": "DMARC1", "p": "none"}},
+        {"v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEB==", map[string]string{"v": "DKIM1", "k": "rsa", "p": "MIGfMA0GCSqGSIb3DQEB=="}},
+        {"v=STSv1; id=20240101; junk", map[string]string{"v": "STSv1", "id": "20240101"}},
+        {"", map[string]string{}},
+    }
+    for _, tt := range tests {
+        if got := parseTags(tt.record); !reflect.DeepEqual(got, tt.want) { t.Errorf("parseTags(%q) = %v, want %v", tt.record, got, tt.want) }
+    }
+}
+
+func TestDMARC(t *testing.T) {
+    tests := []struct {
+        name     string
+        domain   string
+        records  []string
+        source   string
+        findings []string
+    }{
+        {
+            name:    "own record",
+            domain:  "example.com",
+            records: []string{`_dmarc.example.com. TXT "v=DMARC1; p=reject; rua=mailto:d@example.com"`},
+            source:  "_dmarc.example.com",
+        },
+        {
+            name:    "organizational domain",
+            domain:  "mail.example.co.uk",
+            records: []string{`_dmarc.example.co.uk. TXT "v=DMARC1; p=quarantine; pct=50; rua=mailto:d@example.co.uk"`},
+            source:  "_dmarc.example.co.uk",
+            findings: []string{"info: DMARC policy applies to 50% of failing mail"},
+        },
+        {
+            name:   "subdomain record wins",
+            domain: "mail.example.com",
+            records: []string{
+                `_dmarc.mail.example.com. TXT "v=DMARC1; p=none; rua=mailto:d@example.com"`,
+                `_dmarc.example.com. TXT "v=DMARC1; p=reject; rua=mailto:d@example.com"`,
+            },
+            source:   "_dmarc.mail.example.com",
+            findings: []string{"warning: DMARC policy is p=none: failing mail is only reported, not rejected"},
+        },
+        {
+            name:     "none anywhere",
+            domain:   "mail.example.com",
+            records:  []string{`_dmarc.example.com. TXT "not dmarc"`},
+            findings: []string{"error: no DMARC record at _dmarc.mail.example.com"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            a := &mailAuditor{ctx: context.Background(), lookup: fakeLookup(t, tt.records...)}
+            tags := a.dmarc(tt.domain)
+            if tags["source"] != tt.source { t.Errorf("source = %q, want %q", tags["source"], tt.source) }
+            if got := messages(a); !reflect.DeepEqual(got, tt.findings) { t.Errorf("findings = %q, want %q", got, tt.findings) }
+        })
+    }
+}
+
+func TestSTSPolicy(t *testing.T) {
+    mx := []string{"example.com. MX 10 mx1.example.com.", "example.com. MX 20 relay.example.net."}
+    tests := []struct {
+        name     string
+        body     string
+        mx       []string
+        findings []string
+    }{
+        {
+            name: "enforce",
+            body: "version: STSv1\r\nmode: enforce\r\nmx: mx1.example.com\r\nmx: *.example.net\r\nmax_age: 604800\r\n",
+            mx:   []string{"mx1.example.com", "*.example.net"},
+        },
+        {
+            name:     "uncovered mx",
+            body:     "version: STSv1\nmode: testing\nmx: mx1.example.com\nmax_age: 86400\n",
+            mx:       []string{"mx1.example.com"},
+            findings: []string{
+                "info: MTA-STS is in testing mode: failures are reported but not enforced",
+                "error: MX relay.example.net is not listed in the MTA-STS policy",
+            },
+        },
+        {
+            name: "wildcard matches one label only",
+            body: "version: STSv1\nmode: enforce\nmx: mx1.example.com\nmx: *.net\nmax_age: 604800\n",
+            mx:   []string{"mx1.example.com", "*.net"},
+            findings: []string{"error: MX relay.example.net is not listed in the MTA-STS policy"},
+        },
+        {
+            name: "invalid fields",
+            body: "version: STSv2\nmode: strict\nmx: mx1.example.com\nmx: relay.example.net\nmax_age: 3600\n",
+            mx:   []string{"mx1.example.com", "relay.example.net"},
+            findings: []string{
+                "error: MTA-STS policy has an invalid mode strict",
+                "error: MTA-STS policy version is STSv2, expected STSv1",
+                "warning: MTA-STS max_age is only 3600 seconds",
+            },
+        },
+        {
+            name: "empty",
+            body: "<html>not a policy</html>",
+            findings: []string{
+                "error: MTA-STS policy has an invalid mode <nil>",
+                "error: MTA-STS policy version is <nil>, expected STSv1",
+                "error: MTA-STS policy has no valid max_age",
+                "error: MX mx1.example.com is not listed in the MTA-STS policy",
+                "error: MX relay.example.net is not listed in the MTA-STS policy",
+            },
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            a := &mailAuditor{ctx: context.Background(), lookup: fakeLookup(t, mx...)}
+            policy := a.stsPolicy("example.com", []byte(tt.body))
+            if got, _ := policy["mx"].([]string); !reflect.DeepEqual(got, tt.mx) { t.Errorf("mx = %q, want %q", got, tt.mx) }
+            if got := messages(a); !reflect.DeepEqual(got, tt.findings) { t.Errorf("findings = %q, want %q", got, tt.findings) }
+        })
+    }
+}
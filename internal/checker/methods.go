@@ -0,0 +1,48 @@
+package checker
+
+import "context"
+
+// none is the options type of methods that take no parameters.
+type none struct{}
+
+// fromCheck wraps the (ok, latency, msg, details) result most checks return.
+func fromCheck(ok bool, latency int64, msg string, details map[string]any) Result {
+    return Result{Success: ok, LatencyMs: latency, Message: msg, Details: details}
+}
+
+func init() {
+    Register(Func("http", func(ctx context.Context, target string, opts httpOptions) Result {
+        ok, code, lat, msg, det := httpCheck(ctx, target, opts)
+        return Result{Success: ok, LatencyMs: lat, StatusCode: code, Message: msg, Details: det}
+    }))
+    Register(Func("websocket", func(ctx context.Context, target string, opts websocketOptions) Result {
+        ok, code, lat, msg, det := websocketCheck(ctx, target, opts)
+        return Result{Success: ok, LatencyMs: lat, StatusCode: code, Message: msg, Details: det}
+    }))
+    Register(Func("dns", func(ctx context.Context, target string, opts dnsOptions) Result { return fromCheck(dnsCheck(ctx, target, opts)) }))
+    Register(Func("tcp", func(ctx context.Context, target string, opts tcpOptions) Result { return fromCheck(tcpCheck(ctx, target, opts)) }))
+    Register(Func("icmp", func(ctx context.Context, target string, opts icmpOptions) Result { return fromCheck(icmpCheck(ctx, target, opts)) }))
+    Register(Func("udp", func(ctx context.Context, target string, opts udpOptions) Result { return fromCheck(udpCheck(ctx, target, opts)) }))
+    Register(Func("blacklist", func(ctx context.Context, target string, opts blacklistOptions) Result { return fromCheck(blacklistCheck(ctx, target, opts)) }))
+    Register(Func("whois", func(ctx context.Context, target string, _ none) Result {
+        ok, lat, msg, det := whoisCheck(ctx, target)
+        // without a GeoIP source the key is left out rather than null
+        if geo := geoIPLookup(target); geo != nil { det["geoip"] = geo }
+        return fromCheck(ok, lat, msg, det)
+    }))
+    Register(Func("tls", func(ctx context.Context, target string, _ none) Result { return fromCheck(tlsCheck(ctx, target)) }))
+    for _, m := range []string{"smtp", "imap", "pop3"} {
+        Register(Func(m, func(ctx context.Context, target string, opts mailOptions) Result { return fromCheck(mailCheck(ctx, m, target, opts)) }))
+    }
+    for _, m := range []string{"postgres", "mysql", "redis", "mongodb"} {
+        Register(Func(m, func(ctx context.Context, target string, opts dbOptions) Result { return fromCheck(dbCheck(ctx, m, target, opts)) }))
+    }
+    Register(Func("mailauth", func(ctx context.Context, target string, opts mailAuthOptions) Result { return fromCheck(mailAuthCheck(ctx, target, opts)) }))
+    Register(Func("ssh", func(ctx context.Context, target string, opts sshOptions) Result { return fromCheck(sshCheck(ctx, target, opts)) }))
+    Register(Func("traceroute", func(ctx context.Context, target string, opts tracerouteOptions) Result {
+        ok, lat, msg, hops := traceroute(ctx, target, opts)
+        det := map[string]any{"hops": hops}
+        if geo := geoIPLookup(target); geo != nil { det["geoip"] = geo }
+        return Result{Success: ok, LatencyMs: lat, Message: msg, Details: det}
+    }))
+}
@@ -0,0 +1,296 @@
+package checker
+
+import (
+    "bytes"
+    "context"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/rsa"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "net"
+    "strconv"
+    "strings"
+    "time"
+
+    "golang.org/x/crypto/ssh"
+)
+
+// sshOptions are the per-task parameters of the ssh method.
+type sshOptions struct {
+    Port int `json:"port"`
+    // ExpectFingerprint pins the host key (SHA256:... as printed by ssh-keygen -l);
+    // a different key fails the check.
+    ExpectFingerprint string `json:"expect_fingerprint"`
+    // FailOnWeak fails the check when the server offers weak algorithms.
+    FailOnWeak bool `json:"fail_on_weak"`
+    TimeoutMs  int  `json:"timeout_ms"`
+}
+
+// The agent offers everything x/crypto/ssh can speak, legacy algorithms included,
+// so that the key exchange gets as far as the host key even on old servers.
+var (
+    sshProbeKex = []string{
+        "curve25519-sha256", "curve25519-sha256@libssh.org",
+        "ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+        "diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512", "diffie-hellman-group-exchange-sha256",
+        "diffie-hellman-group14-sha1", "diffie-hellman-group-exchange-sha1", "diffie-hellman-group1-sha1",
+    }
+    sshProbeCiphers = []string{
+        "aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+        "aes128-ctr", "aes192-ctr", "aes256-ctr",
+        "aes128-cbc", "3des-cbc", "arcfour256", "arcfour128", "arcfour",
+    }
+    sshProbeMACs = []string{
+        "hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com", "hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
+    }
+    // same preference as OpenSSH, so that agents and users see the same key type
+    sshProbeHostKeys = []string{
+        ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+        ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+    }
+)
+
+// sshKexInit holds the name-lists of the server's SSH_MSG_KEXINIT (RFC 4253, 7.1).
+type sshKexInit struct {
+    Kex         []string `json:"kex_algorithms"`
+    HostKey     []string `json:"host_key_algorithms"`
+    CiphersC2S  []string `json:"ciphers_client_to_server"`
+    CiphersS2C  []string `json:"ciphers_server_to_client"`
+    MACsC2S     []string `json:"macs_client_to_server"`
+    MACsS2C     []string `json:"macs_server_to_client"`
+    Compression []string `json:"compression"`
+}
+
+type sshWeakness struct {
+    Category  string `json:"category"`
+    Algorithm string `json:"algorithm"`
+    Reason    string `json:"reason"`
+}
+
+// recordingConn keeps a copy of the first bytes read from the server: the
+// identification string and the cleartext KEXINIT that x/crypto/ssh does not expose.
+type recordingConn struct {
+    net.Conn
+    buf bytes.Buffer
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+    n, err := c.Conn.Read(p)
+    if n > 0 && c.buf.Len() < 64<<10 { c.buf.Write(p[:n]) }
+    return n, err
+}
+
+// parseSSHIdent splits the recorded stream into the lines sent before the
+// identification string, the identification string itself and what follows it.
+func parseSSHIdent(b []byte) (pre []string, ident string, rest []byte, err error) {
+    for len(b) > 0 {
+        i := bytes.IndexByte(b, '\n')
+        if i < 0 { break }
+        line := strings.TrimRight(string(b[:i]), "\r")
+        b = b[i+1:]
+        if strings.HasPrefix(line, "SSH-") { return pre, line, b, nil }
+        pre = append(pre, line)
+    }
+    return pre, "", nil, errors.New("no SSH identification string")
+}
+
+func parseKexInit(b []byte) (*sshKexInit, error) {
+    if len(b) < 6 { return nil, errors.New("KEXINIT not received") }
+    length := binary.BigEndian.Uint32(b)
+    padding := uint32(b[4])
+    if length < padding+1 || uint64(len(b)) < 4+uint64(length) { return nil, errors.New("KEXINIT truncated") }
+    payload := b[5 : 4+length-padding]
+    if len(payload) > 0 && payload[0] != 20 { return nil, fmt.Errorf("expected KEXINIT, got message %d", payload[0]) }
+    if len(payload) < 17 { return nil, errors.New("KEXINIT too short") }
+    payload = payload[17:] // message type and cookie
+    lists := make([][]string, 0, 10)
+    for i := 0; i < 10; i++ {
+        if len(payload) < 4 { return nil, errors.New("KEXINIT truncated") }
+        n := binary.BigEndian.Uint32(payload)
+        if uint64(len(payload)) < 4+uint64(n) { return nil, errors.New("KEXINIT truncated") }
+        var names []string
+        if n > 0 { names = strings.Split(string(payload[4:4+n]), ",") }
+        lists = append(lists, names)
+        payload = payload[4+n:]
+    }
+    return &sshKexInit{
+        Kex: lists[0], HostKey: lists[1],
+        CiphersC2S: lists[2], CiphersS2C: lists[3],
+        MACsC2S: lists[4], MACsS2C: lists[5],
+        Compression: lists[6],
+    }, nil
+}
+
+// sshWeakReason says why an algorithm is considered weak, or "" when it is fine.
+func sshWeakReason(category, alg string) string {
+    a := strings.ToLower(alg)
+    switch category {
+    case "kex":
+        switch {
+        case a == "diffie-hellman-group1-sha1": return "1024-bit group and SHA-1"
+        case strings.HasPrefix(a, "rsa1024-"): return "1024-bit RSA"
+        case strings.Contains(a, "sha1"): return "SHA-1"
+        }
+    case "host_key":
+        switch {
+        case strings.HasPrefix(a, "ssh-dss"): return "DSA (1024-bit, removed from OpenSSH)"
+        case a == "ssh-rsa" || a == "ssh-rsa-cert-v01@openssh.com": return "RSA signatures with SHA-1"
+        }
+    case "cipher":
+        switch {
+        case a == "none": return "no encryption"
+        case strings.HasPrefix(a, "arcfour"): return "RC4"
+        case strings.Contains(a, "des") || strings.HasPrefix(a, "blowfish") || strings.HasPrefix(a, "cast128"): return "64-bit block cipher"
+        case strings.HasSuffix(a, "-cbc") || a == "rijndael-cbc@lysator.liu.se": return "CBC mode"
+        }
+    case "mac":
+        switch {
+        case a == "none": return "no integrity protection"
+        case strings.Contains(a, "md5"): return "MD5"
+        case strings.HasSuffix(a, "-96"): return "truncated tag"
+        case strings.HasPrefix(a, "umac-64"): return "64-bit tag"
+        case strings.HasPrefix(a, "hmac-sha1"): return "SHA-1"
+        }
+    }
+    return ""
+}
+
+func sshWeakAlgorithms(k *sshKexInit) []sshWeakness {
+    out := []sshWeakness{}
+    seen := map[string]bool{}
+    add := func(category string, algs ...[]string) {
+        for _, list := range algs {
+            for _, a := range list {
+                if seen[category+" "+a] { continue }
+                seen[category+" "+a] = true
+                if r := sshWeakReason(category, a); r != "" { out = append(out, sshWeakness{category, a, r}) }
+            }
+        }
+    }
+    add("kex", k.Kex)
+    add("host_key", k.HostKey)
+    add("cipher", k.CiphersC2S, k.CiphersS2C)
+    add("mac", k.MACsC2S, k.MACsS2C)
+    return out
+}
+
+func sshKeyBits(k ssh.PublicKey) int {
+    ck, ok := k.(ssh.CryptoPublicKey)
+    if !ok { return 0 }
+    switch pk := ck.CryptoPublicKey().(type) {
+    case *rsa.PublicKey:
+        return pk.N.BitLen()
+    case *ecdsa.PublicKey:
+        return pk.Curve.Params().BitSize
+    case ed25519.PublicKey:
+        return 256
+    }
+    if k.Type() == ssh.KeyAlgoDSA { return 1024 }
+    return 0
+}
+
+var errHostKeyCaptured = errors.New("host key captured")
+
+// sshCheck reads the server identification, its KEXINIT and, by running the key
+// exchange up to host key verification, the host key. It never authenticates.
+func sshCheck(ctx context.Context, target string, opts sshOptions) (ok bool, latency int64, msg string, details map[string]any) {
+    opts.TimeoutMs = timeoutMs(ctx, opts.TimeoutMs, 10*time.Second)
+    host, _, _ := net.SplitHostPort(tcpAddress(target))
+    port := opts.Port
+    if port == 0 { port, _ = strconv.Atoi(explicitPort(target)) }
+    if port == 0 { port = 22 }
+    addr := net.JoinHostPort(host, strconv.Itoa(port))
+    timeout := time.Duration(opts.TimeoutMs) * time.Millisecond
+
+    start := time.Now()
+    timings := map[string]float64{}
+    details = map[string]any{"host": host, "port": port, "timings": timings}
+    fail := func(err error) (bool, int64, string, map[string]any) {
+        timings["total_ms"] = durMs(time.Since(start))
+        return false, time.Since(start).Milliseconds(), err.Error(), details
+    }
+
+    d := net.Dialer{Timeout: timeout}
+    conn, err := d.DialContext(ctx, "tcp", addr)
+    if err != nil { return fail(err) }
+    defer func() { _ = conn.Close() }()
+    stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+    defer stop()
+    _ = conn.SetDeadline(start.Add(timeout))
+    timings["connect_ms"] = durMs(time.Since(start))
+    details["remote_addr"] = conn.RemoteAddr().String()
+
+    stage := time.Now()
+    rec := &recordingConn{Conn: conn}
+    var hostKey ssh.PublicKey
+    cfg := &ssh.ClientConfig{
+        User:          "check",
+        ClientVersion: "SSH-2.0-SyharikCheck",
+        Config:        ssh.Config{KeyExchanges: sshProbeKex, Ciphers: sshProbeCiphers, MACs: sshProbeMACs},
+        HostKeyAlgorithms: sshProbeHostKeys,
+        HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+            hostKey = key
+            return errHostKeyCaptured
+        },
+    }
+    _, _, _, hsErr := ssh.NewClientConn(rec, addr, cfg)
+    timings["handshake_ms"] = durMs(time.Since(stage))
+
+    pre, ident, rest, err := parseSSHIdent(rec.buf.Bytes())
+    if len(pre) > 0 { details["pre_banner"] = pre }
+    if err != nil {
+        if hsErr != nil && !errors.Is(hsErr, errHostKeyCaptured) { err = fmt.Errorf("%v: %v", err, hsErr) }
+        return fail(err)
+    }
+    details["banner"] = ident
+    // SSH-protoversion-softwareversion SP comments
+    id, comments, _ := strings.Cut(ident, " ")
+    if parts := strings.SplitN(id, "-", 3); len(parts) == 3 {
+        details["protocol"] = parts[1]
+        details["software"] = parts[2]
+    }
+    if comments != "" { details["comments"] = comments }
+
+    var weak []sshWeakness
+    if kex, err := parseKexInit(rest); err == nil {
+        details["algorithms"] = kex
+        weak = sshWeakAlgorithms(kex)
+    } else {
+        details["kexinit_error"] = err.Error()
+    }
+    if p, _ := details["protocol"].(string); p == "1.99" || strings.HasPrefix(p, "1.") {
+        weak = append(weak, sshWeakness{"protocol", "SSH-" + p, "SSH protocol 1 supported"})
+    }
+
+    var problems []string
+    if hostKey == nil {
+        timings["total_ms"] = durMs(time.Since(start))
+        details["weak"] = weak
+        if hsErr == nil { hsErr = errors.New("no host key") }
+        return false, time.Since(start).Milliseconds(), "key exchange failed: " + hsErr.Error(), details
+    }
+    fp := ssh.FingerprintSHA256(hostKey)
+    bits := sshKeyBits(hostKey)
+    details["host_key"] = map[string]any{
+        "type":               hostKey.Type(),
+        "bits":               bits,
+        "fingerprint_sha256": fp,
+        "fingerprint_md5":    ssh.FingerprintLegacyMD5(hostKey),
+    }
+    if hostKey.Type() == ssh.KeyAlgoRSA && bits > 0 && bits < 2048 {
+        weak = append(weak, sshWeakness{"host_key", fmt.Sprintf("ssh-rsa %d", bits), "RSA key shorter than 2048 bits"})
+    }
+    details["weak"] = weak
+    if opts.ExpectFingerprint != "" && strings.TrimPrefix(opts.ExpectFingerprint, "SHA256:") != strings.TrimPrefix(fp, "SHA256:") {
+        problems = append(problems, "host key "+fp+" does not match the expected "+opts.ExpectFingerprint)
+    }
+    if opts.FailOnWeak && len(weak) > 0 { problems = append(problems, fmt.Sprintf("%d weak algorithms offered", len(weak))) }
+
+    timings["total_ms"] = durMs(time.Since(start))
+    msg = fmt.Sprintf("%s, %s %s", ident, hostKey.Type(), fp)
+    if len(weak) > 0 && !opts.FailOnWeak { msg += fmt.Sprintf(", %d weak algorithms", len(weak)) }
+    if len(problems) > 0 { msg = strings.Join(problems, "; ") }
+    return len(problems) == 0, time.Since(start).Milliseconds(), msg, details
+}
@@ -0,0 +1,22 @@
+package checker
+
+import "testing"
+
+func TestParseKexInitMalformed(t *testing.T) {
+    tests := []struct {
+        name string
+        pkt  []byte
+        want string
+    }{
+        {"empty payload", []byte{0, 0, 0, 1, 0, 0}, "KEXINIT too short"},
+        {"short kexinit", []byte{0, 0, 0, 4, 0, 20, 1, 2}, "KEXINIT too short"},
+        {"other message", []byte{0, 0, 0, 2, 0, 21}, "expected KEXINIT, got message 21"},
+        {"length past the end", []byte{0, 0, 1, 0, 0, 20}, "KEXINIT truncated"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := parseKexInit(tt.pkt)
+            if err == nil || err.Error() != tt.want { t.Fatalf("err = %v, want %q", err, tt.want) }
+        })
+    }
+}
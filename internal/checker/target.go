@@ -0,0 +1,111 @@
+package checker
+
+import (
+    "encoding/json"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "aeza/internal/geoip"
+)
+
+// Config carries the agent settings that some checks depend on.
+type Config struct {
+    // GeoIP holds the local MaxMind databases; nil means no offline data.
+    GeoIP *geoip.DB
+    // GeoIPOnline enables the ipapi.co fallback for addresses the databases do not know.
+    GeoIPOnline bool
+    // DNSBLZones replaces the default zones of the blacklist method.
+    DNSBLZones []string
+}
+
+// Configure applies agent settings; it is called once before the first check runs.
+func Configure(cfg Config) {
+    geoDB, geoOnline = cfg.GeoIP, cfg.GeoIPOnline
+    if len(cfg.DNSBLZones) > 0 { dnsblZones = cfg.DNSBLZones }
+}
+
+// geoDB holds the local MaxMind databases from GEOIP_DB; empty means no offline data.
+var geoDB *geoip.DB
+
+// geoOnline enables the legacy ipapi.co lookup when no local database knows the address.
+var geoOnline bool
+
+// geoIPLookup resolves host and returns its GeoIP/ASN data, or nil when nothing is known.
+func geoIPLookup(host string) any {
+    // If host is a URL, extract hostname
+    h := hostnameForDNS(host)
+    // Try to resolve to IP if domain
+    ip := h
+    if net.ParseIP(h) == nil {
+        if ips, err := net.LookupIP(h); err == nil && len(ips) > 0 {
+            ip = ips[0].String()
+        }
+    }
+    if info := geoDB.LookupString(ip); info != nil { return info }
+    if !geoOnline { return nil }
+    // best-effort ipapi.co (no key, rate-limited, sends the IP to a third party)
+    client := &http.Client{Timeout: 5 * time.Second}
+    req, _ := http.NewRequest(http.MethodGet, "https://ipapi.co/"+ip+"/json/", nil)
+    resp, err := client.Do(req)
+    if err != nil { return nil }
+    defer resp.Body.Close()
+    var m map[string]any
+    if err := json.NewDecoder(resp.Body).Decode(&m); err != nil { return nil }
+    return m
+}
+
+func ensureHTTPURL(target string) string {
+    t := strings.TrimSpace(target)
+    if strings.HasPrefix(t, "http://") || strings.HasPrefix(t, "https://") {
+        return t
+    }
+    // if looks like host:port, prepend http://
+    return "http://" + t
+}
+
+func hostnameForDNS(target string) string {
+    t := strings.TrimSpace(target)
+    if strings.Contains(t, "://") {
+        if u, err := url.Parse(t); err == nil {
+            return u.Hostname()
+        }
+    }
+    // strip path if accidentally present
+    if i := strings.Index(t, "/"); i > 0 {
+        t = t[:i]
+    }
+    // strip port if present
+    if h, _, err := net.SplitHostPort(t); err == nil {
+        return h
+    }
+    return t
+}
+
+func tcpAddress(target string) string {
+    t := strings.TrimSpace(target)
+    if strings.Contains(t, "://") {
+        if u, err := url.Parse(t); err == nil {
+            host := u.Hostname()
+            port := u.Port()
+            if port == "" {
+                if u.Scheme == "https" { port = "443" } else { port = "80" }
+            }
+            return net.JoinHostPort(host, port)
+        }
+    }
+    // if path present, strip after '/'
+    if i := strings.Index(t, "/"); i > 0 { t = t[:i] }
+    // if no port, default 80
+    if _, _, err := net.SplitHostPort(t); err != nil {
+        return net.JoinHostPort(t, "80")
+    }
+    return t
+}
+
+// Host returns the lower-cased host a target points at, without scheme, port or path.
+func Host(target string) string {
+    return strings.ToLower(hostnameForDNS(target))
+}
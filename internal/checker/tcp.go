@@ -0,0 +1,162 @@
+package checker
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+    "unicode"
+)
+
+// tcpOptions are the per-task parameters of the tcp method.
+type tcpOptions struct {
+    // Ports is a list of ports and ranges, e.g. "22,80,443,8000-8010". Empty means the
+    // port of the target itself (80 by default).
+    Ports       string `json:"ports"`
+    Concurrency int    `json:"concurrency"`
+    TimeoutMs   int    `json:"timeout_ms"`
+    // Banner reads whatever the service sends right after connecting (SSH, SMTP, FTP...).
+    Banner          bool `json:"banner"`
+    BannerBytes     int  `json:"banner_bytes"`
+    BannerTimeoutMs int  `json:"banner_timeout_ms"`
+    IPVersion       int  `json:"ip_version"`
+}
+
+const tcpMaxPorts = 1024
+
+func (o *tcpOptions) normalize() {
+    if o.Concurrency <= 0 { o.Concurrency = 50 }
+    if o.Concurrency > 200 { o.Concurrency = 200 }
+    if o.TimeoutMs <= 0 { o.TimeoutMs = 3000 }
+    if o.BannerBytes <= 0 { o.BannerBytes = 256 }
+    if o.BannerBytes > 4096 { o.BannerBytes = 4096 }
+    if o.BannerTimeoutMs <= 0 { o.BannerTimeoutMs = 2000 }
+}
+
+// parsePorts expands "22,80,8000-8010" into a sorted list of unique ports.
+func parsePorts(spec string) ([]int, error) {
+    seen := map[int]bool{}
+    var out []int
+    for _, f := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' || r == ';' }) {
+        lo, hi, isRange := strings.Cut(f, "-")
+        a, err := strconv.Atoi(strings.TrimSpace(lo))
+        if err != nil { return nil, fmt.Errorf("invalid port %q", f) }
+        b := a
+        if isRange {
+            if b, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil { return nil, fmt.Errorf("invalid port range %q", f) }
+        }
+        if a < 1 || b > 65535 || a > b { return nil, fmt.Errorf("invalid port range %q", f) }
+        for p := a; p <= b; p++ {
+            if seen[p] { continue }
+            seen[p] = true
+            out = append(out, p)
+            if len(out) > tcpMaxPorts { return nil, fmt.Errorf("too many ports (max %d)", tcpMaxPorts) }
+        }
+    }
+    if len(out) == 0 { return nil, fmt.Errorf("no ports in %q", spec) }
+    sort.Ints(out)
+    return out, nil
+}
+
+type tcpPortResult struct {
+    Port      int     `json:"port"`
+    // Status is open, closed (connection refused) or filtered (no answer / unreachable).
+    Status    string  `json:"status"`
+    ConnectMs float64 `json:"connect_ms"`
+    Banner    string  `json:"banner,omitempty"`
+    Error     string  `json:"error,omitempty"`
+}
+
+// cleanBanner keeps the printable part of what the service sent.
+func cleanBanner(b []byte) string {
+    s := strings.ToValidUTF8(string(b), "")
+    s = strings.Map(func(r rune) rune {
+        if r == '\n' || r == '\t' || unicode.IsPrint(r) { return r }
+        return -1
+    }, s)
+    return strings.TrimSpace(s)
+}
+
+func probeTCPPort(ctx context.Context, ip net.IP, port int, opts tcpOptions) tcpPortResult {
+    r := tcpPortResult{Port: port}
+    start := time.Now()
+    d := net.Dialer{Timeout: time.Duration(opts.TimeoutMs) * time.Millisecond}
+    conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+    r.ConnectMs = durMs(time.Since(start))
+    if err != nil {
+        r.Status, r.Error = "filtered", err.Error()
+        if errors.Is(err, syscall.ECONNREFUSED) { r.Status = "closed" }
+        return r
+    }
+    defer conn.Close()
+    stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+    defer stop()
+    r.Status = "open"
+    if opts.Banner {
+        buf := make([]byte, opts.BannerBytes)
+        _ = conn.SetReadDeadline(time.Now().Add(time.Duration(opts.BannerTimeoutMs) * time.Millisecond))
+        n, _ := conn.Read(buf)
+        r.Banner = cleanBanner(buf[:n])
+    }
+    return r
+}
+
+// tcpCheck connects to one or more ports of the target concurrently. It succeeds when
+// every requested port is open.
+func tcpCheck(ctx context.Context, target string, opts tcpOptions) (ok bool, latency int64, msg string, details map[string]any) {
+    opts.normalize()
+    start := time.Now()
+    host, portStr, _ := net.SplitHostPort(tcpAddress(target))
+    spec := opts.Ports
+    if strings.TrimSpace(spec) == "" { spec = portStr }
+    ports, err := parsePorts(spec)
+    if err != nil { return false, 0, err.Error(), nil }
+    ip, err := resolveIP(ctx, host, opts.IPVersion)
+    if err != nil { return false, time.Since(start).Milliseconds(), err.Error(), nil }
+
+    results := make([]tcpPortResult, len(ports))
+    sem := make(chan struct{}, opts.Concurrency)
+    var wg sync.WaitGroup
+probe:
+    for i, p := range ports {
+        select {
+        case sem <- struct{}{}:
+        case <-ctx.Done():
+            // ports that never got a slot are reported unprobed
+            for j := i; j < len(ports); j++ { results[j] = tcpPortResult{Port: ports[j], Status: "filtered", Error: ctx.Err().Error()} }
+            break probe
+        }
+        wg.Add(1)
+        go func(i, p int) {
+            defer func() { <-sem; wg.Done() }()
+            results[i] = probeTCPPort(ctx, ip, p, opts)
+        }(i, p)
+    }
+    wg.Wait()
+
+    counts := map[string]int{}
+    var notOpen []string
+    for _, r := range results {
+        counts[r.Status]++
+        if r.Status != "open" { notOpen = append(notOpen, fmt.Sprintf("%d %s", r.Port, r.Status)) }
+    }
+    details = map[string]any{
+        "host": host, "ip": ip.String(), "ports": results,
+        "open": counts["open"], "closed": counts["closed"], "filtered": counts["filtered"],
+    }
+    if len(results) == 1 {
+        r := results[0]
+        return r.Status == "open", time.Since(start).Milliseconds(), r.Error, details
+    }
+    if len(notOpen) > 0 {
+        if len(notOpen) > 20 { notOpen = append(notOpen[:20], "...") }
+        msg = fmt.Sprintf("%d/%d open; ", counts["open"], len(results)) + strings.Join(notOpen, ", ")
+    }
+    return len(notOpen) == 0, time.Since(start).Milliseconds(), msg, details
+}
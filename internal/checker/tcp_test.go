@@ -0,0 +1,60 @@
+package checker
+
+import (
+    "context"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestParsePorts(t *testing.T) {
+    tests := []struct {
+        spec    string
+        want    []int
+        wantErr string
+    }{
+        {spec: "443", want: []int{443}},
+        {spec: "22,80, 443", want: []int{22, 80, 443}},
+        {spec: "8000-8003;22", want: []int{22, 8000, 8001, 8002, 8003}},
+        {spec: "80 - 81", wantErr: `invalid port "-"`},
+        {spec: "443,80,443,80-81", want: []int{80, 81, 443}},
+        {spec: "1-1024", want: seq(1, 1024)},
+        {spec: "1-1024,1-1024", want: seq(1, 1024)},
+        {spec: "1-1025", wantErr: "too many ports (max 1024)"},
+        {spec: "1-1000,2000-2100", wantErr: "too many ports (max 1024)"},
+        {spec: "http", wantErr: `invalid port "http"`},
+        {spec: "80-", wantErr: `invalid port range "80-"`},
+        {spec: "90-80", wantErr: `invalid port range "90-80"`},
+        {spec: "0", wantErr: `invalid port range "0"`},
+        {spec: "65536", wantErr: `invalid port range "65536"`},
+        {spec: " , ", wantErr: `no ports in " , "`},
+    }
+    for _, tt := range tests {
+        got, err := parsePorts(tt.spec)
+        if err != nil {
+            if err.Error() != tt.wantErr { t.Errorf("parsePorts(%q) error = %v, want %q", tt.spec, err, tt.wantErr) }
+            continue
+        }
+        if tt.wantErr != "" || !reflect.DeepEqual(got, tt.want) { t.Errorf("parsePorts(%q) = %v, want %v, error %q", tt.spec, got, tt.want, tt.wantErr) }
+    }
+}
+
+func seq(lo, hi int) []int {
+    var out []int
+    for p := lo; p <= hi; p++ { out = append(out, p) }
+    return out
+}
+
+func TestTCPCheckCanceled(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    start := time.Now()
+    ok, _, _, details := tcpCheck(ctx, "127.0.0.1", tcpOptions{Ports: "1-1024", Concurrency: 1})
+    if ok { t.Error("ok with a canceled context") }
+    if waited := time.Since(start); waited > 2*time.Second { t.Errorf("scan outlived the context by %v", waited) }
+    results, _ := details["ports"].([]tcpPortResult)
+    if len(results) != 1024 { t.Fatalf("%d results, want 1024", len(results)) }
+    for i, r := range results {
+        if r.Port != i+1 || r.Status != "filtered" || r.Error == "" { t.Fatalf("results[%d] = %+v", i, r) }
+    }
+}
@@ -0,0 +1,175 @@
+package checker
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/hex"
+    "fmt"
+    "net"
+    "net/url"
+    "strings"
+    "time"
+
+    "golang.org/x/crypto/ocsp"
+)
+
+// tlsAddress normalizes target into host:port, defaulting to 443.
+func tlsAddress(target string) string {
+    t := strings.TrimSpace(target)
+    if strings.Contains(t, "://") {
+        if u, err := url.Parse(t); err == nil {
+            port := u.Port()
+            if port == "" { port = "443" }
+            return net.JoinHostPort(u.Hostname(), port)
+        }
+    }
+    if i := strings.Index(t, "/"); i > 0 { t = t[:i] }
+    if _, _, err := net.SplitHostPort(t); err != nil {
+        return net.JoinHostPort(t, "443")
+    }
+    return t
+}
+
+func certKeyInfo(c *x509.Certificate) (string, int) {
+    switch k := c.PublicKey.(type) {
+    case *rsa.PublicKey:
+        return "RSA", k.N.BitLen()
+    case *ecdsa.PublicKey:
+        return "ECDSA", k.Curve.Params().BitSize
+    case ed25519.PublicKey:
+        return "Ed25519", 256
+    }
+    return c.PublicKeyAlgorithm.String(), 0
+}
+
+func certSummary(c *x509.Certificate, now time.Time) map[string]any {
+    keyType, keyBits := certKeyInfo(c)
+    fp := sha256.Sum256(c.Raw)
+    ips := make([]string, 0, len(c.IPAddresses))
+    for _, ip := range c.IPAddresses { ips = append(ips, ip.String()) }
+    return map[string]any{
+        "subject":             c.Subject.String(),
+        "issuer":              c.Issuer.String(),
+        "serial":              c.SerialNumber.Text(16),
+        "not_before":          c.NotBefore.UTC(),
+        "not_after":           c.NotAfter.UTC(),
+        "days_until_expiry":   int(c.NotAfter.Sub(now).Hours() / 24),
+        "dns_names":           c.DNSNames,
+        "ip_addresses":        ips,
+        "signature_algorithm": c.SignatureAlgorithm.String(),
+        "key_type":            keyType,
+        "key_bits":            keyBits,
+        "is_ca":               c.IsCA,
+        "self_signed":         c.Subject.String() == c.Issuer.String() && c.CheckSignatureFrom(c) == nil,
+        "sha256_fingerprint":  hex.EncodeToString(fp[:]),
+    }
+}
+
+func ocspStatus(cs tls.ConnectionState) map[string]any {
+    if len(cs.OCSPResponse) == 0 {
+        return map[string]any{"stapled": false}
+    }
+    out := map[string]any{"stapled": true}
+    var issuer *x509.Certificate
+    if len(cs.PeerCertificates) > 1 { issuer = cs.PeerCertificates[1] }
+    resp, err := ocsp.ParseResponse(cs.OCSPResponse, issuer)
+    if err != nil {
+        out["error"] = err.Error()
+        return out
+    }
+    switch resp.Status {
+    case ocsp.Good:
+        out["status"] = "good"
+    case ocsp.Revoked:
+        out["status"] = "revoked"
+        out["revoked_at"] = resp.RevokedAt.UTC()
+    default:
+        out["status"] = "unknown"
+    }
+    out["this_update"] = resp.ThisUpdate.UTC()
+    if !resp.NextUpdate.IsZero() { out["next_update"] = resp.NextUpdate.UTC() }
+    return out
+}
+
+// tlsCheck performs a TLS handshake without trusting the peer blindly and reports
+// the presented chain, verification outcome and negotiated parameters.
+func tlsCheck(ctx context.Context, target string) (ok bool, latency int64, msg string, details map[string]any) {
+    addr := tlsAddress(target)
+    host, _, _ := net.SplitHostPort(addr)
+    start := time.Now()
+    dialer := &tls.Dialer{
+        NetDialer: &net.Dialer{Timeout: checkTimeout(ctx, 10*time.Second)},
+        // verification is done by describeTLS so that broken chains are still reported
+        Config: &tls.Config{
+            ServerName:         host,
+            InsecureSkipVerify: true,
+            NextProtos:         []string{"h2", "http/1.1"},
+        },
+    }
+    c, err := dialer.DialContext(ctx, "tcp", addr)
+    if err != nil { return false, time.Since(start).Milliseconds(), err.Error(), nil }
+    latency = time.Since(start).Milliseconds()
+    conn := c.(*tls.Conn)
+    cs := conn.ConnectionState()
+    remote := conn.RemoteAddr().String()
+    _ = conn.Close()
+
+    details, msg = describeTLS(cs, host)
+    if details == nil { return false, latency, msg, nil }
+    details["remote_addr"] = remote
+    return msg == "", latency, msg, details
+}
+
+// describeTLS summarises a finished handshake: protocol, cipher, the presented chain and
+// whether it verifies for host. msg is empty when the certificate is acceptable.
+func describeTLS(cs tls.ConnectionState, host string) (details map[string]any, msg string) {
+    if len(cs.PeerCertificates) == 0 {
+        return nil, "server presented no certificates"
+    }
+    now := time.Now()
+    leaf := cs.PeerCertificates[0]
+    chain := make([]map[string]any, 0, len(cs.PeerCertificates))
+    for _, c := range cs.PeerCertificates { chain = append(chain, certSummary(c, now)) }
+
+    inter := x509.NewCertPool()
+    for _, c := range cs.PeerCertificates[1:] { inter.AddCert(c) }
+    verified := true
+    verifyErr := ""
+    if _, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Intermediates: inter, CurrentTime: now}); err != nil {
+        verified = false
+        verifyErr = err.Error()
+    }
+    hostnameMatch := leaf.VerifyHostname(host) == nil
+    days := int(leaf.NotAfter.Sub(now).Hours() / 24)
+
+    details = map[string]any{
+        "server_name":       host,
+        "tls_version":       tls.VersionName(cs.Version),
+        "cipher_suite":      tls.CipherSuiteName(cs.CipherSuite),
+        "alpn":              cs.NegotiatedProtocol,
+        "verified":          verified,
+        "hostname_match":    hostnameMatch,
+        "days_until_expiry": days,
+        "not_after":         leaf.NotAfter.UTC(),
+        "ocsp":              ocspStatus(cs),
+        "chain":             chain,
+    }
+    if verifyErr != "" { details["verify_error"] = verifyErr }
+
+    switch {
+    case now.After(leaf.NotAfter):
+        msg = fmt.Sprintf("certificate expired %d days ago", -days)
+    case now.Before(leaf.NotBefore):
+        msg = "certificate is not yet valid"
+    case !hostnameMatch:
+        msg = "certificate is not valid for " + host
+    case !verified:
+        msg = verifyErr
+    }
+    return details, msg
+}
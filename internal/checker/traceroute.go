@@ -0,0 +1,445 @@
+package checker
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "math"
+    "net"
+    "os"
+    "strings"
+    "sync/atomic"
+    "syscall"
+    "time"
+
+    "aeza/internal/geoip"
+
+    "golang.org/x/net/icmp"
+    "golang.org/x/net/ipv4"
+    "golang.org/x/net/ipv6"
+)
+
+// tracerouteOptions are the per-task parameters of the traceroute method.
+type tracerouteOptions struct {
+    // Protocol is the probe type: udp (default), icmp or tcp.
+    Protocol  string `json:"protocol"`
+    // Port is the destination port for tcp probes and the base port for udp probes.
+    Port      int    `json:"port"`
+    MaxHops   int    `json:"max_hops"`
+    Probes    int    `json:"probes"`
+    TimeoutMs int    `json:"timeout_ms"`
+    // MTR repeats the trace Cycles times and reports per-hop loss and RTT statistics.
+    MTR       bool   `json:"mtr"`
+    Cycles    int    `json:"cycles"`
+    IPVersion int    `json:"ip_version"`
+    NoDNS     bool   `json:"no_dns"`
+}
+
+func (o *tracerouteOptions) normalize() {
+    o.Protocol = strings.ToLower(strings.TrimSpace(o.Protocol))
+    if o.Protocol == "" { o.Protocol = "udp" }
+    if o.Port <= 0 {
+        if o.Protocol == "tcp" { o.Port = 80 } else { o.Port = 33434 }
+    }
+    if o.MaxHops <= 0 { o.MaxHops = 20 }
+    if o.MaxHops > 64 { o.MaxHops = 64 }
+    if o.Probes <= 0 { o.Probes = 3 }
+    if o.Probes > 10 { o.Probes = 10 }
+    if o.TimeoutMs <= 0 { o.TimeoutMs = 1000 }
+    if o.Cycles <= 0 { o.Cycles = 10 }
+    if o.Cycles > 100 { o.Cycles = 100 }
+    if o.MTR { o.Probes = 1 }
+}
+
+// traceHop is one TTL of the path. RTTs holds nil for probes that got no answer.
+type traceHop struct {
+    TTL      int        `json:"ttl"`
+    IP       string     `json:"ip,omitempty"`
+    Host     string     `json:"host,omitempty"`
+    RTTs     []*float64 `json:"rtts_ms"`
+    Note     string     `json:"note,omitempty"`
+    // MTR statistics
+    Sent     int        `json:"sent,omitempty"`
+    Received int        `json:"received,omitempty"`
+    LossPct  *float64   `json:"loss_pct,omitempty"`
+    Best     *float64   `json:"best_ms,omitempty"`
+    Avg      *float64   `json:"avg_ms,omitempty"`
+    Worst    *float64   `json:"worst_ms,omitempty"`
+    StDev    *float64   `json:"stdev_ms,omitempty"`
+    Geo      *geoip.Info `json:"geo,omitempty"`
+}
+
+// probePayload is the data carried by ICMP and UDP probes.
+var probePayload = []byte("syharikcheck")
+
+// probeResult is the outcome of a single probe.
+type probeResult struct {
+    ip      net.IP
+    rtt     time.Duration
+    reached bool
+    note    string
+}
+
+// tracer sends TTL-limited probes and listens for ICMP errors on a raw socket (needs CAP_NET_RAW).
+type tracer struct {
+    ctx   context.Context
+    opts  tracerouteOptions
+    dst   net.IP
+    v6    bool
+    icmp  *icmp.PacketConn
+    id    int
+    seq   int
+}
+
+func newTracer(ctx context.Context, dst net.IP, opts tracerouteOptions) (*tracer, error) {
+    t := &tracer{ctx: ctx, opts: opts, dst: dst, v6: dst.To4() == nil, id: nextICMPID()}
+    network, addr := "ip4:icmp", "0.0.0.0"
+    if t.v6 { network, addr = "ip6:ipv6-icmp", "::" }
+    c, err := icmp.ListenPacket(network, addr)
+    if err != nil { return nil, fmt.Errorf("icmp socket: %w", err) }
+    t.icmp = c
+    return t, nil
+}
+
+func (t *tracer) Close() error { return t.icmp.Close() }
+
+func (t *tracer) icmpProto() int {
+    if t.v6 { return 58 }
+    return 1
+}
+
+// quotedProbe extracts the protocol, destination and first transport bytes of the
+// original packet quoted in an ICMP error message.
+func quotedProbe(data []byte, v6 bool) (proto int, dst net.IP, l4 []byte, ok bool) {
+    if v6 {
+        if len(data) < 48 { return 0, nil, nil, false }
+        return int(data[6]), net.IP(data[24:40]), data[40:], true
+    }
+    if len(data) < 20 { return 0, nil, nil, false }
+    ihl := int(data[0]&0x0f) * 4
+    if len(data) < ihl+8 { return 0, nil, nil, false }
+    return int(data[9]), net.IP(data[16:20]), data[ihl:], true
+}
+
+// matchFunc decides whether a quoted probe belongs to the probe in flight.
+type matchFunc func(proto int, l4 []byte) bool
+
+// matchPorts matches a quoted UDP or TCP header by protocol and ports. srcPort is read
+// on every call since a TCP probe learns its port only once the socket is bound.
+func matchPorts(proto int, srcPort func() int, dstPort int) matchFunc {
+    return func(p int, l4 []byte) bool {
+        return p == proto && len(l4) >= 4 && int(binary.BigEndian.Uint16(l4[0:2])) == srcPort() && int(binary.BigEndian.Uint16(l4[2:4])) == dstPort
+    }
+}
+
+// awaitICMP reads ICMP messages until one answers the probe in flight, the deadline passes
+// or settled is closed. A nil settled never fires.
+func (t *tracer) awaitICMP(sent time.Time, deadline time.Time, match matchFunc, echoSeq int, settled <-chan struct{}) (probeResult, error) {
+    _ = t.icmp.SetReadDeadline(deadline)
+    buf := make([]byte, 1500)
+    for {
+        // the wake-up deadline set by whoever closed settled may have been overwritten above
+        select {
+        case <-settled:
+            return probeResult{}, os.ErrDeadlineExceeded
+        default:
+        }
+        n, peer, err := t.icmp.ReadFrom(buf)
+        if err != nil { return probeResult{}, err }
+        rtt := time.Since(sent)
+        m, err := icmp.ParseMessage(t.icmpProto(), buf[:n])
+        if err != nil { continue }
+        from := net.ParseIP(hostOnly(peer.String()))
+        var quoted []byte
+        unreachable := false
+        switch b := m.Body.(type) {
+        case *icmp.TimeExceeded:
+            quoted = b.Data
+        case *icmp.DstUnreach:
+            quoted = b.Data
+            unreachable = true
+        case *icmp.Echo:
+            if (m.Type == ipv4.ICMPTypeEchoReply || m.Type == ipv6.ICMPTypeEchoReply) && echoSeq > 0 && b.ID == t.id && b.Seq == echoSeq &&
+                from.Equal(t.dst) && bytes.Equal(b.Data, probePayload) {
+                return probeResult{ip: from, rtt: rtt, reached: true}, nil
+            }
+            continue
+        default:
+            continue
+        }
+        proto, qdst, l4, ok := quotedProbe(quoted, t.v6)
+        if !ok || !qdst.Equal(t.dst) || !match(proto, l4) { continue }
+        res := probeResult{ip: from, rtt: rtt}
+        if unreachable {
+            res.reached = from.Equal(t.dst)
+            if !res.reached || !isPortUnreachable(m) { res.note = unreachableNote(m) }
+        }
+        return res, nil
+    }
+}
+
+func isPortUnreachable(m *icmp.Message) bool {
+    if m.Type == ipv6.ICMPTypeDestinationUnreachable { return m.Code == 4 }
+    return m.Code == 3
+}
+
+func unreachableNote(m *icmp.Message) string {
+    if m.Type == ipv6.ICMPTypeDestinationUnreachable {
+        switch m.Code {
+        case 1: return "!X admin prohibited"
+        case 3: return "!H address unreachable"
+        case 4: return ""
+        }
+        return fmt.Sprintf("!<%d>", m.Code)
+    }
+    switch m.Code {
+    case 0: return "!N network unreachable"
+    case 1: return "!H host unreachable"
+    case 3: return ""
+    case 9, 10, 13: return "!X admin prohibited"
+    }
+    return fmt.Sprintf("!<%d>", m.Code)
+}
+
+func isTimeout(err error) bool {
+    var ne net.Error
+    return errors.As(err, &ne) && ne.Timeout()
+}
+
+func (t *tracer) probe(ttl int) (probeResult, error) {
+    t.seq++
+    timeout := time.Duration(t.opts.TimeoutMs) * time.Millisecond
+    switch t.opts.Protocol {
+    case "icmp":
+        return t.probeICMP(ttl, timeout)
+    case "tcp":
+        return t.probeTCP(ttl, timeout)
+    }
+    return t.probeUDP(ttl, timeout)
+}
+
+func (t *tracer) probeICMP(ttl int, timeout time.Duration) (probeResult, error) {
+    seq := t.seq & 0xffff
+    var typ icmp.Type = ipv4.ICMPTypeEcho
+    if t.v6 {
+        typ = ipv6.ICMPTypeEchoRequest
+        if err := t.icmp.IPv6PacketConn().SetHopLimit(ttl); err != nil { return probeResult{}, err }
+    } else if err := t.icmp.IPv4PacketConn().SetTTL(ttl); err != nil {
+        return probeResult{}, err
+    }
+    b, err := (&icmp.Message{Type: typ, Body: &icmp.Echo{ID: t.id, Seq: seq, Data: probePayload}}).Marshal(nil)
+    if err != nil { return probeResult{}, err }
+    sent := time.Now()
+    if _, err := t.icmp.WriteTo(b, &net.IPAddr{IP: t.dst}); err != nil { return probeResult{}, err }
+    match := func(proto int, l4 []byte) bool {
+        return proto == t.icmpProto() && len(l4) >= 8 && int(binary.BigEndian.Uint16(l4[4:6])) == t.id && int(binary.BigEndian.Uint16(l4[6:8])) == seq
+    }
+    return t.awaitICMP(sent, sent.Add(timeout), match, seq, nil)
+}
+
+func (t *tracer) probeUDP(ttl int, timeout time.Duration) (probeResult, error) {
+    network, laddr := "udp4", "0.0.0.0:0"
+    if t.v6 { network, laddr = "udp6", "[::]:0" }
+    c, err := net.ListenPacket(network, laddr)
+    if err != nil { return probeResult{}, err }
+    defer c.Close()
+    if t.v6 {
+        err = ipv6.NewPacketConn(c).SetHopLimit(ttl)
+    } else {
+        err = ipv4.NewPacketConn(c).SetTTL(ttl)
+    }
+    if err != nil { return probeResult{}, err }
+    srcPort := c.LocalAddr().(*net.UDPAddr).Port
+    dstPort := t.opts.Port + t.seq%1024
+    sent := time.Now()
+    if _, err := c.WriteTo(probePayload, &net.UDPAddr{IP: t.dst, Port: dstPort}); err != nil { return probeResult{}, err }
+    match := matchPorts(syscall.IPPROTO_UDP, func() int { return srcPort }, dstPort)
+    return t.awaitICMP(sent, sent.Add(timeout), match, 0, nil)
+}
+
+// probeTCP sends a TTL-limited SYN through a regular connect. A handshake or a refusal
+// means the destination was reached; intermediate hops answer with ICMP time exceeded.
+func (t *tracer) probeTCP(ttl int, timeout time.Duration) (probeResult, error) {
+    // the socket is bound before connect so that quoted SYNs can be told apart by source port
+    var srcPort atomic.Uint32
+    d := net.Dialer{Timeout: timeout, Control: func(network, address string, rc syscall.RawConn) error {
+        var serr error
+        err := rc.Control(func(fd uintptr) {
+            var local syscall.Sockaddr = &syscall.SockaddrInet4{}
+            if t.v6 {
+                local = &syscall.SockaddrInet6{}
+                serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+            } else {
+                serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+            }
+            if serr == nil { serr = syscall.Bind(int(fd), local) }
+            if serr != nil { return }
+            sa, err := syscall.Getsockname(int(fd))
+            if err != nil { serr = err; return }
+            switch a := sa.(type) {
+            case *syscall.SockaddrInet4:
+                srcPort.Store(uint32(a.Port))
+            case *syscall.SockaddrInet6:
+                srcPort.Store(uint32(a.Port))
+            }
+        })
+        if err != nil { return err }
+        return serr
+    }}
+    type dialResult struct { rtt time.Duration; err error }
+    done := make(chan dialResult, 1)
+    settled := make(chan struct{})
+    sent := time.Now()
+    go func() {
+        conn, err := d.DialContext(t.ctx, "tcp", net.JoinHostPort(t.dst.String(), fmt.Sprint(t.opts.Port)))
+        rtt := time.Since(sent)
+        if conn != nil { _ = conn.Close() }
+        // the probe is settled: close settled first so that awaitICMP sees it even if it
+        // arms its own deadline after ours, then wake up a read already in progress
+        close(settled)
+        _ = t.icmp.SetReadDeadline(time.Now())
+        done <- dialResult{rtt, err}
+    }()
+    match := matchPorts(syscall.IPPROTO_TCP, func() int { return int(srcPort.Load()) }, t.opts.Port)
+    res, err := t.awaitICMP(sent, sent.Add(timeout), match, 0, settled)
+    dr := <-done
+    if err == nil { return res, nil }
+    if !isTimeout(err) { return probeResult{}, err }
+    if dr.err == nil || errors.Is(dr.err, syscall.ECONNREFUSED) {
+        return probeResult{ip: t.dst, rtt: dr.rtt, reached: true}, nil
+    }
+    return probeResult{}, err
+}
+
+// reverseDNS resolves PTR names with a short timeout and caches them per trace.
+func reverseDNS(ctx context.Context, cache map[string]string, ip string) string {
+    if h, ok := cache[ip]; ok { return h }
+    ctx, cancel := context.WithTimeout(ctx, time.Second)
+    defer cancel()
+    host := ""
+    if names, err := net.DefaultResolver.LookupAddr(ctx, ip); err == nil && len(names) > 0 {
+        host = strings.TrimSuffix(names[0], ".")
+    }
+    cache[ip] = host
+    return host
+}
+
+func msPtr(v float64) *float64 { return &v }
+
+// trace walks TTLs up to MaxHops (or limit when > 0) and returns the hops and whether the destination answered.
+func (t *tracer) trace(limit int) ([]*traceHop, bool, error) {
+    maxHops := t.opts.MaxHops
+    if limit > 0 { maxHops = limit }
+    var hops []*traceHop
+    for ttl := 1; ttl <= maxHops; ttl++ {
+        hop := &traceHop{TTL: ttl}
+        reached, stop := false, false
+        for i := 0; i < t.opts.Probes; i++ {
+            if err := t.ctx.Err(); err != nil { return hops, false, err }
+            res, err := t.probe(ttl)
+            if err != nil && !isTimeout(err) { return hops, false, err }
+            if err != nil || res.ip == nil {
+                hop.RTTs = append(hop.RTTs, nil)
+                continue
+            }
+            if hop.IP == "" { hop.IP = res.ip.String() }
+            // an unreachable error ends the trace even if it came from an intermediate router
+            if res.note != "" { hop.Note = res.note; stop = true }
+            hop.RTTs = append(hop.RTTs, msPtr(durMs(res.rtt)))
+            reached = reached || res.reached
+        }
+        hops = append(hops, hop)
+        if reached || stop { return hops, reached, nil }
+    }
+    return hops, false, nil
+}
+
+// mtr repeats the trace and folds the cycles into per-hop statistics.
+func (t *tracer) mtr() ([]*traceHop, bool, error) {
+    var stats []*traceHop
+    reachedAny := false
+    limit := 0
+    for cycle := 0; cycle < t.opts.Cycles; cycle++ {
+        hops, reached, err := t.trace(limit)
+        if err != nil { return stats, reachedAny, err }
+        if reached {
+            reachedAny = true
+            // later cycles do not need to probe beyond the destination
+            limit = len(hops)
+        }
+        for i, h := range hops {
+            if i >= len(stats) { stats = append(stats, &traceHop{TTL: h.TTL}) }
+            s := stats[i]
+            if s.IP == "" { s.IP = h.IP }
+            if h.Note != "" { s.Note = h.Note }
+            s.RTTs = append(s.RTTs, h.RTTs...)
+        }
+    }
+    for _, s := range stats {
+        var sum, sum2 float64
+        for _, r := range s.RTTs {
+            s.Sent++
+            if r == nil { continue }
+            s.Received++
+            v := *r
+            if s.Best == nil || v < *s.Best { s.Best = msPtr(v) }
+            if s.Worst == nil || v > *s.Worst { s.Worst = msPtr(v) }
+            sum += v
+            sum2 += v * v
+        }
+        s.LossPct = msPtr(roundMs(float64(s.Sent-s.Received) * 100 / float64(max(s.Sent, 1))))
+        if s.Received > 0 {
+            avg := sum / float64(s.Received)
+            s.Avg = msPtr(roundMs(avg))
+            s.StDev = msPtr(roundMs(math.Sqrt(math.Max(sum2/float64(s.Received)-avg*avg, 0))))
+        }
+    }
+    return stats, reachedAny, nil
+}
+
+// traceroute performs a built-in traceroute (or MTR when opts.MTR is set) towards target.
+func traceroute(ctx context.Context, target string, opts tracerouteOptions) (ok bool, latency int64, msg string, hops []*traceHop) {
+    opts.normalize()
+    switch opts.Protocol {
+    case "udp", "icmp", "tcp":
+    default:
+        return false, 0, "unsupported traceroute protocol: " + opts.Protocol, nil
+    }
+    start := time.Now()
+    ip, err := resolveIP(ctx, hostnameForDNS(target), opts.IPVersion)
+    if err != nil { return false, time.Since(start).Milliseconds(), err.Error(), nil }
+    t, err := newTracer(ctx, ip, opts)
+    if err != nil { return false, time.Since(start).Milliseconds(), err.Error(), nil }
+    defer t.Close()
+    stop := context.AfterFunc(ctx, func() { _ = t.Close() })
+    defer stop()
+
+    var reached bool
+    if opts.MTR {
+        hops, reached, err = t.mtr()
+    } else {
+        hops, reached, err = t.trace(0)
+    }
+    if !opts.NoDNS {
+        names := map[string]string{}
+        for _, h := range hops {
+            if h.IP != "" { h.Host = reverseDNS(ctx, names, h.IP) }
+        }
+    }
+    for _, h := range hops {
+        if h.IP != "" { h.Geo = geoDB.LookupString(h.IP) }
+    }
+    latency = time.Since(start).Milliseconds()
+    switch {
+    case err != nil:
+        return false, latency, err.Error(), hops
+    case !reached && len(hops) > 0 && hops[len(hops)-1].Note != "":
+        last := hops[len(hops)-1]
+        return false, latency, fmt.Sprintf("%s at hop %d (%s)", last.Note, last.TTL, last.IP), hops
+    case !reached:
+        return false, latency, fmt.Sprintf("destination %s not reached within %d hops", ip, opts.MaxHops), hops
+    }
+    return true, latency, "", hops
+}
@@ -0,0 +1,87 @@
+package checker
+
+import (
+    "encoding/binary"
+    "net"
+    "syscall"
+    "testing"
+    "time"
+
+    "golang.org/x/net/icmp"
+)
+
+// quotedTCP builds the IP header and the first 8 bytes of a TCP SYN an ICMP error quotes.
+func quotedTCP(dst net.IP, srcPort, dstPort int) []byte {
+    l4 := make([]byte, 8)
+    binary.BigEndian.PutUint16(l4[0:2], uint16(srcPort))
+    binary.BigEndian.PutUint16(l4[2:4], uint16(dstPort))
+    if dst.To4() == nil {
+        h := make([]byte, 40)
+        h[0], h[6], h[7] = 0x60, syscall.IPPROTO_TCP, 1
+        copy(h[8:24], net.ParseIP("2001:db8::10"))
+        copy(h[24:40], dst)
+        return append(h, l4...)
+    }
+    h := make([]byte, 20)
+    h[0], h[8], h[9] = 0x45, 1, syscall.IPPROTO_TCP
+    copy(h[12:16], net.IPv4(192, 0, 2, 10).To4())
+    copy(h[16:20], dst.To4())
+    return append(h, l4...)
+}
+
+func TestQuotedTCPProbe(t *testing.T) {
+    dst4, dst6 := net.IPv4(192, 0, 2, 1), net.ParseIP("2001:db8::1")
+    match := matchPorts(syscall.IPPROTO_TCP, func() int { return 40000 }, 443)
+    withOptions := quotedTCP(dst4, 40000, 443)
+    withOptions[0] = 0x46
+    withOptions = append(withOptions[:20], append(make([]byte, 4), withOptions[20:]...)...)
+    tests := []struct {
+        name   string
+        quoted []byte
+        dst    net.IP
+        want   bool
+    }{
+        {"our probe", quotedTCP(dst4, 40000, 443), dst4, true},
+        {"ip options", withOptions, dst4, true},
+        {"other source port", quotedTCP(dst4, 40001, 443), dst4, false},
+        {"other destination port", quotedTCP(dst4, 40000, 80), dst4, false},
+        {"other destination", quotedTCP(net.IPv4(192, 0, 2, 2), 40000, 443), dst4, false},
+        {"udp probe", func() []byte { b := quotedTCP(dst4, 40000, 443); b[9] = syscall.IPPROTO_UDP; return b }(), dst4, false},
+        {"truncated", quotedTCP(dst4, 40000, 443)[:26], dst4, false},
+        {"ipv6 probe", quotedTCP(dst6, 40000, 443), dst6, true},
+        {"ipv6 other source port", quotedTCP(dst6, 40001, 443), dst6, false},
+        {"ipv6 truncated", quotedTCP(dst6, 40000, 443)[:44], dst6, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            proto, qdst, l4, ok := quotedProbe(tt.quoted, tt.dst.To4() == nil)
+            got := ok && qdst.Equal(tt.dst) && match(proto, l4)
+            if got != tt.want { t.Errorf("matched = %v, want %v", got, tt.want) }
+        })
+    }
+}
+
+func TestMatchPortsReadsSourcePortLate(t *testing.T) {
+    // a TCP probe learns its source port from the dial goroutine after matching starts
+    port := 0
+    match := matchPorts(syscall.IPPROTO_TCP, func() int { return port }, 443)
+    _, _, l4, _ := quotedProbe(quotedTCP(net.IPv4(192, 0, 2, 1), 40000, 443), false)
+    if match(syscall.IPPROTO_TCP, l4) { t.Fatal("matched before the port was known") }
+    port = 40000
+    if !match(syscall.IPPROTO_TCP, l4) { t.Fatal("no match once the port is known") }
+}
+
+func TestAwaitICMPSettled(t *testing.T) {
+    c, err := icmp.ListenPacket("ip4:icmp", "127.0.0.1")
+    if err != nil { t.Skipf("icmp socket (needs CAP_NET_RAW): %v", err) }
+    tr := &tracer{dst: net.IPv4(192, 0, 2, 1), icmp: c}
+    defer tr.Close()
+    // the probe settled before awaitICMP armed its deadline, which overwrites the wake-up
+    settled := make(chan struct{})
+    close(settled)
+    _ = c.SetReadDeadline(time.Now())
+    start := time.Now()
+    _, err = tr.awaitICMP(start, start.Add(time.Minute), func(int, []byte) bool { return false }, 0, settled)
+    if !isTimeout(err) { t.Fatalf("err = %v, want a timeout", err) }
+    if waited := time.Since(start); waited > time.Second { t.Errorf("waited %v for a settled probe", waited) }
+}
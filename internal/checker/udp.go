@@ -0,0 +1,288 @@
+package checker
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+
+    "github.com/miekg/dns"
+)
+
+// udpOptions are the per-task parameters of the udp method.
+type udpOptions struct {
+    // Probe is dns, ntp, snmp, stun, hex or empty. By default it is picked from the
+    // port (53, 123, 161, 3478); a target without a port gets a DNS probe on 53.
+    Probe string `json:"probe"`
+    // Payload is the hex-encoded datagram for the hex probe.
+    Payload   string `json:"payload"`
+    Community string `json:"community"`
+    Retries   int    `json:"retries"`
+    TimeoutMs int    `json:"timeout_ms"`
+    IPVersion int    `json:"ip_version"`
+}
+
+func (o *udpOptions) normalize() {
+    o.Probe = strings.ToLower(strings.TrimSpace(o.Probe))
+    if o.Community == "" { o.Community = "public" }
+    if o.Retries <= 0 { o.Retries = 2 }
+    if o.Retries > 5 { o.Retries = 5 }
+    if o.TimeoutMs <= 0 { o.TimeoutMs = 2000 }
+}
+
+var udpProbePorts = map[string]int{"dns": 53, "ntp": 123, "snmp": 161, "stun": 3478}
+
+func udpProbeForPort(port int) string {
+    switch port {
+    case 53, 5353:
+        return "dns"
+    case 123:
+        return "ntp"
+    case 161:
+        return "snmp"
+    case 3478, 19302:
+        return "stun"
+    }
+    return "empty"
+}
+
+// explicitPort returns the port written in the target, if any.
+func explicitPort(target string) string {
+    t := strings.TrimSpace(target)
+    if strings.Contains(t, "://") {
+        if u, err := url.Parse(t); err == nil { return u.Port() }
+        return ""
+    }
+    if i := strings.Index(t, "/"); i > 0 { t = t[:i] }
+    if _, p, err := net.SplitHostPort(t); err == nil { return p }
+    return ""
+}
+
+// udpProbe builds a request datagram and knows how to read the answer to it.
+type udpProbe struct {
+    payload []byte
+    // parse describes a response; an error means the port answered with something else.
+    parse func(resp []byte) (map[string]any, error)
+}
+
+func dnsUDPProbe() (udpProbe, error) {
+    m := new(dns.Msg)
+    m.SetQuestion(".", dns.TypeNS)
+    b, err := m.Pack()
+    if err != nil { return udpProbe{}, err }
+    return udpProbe{payload: b, parse: func(resp []byte) (map[string]any, error) {
+        r := new(dns.Msg)
+        if err := r.Unpack(resp); err != nil { return nil, err }
+        if r.Id != m.Id || !r.Response { return nil, fmt.Errorf("not a reply to our query") }
+        return map[string]any{"rcode": dns.RcodeToString[r.Rcode], "answers": len(r.Answer), "recursion_available": r.RecursionAvailable}, nil
+    }}, nil
+}
+
+func ntpUDPProbe() udpProbe {
+    req := make([]byte, 48)
+    req[0] = 0x1b // LI 0, version 3, mode 3 (client)
+    sent := time.Now()
+    return udpProbe{payload: req, parse: func(resp []byte) (map[string]any, error) {
+        if len(resp) < 48 { return nil, fmt.Errorf("short NTP reply (%d bytes)", len(resp)) }
+        if mode := resp[0] & 0x07; mode != 4 { return nil, fmt.Errorf("unexpected NTP mode %d", mode) }
+        out := map[string]any{"stratum": int(resp[1]), "version": int(resp[0] >> 3 & 0x07)}
+        // transmit timestamp, seconds since 1900
+        secs := binary.BigEndian.Uint32(resp[40:44])
+        frac := binary.BigEndian.Uint32(resp[44:48])
+        if secs != 0 {
+            t := time.Unix(int64(secs)-2208988800, int64(float64(frac)/(1<<32)*1e9)).UTC()
+            out["server_time"] = t.Format(time.RFC3339Nano)
+            out["offset_ms"] = roundMs(float64(t.Sub(sent).Microseconds()) / 1000)
+        }
+        return out, nil
+    }}
+}
+
+// ber encodes one BER TLV.
+func ber(tag byte, content []byte) []byte {
+    n := len(content)
+    switch {
+    case n < 0x80:
+        return append([]byte{tag, byte(n)}, content...)
+    case n < 0x100:
+        return append([]byte{tag, 0x81, byte(n)}, content...)
+    default:
+        return append([]byte{tag, 0x82, byte(n >> 8), byte(n)}, content...)
+    }
+}
+
+// berNext splits the first TLV off b.
+func berNext(b []byte) (tag byte, content, rest []byte, err error) {
+    if len(b) < 2 { return 0, nil, nil, fmt.Errorf("truncated BER") }
+    tag, n, off := b[0], int(b[1]), 2
+    if n&0x80 != 0 {
+        l := n & 0x7f
+        if l == 0 || l > 2 || len(b) < 2+l { return 0, nil, nil, fmt.Errorf("bad BER length") }
+        n = 0
+        for _, x := range b[2 : 2+l] { n = n<<8 | int(x) }
+        off += l
+    }
+    if len(b) < off+n { return 0, nil, nil, fmt.Errorf("truncated BER") }
+    return tag, b[off : off+n], b[off+n:], nil
+}
+
+// snmpUDPProbe asks for sysDescr.0 with an SNMPv2c GetRequest.
+func snmpUDPProbe(community string) udpProbe {
+    var id [4]byte
+    _, _ = rand.Read(id[:])
+    id[0] &= 0x7f
+    sysDescr := []byte{0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00} // 1.3.6.1.2.1.1.1.0
+    varbind := ber(0x30, append(ber(0x06, sysDescr), 0x05, 0x00))
+    pdu := ber(0xa0, append(append(append(ber(0x02, id[:]), ber(0x02, []byte{0})...), ber(0x02, []byte{0})...), ber(0x30, varbind)...))
+    msg := ber(0x30, append(append(ber(0x02, []byte{1}), ber(0x04, []byte(community))...), pdu...))
+    return udpProbe{payload: msg, parse: func(resp []byte) (map[string]any, error) {
+        _, seq, _, err := berNext(resp)
+        if err != nil { return nil, err }
+        _, _, seq, err = berNext(seq) // version
+        if err != nil { return nil, err }
+        _, _, seq, err = berNext(seq) // community
+        if err != nil { return nil, err }
+        tag, pdu, _, err := berNext(seq)
+        if err != nil { return nil, err }
+        if tag != 0xa2 { return nil, fmt.Errorf("unexpected SNMP PDU 0x%x", tag) }
+        var fields [4][]byte
+        for i := range fields {
+            if _, fields[i], pdu, err = berNext(pdu); err != nil { return nil, err }
+        }
+        out := map[string]any{}
+        if len(fields[1]) == 1 && fields[1][0] != 0 { out["error_status"] = int(fields[1][0]) }
+        if _, vb, _, err := berNext(fields[3]); err == nil {
+            if _, _, val, err := berNext(vb); err == nil {
+                if t, v, _, err := berNext(val); err == nil && t == 0x04 { out["sys_descr"] = cleanBanner(v) }
+            }
+        }
+        return out, nil
+    }}
+}
+
+const stunMagicCookie = 0x2112a442
+
+// stunUDPProbe sends a STUN binding request (RFC 5389) and reports the reflexive address.
+func stunUDPProbe() udpProbe {
+    req := make([]byte, 20)
+    binary.BigEndian.PutUint16(req[0:], 0x0001)
+    binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+    _, _ = rand.Read(req[8:20])
+    return udpProbe{payload: req, parse: func(resp []byte) (map[string]any, error) {
+        if len(resp) < 20 || binary.BigEndian.Uint32(resp[4:]) != stunMagicCookie || string(resp[8:20]) != string(req[8:20]) {
+            return nil, fmt.Errorf("not a STUN reply to our request")
+        }
+        out := map[string]any{"message_type": fmt.Sprintf("0x%04x", binary.BigEndian.Uint16(resp[0:]))}
+        attrs := resp[20:]
+        for len(attrs) >= 4 {
+            typ, n := binary.BigEndian.Uint16(attrs[0:]), int(binary.BigEndian.Uint16(attrs[2:]))
+            // attributes are padded to 4 bytes; a short one ends the list
+            if len(attrs) < 4+((n+3)&^3) { break }
+            v := attrs[4 : 4+n]
+            if typ == 0x0020 && (n == 8 && v[1] == 0x01 || n == 20 && v[1] == 0x02) { // XOR-MAPPED-ADDRESS, IPv4 or IPv6
+                port := binary.BigEndian.Uint16(v[2:]) ^ uint16(stunMagicCookie>>16)
+                ip := make(net.IP, n-4)
+                copy(ip, v[4:])
+                for i := range ip { ip[i] ^= resp[4+i] } // cookie followed by the transaction id
+                out["mapped_address"] = net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+            }
+            attrs = attrs[4+((n+3)&^3):]
+        }
+        return out, nil
+    }}
+}
+
+func buildUDPProbe(opts udpOptions) (udpProbe, error) {
+    switch opts.Probe {
+    case "dns":
+        return dnsUDPProbe()
+    case "ntp":
+        return ntpUDPProbe(), nil
+    case "snmp":
+        return snmpUDPProbe(opts.Community), nil
+    case "stun":
+        return stunUDPProbe(), nil
+    case "hex":
+        b, err := hex.DecodeString(strings.NewReplacer(" ", "", ":", "").Replace(opts.Payload))
+        if err != nil { return udpProbe{}, fmt.Errorf("invalid hex payload: %w", err) }
+        return udpProbe{payload: b}, nil
+    case "empty":
+        return udpProbe{payload: []byte{}}, nil
+    }
+    return udpProbe{}, fmt.Errorf("unknown udp probe %q", opts.Probe)
+}
+
+// udpCheck sends a protocol-aware datagram and waits for either an answer (open), an
+// ICMP port unreachable (closed) or nothing at all (open|filtered).
+func udpCheck(ctx context.Context, target string, opts udpOptions) (ok bool, latency int64, msg string, details map[string]any) {
+    opts.normalize()
+    start := time.Now()
+    host, _, _ := net.SplitHostPort(tcpAddress(target))
+    port, _ := strconv.Atoi(explicitPort(target))
+    if opts.Probe == "" {
+        opts.Probe = "dns"
+        if port != 0 { opts.Probe = udpProbeForPort(port) }
+    }
+    if port == 0 {
+        port = udpProbePorts[opts.Probe]
+        if port == 0 { return false, 0, "port is required for the " + opts.Probe + " probe", nil }
+    }
+    probe, err := buildUDPProbe(opts)
+    if err != nil { return false, 0, err.Error(), nil }
+    ip, err := resolveIP(ctx, host, opts.IPVersion)
+    if err != nil { return false, time.Since(start).Milliseconds(), err.Error(), nil }
+
+    details = map[string]any{"host": host, "ip": ip.String(), "port": port, "probe": opts.Probe, "status": "open|filtered"}
+    // a connected socket gets ICMP port unreachable reported as ECONNREFUSED
+    conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: port})
+    if err != nil { return false, time.Since(start).Milliseconds(), err.Error(), details }
+    defer conn.Close()
+    stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+    defer stop()
+
+    buf := make([]byte, 4096)
+    timeout := time.Duration(opts.TimeoutMs) * time.Millisecond
+    for attempt := 1; attempt <= opts.Retries; attempt++ {
+        details["attempts"] = attempt
+        sent := time.Now()
+        _, err := conn.Write(probe.payload)
+        n := 0
+        if err == nil {
+            _ = conn.SetReadDeadline(sent.Add(timeout))
+            n, err = conn.Read(buf)
+        }
+        if errors.Is(err, syscall.ECONNREFUSED) {
+            details["status"] = "closed"
+            details["rtt_ms"] = durMs(time.Since(sent))
+            return false, time.Since(start).Milliseconds(), "closed (ICMP port unreachable)", details
+        }
+        var ne net.Error
+        if errors.As(err, &ne) && ne.Timeout() { continue }
+        if err != nil { return false, time.Since(start).Milliseconds(), err.Error(), details }
+
+        details["status"] = "open"
+        details["rtt_ms"] = durMs(time.Since(sent))
+        details["response_bytes"] = n
+        preview := buf[:n]
+        if len(preview) > 64 { preview = preview[:64] }
+        details["response_hex"] = hex.EncodeToString(preview)
+        if probe.parse != nil {
+            info, err := probe.parse(buf[:n])
+            if err != nil {
+                details["parse_error"] = err.Error()
+            } else {
+                details["response"] = info
+            }
+        }
+        return true, time.Since(start).Milliseconds(), "", details
+    }
+    return false, time.Since(start).Milliseconds(), "no response (open|filtered)", details
+}
@@ -0,0 +1,52 @@
+package checker
+
+import (
+    "encoding/binary"
+    "testing"
+)
+
+// stunReply builds a binding success response to req carrying attrs.
+func stunReply(req []byte, attrs ...[]byte) []byte {
+    resp := make([]byte, 20)
+    binary.BigEndian.PutUint16(resp[0:], 0x0101)
+    copy(resp[4:20], req[4:20])
+    for _, a := range attrs { resp = append(resp, a...) }
+    binary.BigEndian.PutUint16(resp[2:], uint16(len(resp)-20))
+    return resp
+}
+
+func stunAttr(typ uint16, declared int, value []byte) []byte {
+    a := make([]byte, 4, 4+len(value))
+    binary.BigEndian.PutUint16(a[0:], typ)
+    binary.BigEndian.PutUint16(a[2:], uint16(declared))
+    return append(a, value...)
+}
+
+func TestSTUNParse(t *testing.T) {
+    p := stunUDPProbe()
+    cookie := p.payload[4:8]
+    // 192.0.2.1:3478 xor-ed with the cookie
+    mapped := []byte{0, 0x01, 0x0d ^ cookie[0], 0x96 ^ cookie[1], 192 ^ cookie[0], 0 ^ cookie[1], 2 ^ cookie[2], 1 ^ cookie[3]}
+
+    tests := []struct {
+        name   string
+        resp   []byte
+        mapped string
+    }{
+        {"xor mapped address", stunReply(p.payload, stunAttr(0x0020, 8, mapped)), "192.0.2.1:3478"},
+        {"unpadded last attribute", stunReply(p.payload, stunAttr(0x8022, 5, []byte("abcde"))), ""},
+        {"length past the end", stunReply(p.payload, stunAttr(0x0020, 200, mapped)), ""},
+        {"odd address length", stunReply(p.payload, stunAttr(0x0020, 12, append(mapped, 1, 2, 3, 4))), ""},
+        {"ipv6 family with ipv4 length", stunReply(p.payload, stunAttr(0x0020, 8, append([]byte{0, 0x02}, mapped[2:]...))), ""},
+        {"truncated header", append(stunReply(p.payload), 0x00, 0x20, 0x00), ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            out, err := p.parse(tt.resp)
+            if err != nil { t.Fatalf("parse: %v", err) }
+            if got, _ := out["mapped_address"].(string); got != tt.mapped {
+                t.Errorf("mapped_address = %q, want %q", got, tt.mapped)
+            }
+        })
+    }
+}
@@ -0,0 +1,181 @@
+package checker
+
+import (
+    "context"
+    "crypto/tls"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "net/http/httptrace"
+    "regexp"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    "github.com/gorilla/websocket"
+)
+
+// websocketOptions are the per-task parameters of the websocket method.
+type websocketOptions struct {
+    Subprotocols []string          `json:"subprotocols"`
+    Headers      map[string]string `json:"headers"`
+    // Message is sent as a text frame after the handshake; MessageHex as a binary one.
+    Message    string `json:"message"`
+    MessageHex string `json:"message_hex"`
+    // Expect is a regular expression the reply must match. Without a message the
+    // agent waits for a matching message pushed by the server.
+    Expect    string `json:"expect"`
+    TimeoutMs int    `json:"timeout_ms"`
+}
+
+// websocketURL maps the target to a ws:// or wss:// URL; bare hosts get wss.
+func websocketURL(target string) string {
+    t := strings.TrimSpace(target)
+    switch {
+    case strings.HasPrefix(t, "ws://"), strings.HasPrefix(t, "wss://"):
+        return t
+    case strings.HasPrefix(t, "http://"):
+        return "ws://" + strings.TrimPrefix(t, "http://")
+    case strings.HasPrefix(t, "https://"):
+        return "wss://" + strings.TrimPrefix(t, "https://")
+    }
+    return "wss://" + t
+}
+
+// wsPreview shows a frame as text when it is printable, as hex otherwise.
+func wsPreview(mt int, data []byte) string {
+    if len(data) > 512 { data = data[:512] }
+    if mt == websocket.TextMessage && utf8.Valid(data) { return string(data) }
+    return hex.EncodeToString(data)
+}
+
+// websocketCheck performs the upgrade handshake, optionally exchanges a message and
+// closes the connection, recording the close code the server answers with.
+func websocketCheck(ctx context.Context, target string, opts websocketOptions) (ok bool, code int, latency int64, msg string, details map[string]any) {
+    u := websocketURL(target)
+    timeout := time.Duration(timeoutMs(ctx, opts.TimeoutMs, 10*time.Second)) * time.Millisecond
+    var expect *regexp.Regexp
+    if opts.Expect != "" {
+        re, err := regexp.Compile(opts.Expect)
+        if err != nil { return false, 0, 0, "bad expect pattern: " + err.Error(), nil }
+        expect = re
+    }
+    var payload []byte
+    mt := websocket.TextMessage
+    if opts.MessageHex != "" {
+        b, err := hex.DecodeString(strings.ReplaceAll(opts.MessageHex, " ", ""))
+        if err != nil { return false, 0, 0, "bad message_hex: " + err.Error(), nil }
+        payload, mt = b, websocket.BinaryMessage
+    } else if opts.Message != "" {
+        payload = []byte(opts.Message)
+    }
+
+    header := http.Header{}
+    for k, v := range opts.Headers { header.Set(k, v) }
+    // released once the check is over, whether or not the handshake succeeded
+    stop := func() bool { return false }
+    defer func() { stop() }()
+    dialer := websocket.Dialer{
+        HandshakeTimeout: timeout,
+        Subprotocols:     opts.Subprotocols,
+        TLSClientConfig:  &tls.Config{InsecureSkipVerify: true},
+        // the dialer only watches ctx while connecting; closing the socket also stops a
+        // stalled handshake and, later on, a blocked read or write
+        NetDialContext: func(dctx context.Context, network, addr string) (net.Conn, error) {
+            var d net.Dialer
+            conn, err := d.DialContext(dctx, network, addr)
+            if err == nil { stop = context.AfterFunc(ctx, func() { _ = conn.Close() }) }
+            return conn, err
+        },
+    }
+    tm := &httpTimings{}
+    dctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+    dctx = httptrace.WithClientTrace(dctx, tm.trace())
+
+    start := time.Now()
+    tm.start = start
+    conn, resp, err := dialer.DialContext(dctx, u, header)
+    tm.done = time.Now()
+    details = map[string]any{"url": u, "timings": tm.asMap(), "remote_ip": hostOnly(tm.remoteAddr), "handshake_ms": durMs(tm.done.Sub(start))}
+    if resp != nil {
+        code = resp.StatusCode
+        if s := resp.Header.Get("Server"); s != "" { details["server"] = s }
+    }
+    if err != nil {
+        if errors.Is(err, websocket.ErrBadHandshake) && resp != nil { err = fmt.Errorf("handshake refused with %s", resp.Status) }
+        return false, code, time.Since(start).Milliseconds(), err.Error(), details
+    }
+    defer func() { _ = conn.Close() }()
+    details["subprotocol"] = conn.Subprotocol()
+    if ext := resp.Header.Get("Sec-WebSocket-Extensions"); ext != "" { details["extensions"] = ext }
+    if tc, isTLS := conn.UnderlyingConn().(*tls.Conn); isTLS {
+        tlsDet, _ := describeTLS(tc.ConnectionState(), hostnameForDNS(u))
+        details["tls"] = tlsDet
+    }
+    if len(opts.Subprotocols) > 0 && conn.Subprotocol() == "" { msg = "server did not accept any of the subprotocols" }
+
+    // a close frame from the server ends ReadMessage with a *websocket.CloseError; the
+    // default handler would replace it with ErrCloseSent when the agent closed first
+    conn.SetCloseHandler(func(code int, _ string) error {
+        _ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+        return nil
+    })
+    closeInfo := func(err error) {
+        var ce *websocket.CloseError
+        if errors.As(err, &ce) {
+            details["close_code"] = ce.Code
+            if ce.Text != "" { details["close_reason"] = ce.Text }
+        }
+    }
+    deadline := start.Add(timeout)
+    if msg == "" && (payload != nil || expect != nil) {
+        _ = conn.SetWriteDeadline(deadline)
+        _ = conn.SetReadDeadline(deadline)
+        sent := time.Now()
+        if payload != nil {
+            if err := conn.WriteMessage(mt, payload); err != nil { return false, code, time.Since(start).Milliseconds(), "send: " + err.Error(), details }
+        }
+        received := 0
+        for {
+            rmt, data, err := conn.ReadMessage()
+            if err != nil {
+                closeInfo(err)
+                msg = "no reply: " + err.Error()
+                if expect != nil && received > 0 { msg = fmt.Sprintf("no reply matching %q among %d messages: %v", opts.Expect, received, err) }
+                break
+            }
+            received++
+            if expect == nil || expect.Match(data) {
+                details["message_rtt_ms"] = durMs(time.Since(sent))
+                details["reply"] = wsPreview(rmt, data)
+                break
+            }
+        }
+        details["messages_received"] = received
+    }
+
+    if _, closed := details["close_code"]; !closed {
+        // polite close; the server's close frame carries its close code
+        _ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+        closeBy := time.Now().Add(2 * time.Second)
+        if closeBy.After(deadline) { closeBy = deadline }
+        _ = conn.SetReadDeadline(closeBy)
+        for {
+            if _, _, err := conn.ReadMessage(); err != nil {
+                closeInfo(err)
+                break
+            }
+        }
+    }
+    latency = time.Since(start).Milliseconds()
+    if msg == "" {
+        parts := []string{fmt.Sprintf("handshake %v ms", details["handshake_ms"])}
+        if p := conn.Subprotocol(); p != "" { parts = append(parts, "subprotocol "+p) }
+        if rtt, found := details["message_rtt_ms"]; found { parts = append(parts, fmt.Sprintf("reply in %v ms", rtt)) }
+        return true, code, latency, strings.Join(parts, ", "), details
+    }
+    return false, code, latency, msg, details
+}
@@ -0,0 +1,147 @@
+package checker
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// wsServer upgrades every request with the given subprotocols and hands the connection to serve.
+func wsServer(t *testing.T, subprotocols []string, serve func(c *websocket.Conn)) string {
+    up := websocket.Upgrader{Subprotocols: subprotocols}
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/forbidden" {
+            http.Error(w, "go away", http.StatusForbidden)
+            return
+        }
+        c, err := up.Upgrade(w, r, nil)
+        if err != nil { return }
+        defer c.Close()
+        serve(c)
+    }))
+    t.Cleanup(srv.Close)
+    return srv.URL
+}
+
+// echo answers every message until the client closes.
+func echo(c *websocket.Conn) {
+    for {
+        mt, data, err := c.ReadMessage()
+        if err != nil { return }
+        if err := c.WriteMessage(mt, data); err != nil { return }
+    }
+}
+
+func TestWebsocketCheck(t *testing.T) {
+    tests := []struct {
+        name    string
+        path    string
+        server  []string
+        opts    websocketOptions
+        serve   func(c *websocket.Conn)
+        ok      bool
+        code    int
+        msg     string
+        details map[string]any
+    }{
+        {
+            name:    "echo",
+            opts:    websocketOptions{Message: "ping", Expect: "^ping$"},
+            serve:   echo,
+            ok:      true,
+            code:    http.StatusSwitchingProtocols,
+            details: map[string]any{"reply": "ping", "messages_received": 1, "close_code": websocket.CloseNormalClosure},
+        },
+        {
+            name:    "binary echo",
+            opts:    websocketOptions{MessageHex: "de ad be ef"},
+            serve:   echo,
+            ok:      true,
+            code:    http.StatusSwitchingProtocols,
+            details: map[string]any{"reply": "deadbeef"},
+        },
+        {
+            name: "pushed message",
+            opts: websocketOptions{Expect: "ready"},
+            serve: func(c *websocket.Conn) {
+                _ = c.WriteMessage(websocket.TextMessage, []byte("hello"))
+                _ = c.WriteMessage(websocket.TextMessage, []byte("ready"))
+                echo(c)
+            },
+            ok:      true,
+            code:    http.StatusSwitchingProtocols,
+            details: map[string]any{"reply": "ready", "messages_received": 2},
+        },
+        {
+            name:    "subprotocol accepted",
+            server:  []string{"v2.chat"},
+            opts:    websocketOptions{Subprotocols: []string{"v1.chat", "v2.chat"}},
+            serve:   echo,
+            ok:      true,
+            code:    http.StatusSwitchingProtocols,
+            details: map[string]any{"subprotocol": "v2.chat"},
+        },
+        {
+            name:  "subprotocol refused",
+            opts:  websocketOptions{Subprotocols: []string{"v1.chat"}},
+            serve: echo,
+            code:  http.StatusSwitchingProtocols,
+            msg:   "server did not accept any of the subprotocols",
+        },
+        {
+            name: "server closes",
+            opts: websocketOptions{Message: "auth"},
+            serve: func(c *websocket.Conn) {
+                _, _, _ = c.ReadMessage()
+                _ = c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "unauthorized"), time.Now().Add(time.Second))
+                _, _, _ = c.ReadMessage()
+            },
+            code:    http.StatusSwitchingProtocols,
+            msg:     "no reply: websocket: close 4001: unauthorized",
+            details: map[string]any{"close_code": 4001, "close_reason": "unauthorized"},
+        },
+        {
+            name: "handshake refused",
+            path: "/forbidden",
+            code: http.StatusForbidden,
+            msg:  "handshake refused with 403 Forbidden",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            target := wsServer(t, tt.server, tt.serve) + tt.path
+            ok, code, _, msg, details := websocketCheck(context.Background(), target, tt.opts)
+            if ok != tt.ok || code != tt.code || (tt.msg != "" && msg != tt.msg) {
+                t.Errorf("websocketCheck = %v, %d, %q; want %v, %d, %q", ok, code, msg, tt.ok, tt.code, tt.msg)
+            }
+            for k, want := range tt.details {
+                if got := details[k]; got != want { t.Errorf("details[%s] = %#v, want %#v", k, got, want) }
+            }
+        })
+    }
+}
+
+func TestWebsocketCheckCanceledHandshake(t *testing.T) {
+    // accepts the TCP connection but never answers the upgrade request
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+    defer ln.Close()
+    go func() {
+        c, err := ln.Accept()
+        if err != nil { return }
+        defer c.Close()
+        _, _ = c.Read(make([]byte, 4096))
+        time.Sleep(5 * time.Second)
+    }()
+    ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+    defer cancel()
+    start := time.Now()
+    ok, _, _, msg, _ := websocketCheck(ctx, "ws://"+ln.Addr().String()+"/", websocketOptions{TimeoutMs: 10000})
+    if ok || msg == "" { t.Errorf("ok = %v, msg = %q", ok, msg) }
+    if waited := time.Since(start); waited > 2*time.Second { t.Errorf("handshake outlived the context by %v", waited) }
+}
@@ -0,0 +1,421 @@
+package checker
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "golang.org/x/net/publicsuffix"
+)
+
+const (
+    whoisIANA     = "whois.iana.org"
+    whoisMaxBytes = 1 << 20
+    whoisMaxHops  = 3
+)
+
+// whoisQuery sends one query to a WHOIS server on port 43 and returns the full answer.
+func whoisQuery(ctx context.Context, server, query string) (string, error) {
+    addr := server
+    if _, _, err := net.SplitHostPort(addr); err != nil { addr = net.JoinHostPort(server, "43") }
+    d := net.Dialer{Timeout: 5 * time.Second}
+    conn, err := d.DialContext(ctx, "tcp", addr)
+    if err != nil { return "", err }
+    defer conn.Close()
+    stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+    defer stop()
+    _ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+    if _, err := conn.Write([]byte(query + "\r\n")); err != nil { return "", err }
+    b, err := io.ReadAll(io.LimitReader(conn, whoisMaxBytes))
+    if err != nil && len(b) == 0 { return "", err }
+    return string(b), nil
+}
+
+// whoisReferral finds the next server to ask in an answer (IANA "refer:", thin
+// registry "Registrar WHOIS Server:", ARIN "ReferralServer:").
+func whoisReferral(text string) string {
+    sc := bufio.NewScanner(strings.NewReader(text))
+    for sc.Scan() {
+        k, v, ok := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+        if !ok { continue }
+        switch strings.ToLower(strings.TrimSpace(k)) {
+        case "refer", "whois", "registrar whois server", "referralserver":
+            v = strings.TrimSpace(v)
+            v = strings.TrimPrefix(strings.TrimPrefix(v, "whois://"), "rwhois://")
+            v = strings.TrimSuffix(v, "/")
+            if v != "" && !strings.Contains(v, " ") { return v }
+        }
+    }
+    return ""
+}
+
+// whoisQueryFor adapts the query syntax of servers that need it.
+func whoisQueryFor(server, q string, isIP bool) string {
+    s := strings.ToLower(server)
+    switch {
+    case isIP && strings.HasPrefix(s, "whois.arin.net"):
+        return "n + " + q
+    case !isIP && strings.HasPrefix(s, "whois.denic.de"):
+        return "-T dn " + q
+    }
+    return q
+}
+
+// whoisLookup follows referrals starting at IANA and returns every server visited
+// together with the most specific answer.
+func whoisLookup(ctx context.Context, q string, isIP bool) (servers []string, text string, err error) {
+    server := whoisIANA
+    for i := 0; i <= whoisMaxHops && server != ""; i++ {
+        ans, qerr := whoisQuery(ctx, server, whoisQueryFor(server, q, isIP))
+        if qerr != nil {
+            if text == "" { return servers, "", qerr }
+            break
+        }
+        servers = append(servers, server)
+        text = ans
+        next := whoisReferral(ans)
+        if next == "" || strings.EqualFold(next, server) { break }
+        seen := false
+        for _, s := range servers { if strings.EqualFold(s, next) { seen = true } }
+        if seen { break }
+        server = next
+    }
+    return servers, text, nil
+}
+
+// whoisFieldKeys maps normalized output fields to the keys used by registries and RIRs.
+var whoisFieldKeys = map[string][]string{
+    "registrar":    {"registrar", "sponsoring registrar", "registrar name"},
+    "created":      {"creation date", "created", "registered on", "registered", "domain registration date", "regdate", "created on"},
+    "updated":      {"updated date", "last-modified", "changed", "last updated", "updated", "updated on"},
+    "expires":      {"registry expiry date", "registrar registration expiration date", "expiration date", "expiry date", "paid-till", "expires", "expires on", "free-date"},
+    "name_servers": {"name server", "nserver", "nameserver", "name servers"},
+    "status":       {"domain status", "status", "state"},
+    "abuse_email":  {"registrar abuse contact email", "abuse-mailbox", "orgabuseemail", "abuse email"},
+    "netblock":     {"inetnum", "inet6num", "netrange", "cidr"},
+    "netname":      {"netname", "net-name"},
+    "asn":          {"origin", "originas", "aut-num"},
+    "org":          {"org-name", "orgname", "organization", "owner", "descr"},
+    "country":      {"country"},
+}
+
+// multiValued fields collect every occurrence instead of the first one.
+var whoisMultiValued = map[string]bool{"name_servers": true, "status": true}
+
+func parseWhois(text string) map[string]any {
+    byKey := map[string]string{}
+    for field, keys := range whoisFieldKeys {
+        for _, k := range keys { byKey[k] = field }
+    }
+    out := map[string]any{}
+    lists := map[string][]string{}
+    sc := bufio.NewScanner(strings.NewReader(text))
+    sc.Buffer(make([]byte, 64*1024), whoisMaxBytes)
+    for sc.Scan() {
+        line := strings.TrimSpace(sc.Text())
+        if line == "" || strings.HasPrefix(line, "%") || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ">>>") { continue }
+        k, v, ok := strings.Cut(line, ":")
+        if !ok { continue }
+        field, known := byKey[strings.ToLower(strings.TrimSpace(k))]
+        v = strings.TrimSpace(v)
+        if !known || v == "" { continue }
+        if whoisMultiValued[field] {
+            // "clientTransferProhibited https://icann.org/epp#..." -> first token
+            if field == "status" { v = strings.Fields(v)[0] }
+            if field == "name_servers" { v = strings.ToLower(strings.TrimSuffix(strings.Fields(v)[0], ".")) }
+            if !containsFold(lists[field], v) { lists[field] = append(lists[field], v) }
+            continue
+        }
+        if _, dup := out[field]; !dup { out[field] = v }
+    }
+    for k, v := range lists { out[k] = v }
+    for _, k := range []string{"created", "updated", "expires"} {
+        if s, ok := out[k].(string); ok {
+            if t, ok := parseWhoisDate(s); ok { out[k] = t.UTC().Format(time.RFC3339) }
+        }
+    }
+    return out
+}
+
+func containsFold(list []string, v string) bool {
+    for _, s := range list { if strings.EqualFold(s, v) { return true } }
+    return false
+}
+
+var whoisDateLayouts = []string{
+    time.RFC3339, "2006-01-02T15:04:05Z", "2006-01-02T15:04:05.0Z", "2006-01-02T15:04:05", "2006-01-02 15:04:05",
+    "2006-01-02 15:04:05 MST", "2006-01-02", "2006.01.02", "2006.01.02 15:04:05", "02-Jan-2006", "02.01.2006", "20060102",
+    "Mon Jan 2 15:04:05 MST 2006", "January 2 2006",
+}
+
+func parseWhoisDate(s string) (time.Time, bool) {
+    s = strings.TrimSpace(s)
+    if i := strings.Index(s, " ("); i > 0 { s = s[:i] }
+    for _, l := range whoisDateLayouts {
+        if t, err := time.Parse(l, s); err == nil { return t, true }
+    }
+    return time.Time{}, false
+}
+
+// --- RDAP ---
+
+// rdapBootstrap caches the IANA RDAP bootstrap registries for the agent lifetime.
+var rdapBootstrap = struct {
+    sync.Mutex
+    loaded  map[string]time.Time
+    entries map[string][][2][]string
+}{loaded: map[string]time.Time{}, entries: map[string][][2][]string{}}
+
+// rdapClient has no timeout of its own, requests are bounded by the check context.
+var rdapClient = &http.Client{}
+
+// rdapServices returns [keys, urls] pairs from https://data.iana.org/rdap/<kind>.json.
+// The lock only guards the cache: concurrent checks on a cold cache may each fetch the
+// registry, but none waits on another check's download.
+func rdapServices(ctx context.Context, kind string) ([][2][]string, error) {
+    rdapBootstrap.Lock()
+    t, ok := rdapBootstrap.loaded[kind]
+    cached := rdapBootstrap.entries[kind]
+    rdapBootstrap.Unlock()
+    if ok && time.Since(t) < 24*time.Hour { return cached, nil }
+
+    req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://data.iana.org/rdap/"+kind+".json", nil)
+    resp, err := rdapClient.Do(req)
+    if err != nil { return nil, err }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK { return nil, fmt.Errorf("rdap bootstrap: status %d", resp.StatusCode) }
+    var doc struct { Services [][][]string `json:"services"` }
+    if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil { return nil, err }
+    out := make([][2][]string, 0, len(doc.Services))
+    for _, s := range doc.Services {
+        if len(s) == 2 { out = append(out, [2][]string{s[0], s[1]}) }
+    }
+    rdapBootstrap.Lock()
+    rdapBootstrap.entries[kind] = out
+    rdapBootstrap.loaded[kind] = time.Now()
+    rdapBootstrap.Unlock()
+    return out, nil
+}
+
+func preferHTTPS(urls []string) string {
+    for _, u := range urls { if strings.HasPrefix(u, "https://") { return u } }
+    if len(urls) > 0 { return urls[0] }
+    return ""
+}
+
+// rdapBaseURL finds the RDAP service responsible for a domain or an IP address.
+func rdapBaseURL(ctx context.Context, q string, ip net.IP) (string, error) {
+    if ip != nil {
+        kind := "ipv4"
+        if ip.To4() == nil { kind = "ipv6" }
+        services, err := rdapServices(ctx, kind)
+        if err != nil { return "", err }
+        best, bestLen := "", -1
+        for _, s := range services {
+            for _, cidr := range s[0] {
+                _, n, err := net.ParseCIDR(cidr)
+                if err != nil || !n.Contains(ip) { continue }
+                if l, _ := n.Mask.Size(); l > bestLen { best, bestLen = preferHTTPS(s[1]), l }
+            }
+        }
+        if best == "" { return "", errors.New("no RDAP service for " + q) }
+        return best, nil
+    }
+    services, err := rdapServices(ctx, "dns")
+    if err != nil { return "", err }
+    labels := strings.Split(strings.ToLower(q), ".")
+    // longest matching suffix wins, e.g. "co.uk" before "uk"
+    for i := 1; i < len(labels); i++ {
+        suffix := strings.Join(labels[i:], ".")
+        for _, s := range services {
+            if containsFold(s[0], suffix) { return preferHTTPS(s[1]), nil }
+        }
+    }
+    return "", errors.New("no RDAP service for " + q)
+}
+
+type rdapEntity struct {
+    Roles     []string     `json:"roles"`
+    Handle    string       `json:"handle"`
+    VCard     []any        `json:"vcardArray"`
+    PublicIDs []struct {
+        Type       string `json:"type"`
+        Identifier string `json:"identifier"`
+    } `json:"publicIds"`
+    Entities  []rdapEntity `json:"entities"`
+}
+
+type rdapObject struct {
+    Handle       string   `json:"handle"`
+    LDHName      string   `json:"ldhName"`
+    Name         string   `json:"name"`
+    Type         string   `json:"type"`
+    Country      string   `json:"country"`
+    Status       []string `json:"status"`
+    StartAddress string   `json:"startAddress"`
+    EndAddress   string   `json:"endAddress"`
+    Events       []struct {
+        Action string `json:"eventAction"`
+        Date   string `json:"eventDate"`
+    } `json:"events"`
+    Nameservers  []struct {
+        LDHName string `json:"ldhName"`
+    } `json:"nameservers"`
+    CIDRs        []struct {
+        V4Prefix string `json:"v4prefix"`
+        V6Prefix string `json:"v6prefix"`
+        Length   int    `json:"length"`
+    } `json:"cidr0_cidrs"`
+    Entities     []rdapEntity `json:"entities"`
+}
+
+// vcardValue returns the first value of property name from a jCard array.
+func vcardValue(vcard []any, name string) string {
+    if len(vcard) < 2 { return "" }
+    props, _ := vcard[1].([]any)
+    for _, p := range props {
+        f, _ := p.([]any)
+        if len(f) < 4 { continue }
+        if n, _ := f[0].(string); n == name {
+            if v, ok := f[3].(string); ok { return v }
+        }
+    }
+    return ""
+}
+
+func hasRole(e rdapEntity, role string) bool {
+    for _, r := range e.Roles { if strings.EqualFold(r, role) { return true } }
+    return false
+}
+
+// findEntity searches entities (and nested ones) for the first with role.
+func findEntity(es []rdapEntity, role string) *rdapEntity {
+    for i := range es {
+        if hasRole(es[i], role) { return &es[i] }
+    }
+    for i := range es {
+        if e := findEntity(es[i].Entities, role); e != nil { return e }
+    }
+    return nil
+}
+
+func rdapLookup(ctx context.Context, q string, ip net.IP) (string, map[string]any, error) {
+    ctx, cancel := context.WithTimeout(ctx, checkTimeout(ctx, 8*time.Second))
+    defer cancel()
+    base, err := rdapBaseURL(ctx, q, ip)
+    if err != nil { return "", nil, err }
+    u := strings.TrimSuffix(base, "/") + "/domain/" + q
+    if ip != nil { u = strings.TrimSuffix(base, "/") + "/ip/" + ip.String() }
+    req, _ := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+    req.Header.Set("Accept", "application/rdap+json")
+    resp, err := rdapClient.Do(req)
+    if err != nil { return u, nil, err }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK { return u, nil, fmt.Errorf("rdap: status %d", resp.StatusCode) }
+    var obj rdapObject
+    if err := json.NewDecoder(io.LimitReader(resp.Body, whoisMaxBytes)).Decode(&obj); err != nil { return u, nil, err }
+
+    out := map[string]any{}
+    for _, ev := range obj.Events {
+        t, err := time.Parse(time.RFC3339, ev.Date)
+        if err != nil { continue }
+        switch ev.Action {
+        case "registration":
+            out["created"] = t.UTC().Format(time.RFC3339)
+        case "expiration":
+            out["expires"] = t.UTC().Format(time.RFC3339)
+        case "last changed":
+            out["updated"] = t.UTC().Format(time.RFC3339)
+        }
+    }
+    if len(obj.Status) > 0 { out["status"] = obj.Status }
+    if len(obj.Nameservers) > 0 {
+        ns := make([]string, 0, len(obj.Nameservers))
+        for _, n := range obj.Nameservers { ns = append(ns, strings.ToLower(strings.TrimSuffix(n.LDHName, "."))) }
+        out["name_servers"] = ns
+    }
+    if r := findEntity(obj.Entities, "registrar"); r != nil {
+        if fn := vcardValue(r.VCard, "fn"); fn != "" { out["registrar"] = fn }
+        for _, id := range r.PublicIDs {
+            if strings.Contains(strings.ToLower(id.Type), "iana") { out["registrar_iana_id"] = id.Identifier }
+        }
+    }
+    if a := findEntity(obj.Entities, "abuse"); a != nil {
+        if email := vcardValue(a.VCard, "email"); email != "" { out["abuse_email"] = email }
+    }
+    if ip != nil {
+        if obj.StartAddress != "" { out["netblock"] = obj.StartAddress + " - " + obj.EndAddress }
+        var cidrs []string
+        for _, c := range obj.CIDRs {
+            p := c.V4Prefix
+            if p == "" { p = c.V6Prefix }
+            if p != "" { cidrs = append(cidrs, fmt.Sprintf("%s/%d", p, c.Length)) }
+        }
+        if len(cidrs) > 0 { out["cidr"] = cidrs }
+        if obj.Name != "" { out["netname"] = obj.Name }
+        if obj.Country != "" { out["country"] = obj.Country }
+        if reg := findEntity(obj.Entities, "registrant"); reg != nil {
+            if fn := vcardValue(reg.VCard, "fn"); fn != "" { out["org"] = fn }
+        }
+    }
+    return u, out, nil
+}
+
+// whoisCheck queries RDAP and classic WHOIS (following referrals) for the target's
+// registrable domain or IP address. RDAP fields take precedence, the raw WHOIS text is kept.
+func whoisCheck(ctx context.Context, target string) (ok bool, latency int64, msg string, details map[string]any) {
+    host := strings.TrimSuffix(hostnameForDNS(target), ".")
+    start := time.Now()
+    ip := net.ParseIP(host)
+    q := host
+    if ip == nil {
+        if d, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(host)); err == nil { q = d }
+    }
+    details = map[string]any{"query": q}
+
+    var errs []string
+    fields := map[string]any{}
+    servers, raw, werr := whoisLookup(ctx, q, ip != nil)
+    if werr != nil {
+        errs = append(errs, "whois: "+werr.Error())
+    } else {
+        details["servers"] = servers
+        details["raw"] = raw
+        for k, v := range parseWhois(raw) { fields[k] = v }
+    }
+    rdapURL, rdapFields, rerr := rdapLookup(ctx, q, ip)
+    if rdapURL != "" { details["rdap_url"] = rdapURL }
+    if rerr != nil {
+        errs = append(errs, "rdap: "+rerr.Error())
+    } else {
+        for k, v := range rdapFields { fields[k] = v }
+    }
+    for k, v := range fields { details[k] = v }
+    switch {
+    case rerr == nil:
+        details["source"] = "rdap"
+    case werr == nil:
+        details["source"] = "whois"
+    }
+    if ip != nil {
+        if _, ok := details["asn"]; !ok {
+            if info := geoDB.Lookup(ip); info != nil && info.ASN != "" { details["asn"] = info.ASN }
+        }
+    }
+    if s, ok := details["expires"].(string); ok {
+        if t, err := time.Parse(time.RFC3339, s); err == nil {
+            details["days_until_expiry"] = int(time.Until(t).Hours() / 24)
+        }
+    }
+    latency = time.Since(start).Milliseconds()
+    if werr != nil && rerr != nil { return false, latency, strings.Join(errs, "; "), details }
+    return true, latency, strings.Join(errs, "; "), details
+}
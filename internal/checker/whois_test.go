@@ -0,0 +1,224 @@
+package checker
+
+import (
+    "reflect"
+    "testing"
+    "time"
+)
+
+// Answers captured from live servers, trimmed to the interesting parts.
+const (
+    whoisIANAExample = `% IANA WHOIS server
+% for more information on IANA, visit http://www.iana.org
+% This query returned 1 object
+
+refer:        whois.verisign-grs.com
+
+domain:       COM
+
+organisation: VeriSign Global Registry Services
+address:      12061 Bluemont Way
+
+nserver:      A.GTLD-SERVERS.NET 192.5.6.30 2001:503:a83e:0:0:0:2:30
+ds-rdata:     19718 13 2 8acbb0cd28f41250a80a491389424d341522d946b0da0c0291f2d3d771d7805a
+
+whois:        whois.verisign-grs.com
+
+status:       ACTIVE
+remarks:      Registration information: http://www.verisigninc.com
+
+created:      1985-01-01
+changed:      2023-12-07
+source:       IANA
+`
+
+    whoisVerisignExample = `   Domain Name: EXAMPLE.COM
+   Registry Domain ID: 2336799_DOMAIN_COM-VRSN
+   Registrar WHOIS Server: whois.iana.org
+   Registrar URL: http://res-dom.iana.org
+   Updated Date: 2024-08-14T07:01:34Z
+   Creation Date: 1995-08-14T04:00:00Z
+   Registry Expiry Date: 2025-08-13T04:00:00Z
+   Registrar: RESERVED-Internet Assigned Numbers Authority
+   Registrar IANA ID: 376
+   Registrar Abuse Contact Email:
+   Domain Status: clientDeleteProhibited https://icann.org/epp#clientDeleteProhibited
+   Domain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited
+   Domain Status: clientUpdateProhibited https://icann.org/epp#clientUpdateProhibited
+   Name Server: A.IANA-SERVERS.NET
+   Name Server: B.IANA-SERVERS.NET
+   DNSSEC: signedDelegation
+   URL of the ICANN Whois Inaccuracy Complaint Form: https://www.icann.org/wicf/
+>>> Last update of whois database: 2024-09-01T10:12:45Z <<<
+`
+
+    whoisTCIExample = `% TCI Whois Service. Terms of use:
+% https://tcinet.ru/documents/whois_ru_rf.pdf (in Russian)
+
+domain:        YANDEX.RU
+nserver:       ns1.yandex.ru. 213.180.193.1
+nserver:       ns2.yandex.ru. 213.180.199.34
+org:           LLC Yandex
+taxpayer-id:   7736207543
+registrar:     RU-CENTER-RU
+admin-contact: https://www.nic.ru/whois
+created:       1997-09-23T09:45:07Z
+paid-till:     2025-09-30T21:00:00Z
+free-date:     2025-11-01
+source:        TCI
+
+Last updated on 2024-09-01T10:16:31Z
+`
+
+    whoisRIPEExample = `% This is the RIPE Database query service.
+% Information related to '77.88.0.0 - 77.88.63.255'
+
+inetnum:        77.88.0.0 - 77.88.63.255
+netname:        YANDEX-77-88-0
+descr:          Yandex enterprise network
+country:        RU
+admin-c:        YNDX1-RIPE
+status:         ASSIGNED PA
+mnt-by:         RIPE-NCC-END-MNT
+created:        2007-03-19T10:56:16Z
+last-modified:  2023-07-11T12:15:34Z
+source:         RIPE
+
+% Information related to '77.88.0.0/18AS13238'
+
+route:          77.88.0.0/18
+origin:         AS13238
+`
+
+    whoisARINExample = `#
+# ARIN WHOIS data and services are subject to the Terms of Use
+#
+
+NetRange:       77.0.0.0 - 77.255.255.255
+CIDR:           77.0.0.0/8
+NetName:        77-RIPE
+Organization:   RIPE Network Coordination Centre (RIPE)
+RegDate:        2006-08-29
+Updated:        2009-05-18
+
+ReferralServer:  whois://whois.ripe.net
+ResourceLink:  https://apps.db.ripe.net/db-web-ui/query
+`
+)
+
+func TestParseWhois(t *testing.T) {
+    tests := []struct {
+        name string
+        text string
+        want map[string]any
+    }{
+        {
+            name: "verisign thin registry",
+            text: whoisVerisignExample,
+            want: map[string]any{
+                "registrar":    "RESERVED-Internet Assigned Numbers Authority",
+                "created":      "1995-08-14T04:00:00Z",
+                "updated":      "2024-08-14T07:01:34Z",
+                "expires":      "2025-08-13T04:00:00Z",
+                "status":       []string{"clientDeleteProhibited", "clientTransferProhibited", "clientUpdateProhibited"},
+                "name_servers": []string{"a.iana-servers.net", "b.iana-servers.net"},
+            },
+        },
+        {
+            name: "tci",
+            text: whoisTCIExample,
+            want: map[string]any{
+                "registrar":    "RU-CENTER-RU",
+                "created":      "1997-09-23T09:45:07Z",
+                "expires":      "2025-09-30T21:00:00Z",
+                "name_servers": []string{"ns1.yandex.ru", "ns2.yandex.ru"},
+            },
+        },
+        {
+            name: "ripe inetnum",
+            text: whoisRIPEExample,
+            want: map[string]any{
+                "netblock": "77.88.0.0 - 77.88.63.255",
+                "netname":  "YANDEX-77-88-0",
+                "org":      "Yandex enterprise network",
+                "country":  "RU",
+                "status":   []string{"ASSIGNED"},
+                "created":  "2007-03-19T10:56:16Z",
+                "updated":  "2023-07-11T12:15:34Z",
+                "asn":      "AS13238",
+            },
+        },
+        {
+            name: "arin",
+            text: whoisARINExample,
+            want: map[string]any{
+                "netblock": "77.0.0.0 - 77.255.255.255",
+                "netname":  "77-RIPE",
+                "org":      "RIPE Network Coordination Centre (RIPE)",
+                "created":  "2006-08-29T00:00:00Z",
+                "updated":  "2009-05-18T00:00:00Z",
+            },
+        },
+        {
+            name: "no match",
+            text: "No match for \"NOPE-NOT-REGISTERED.COM\".\r\n>>> Last update of whois database: 2024-09-01T10:12:45Z <<<\r\n",
+            want: map[string]any{},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := parseWhois(tt.text)
+            if !reflect.DeepEqual(got, tt.want) { t.Errorf("parseWhois =\n%#v\nwant\n%#v", got, tt.want) }
+        })
+    }
+}
+
+func TestParseWhoisDate(t *testing.T) {
+    tests := []struct {
+        in   string
+        want string
+    }{
+        {"2025-08-13T04:00:00Z", "2025-08-13T04:00:00Z"},
+        {"2025-08-13T04:00:00.0Z", "2025-08-13T04:00:00Z"},
+        {"2025-08-13T07:00:00+03:00", "2025-08-13T04:00:00Z"},
+        {"2025-08-13 04:00:00", "2025-08-13T04:00:00Z"},
+        {"2025-08-13", "2025-08-13T00:00:00Z"},
+        {"2025.08.13", "2025-08-13T00:00:00Z"},
+        {"13-Aug-2025", "2025-08-13T00:00:00Z"},
+        {"13.08.2025", "2025-08-13T00:00:00Z"},
+        {"20250813", "2025-08-13T00:00:00Z"},
+        {" 2025-08-13 (YYYY-MM-DD)", "2025-08-13T00:00:00Z"},
+        {"August 13 2025", "2025-08-13T00:00:00Z"},
+        {"next tuesday", ""},
+        {"", ""},
+    }
+    for _, tt := range tests {
+        got, ok := parseWhoisDate(tt.in)
+        if tt.want == "" {
+            if ok { t.Errorf("parseWhoisDate(%q) = %v, want no date", tt.in, got) }
+            continue
+        }
+        if !ok || got.UTC().Format(time.RFC3339) != tt.want { t.Errorf("parseWhoisDate(%q) = %v, %v, want %s", tt.in, got, ok, tt.want) }
+    }
+}
+
+func TestWhoisReferral(t *testing.T) {
+    tests := []struct {
+        name string
+        text string
+        want string
+    }{
+        {"iana refer", whoisIANAExample, "whois.verisign-grs.com"},
+        {"registrar whois server", whoisVerisignExample, "whois.iana.org"},
+        {"arin referral server", whoisARINExample, "whois.ripe.net"},
+        {"rwhois with port", "ReferralServer:  rwhois://rwhois.example.net:4321/\n", "rwhois.example.net:4321"},
+        {"empty value", "Registrar WHOIS Server: \nwhois: whois.example.net\n", "whois.example.net"},
+        {"not a host", "whois: see https://example.net for details\n", ""},
+        {"final answer", whoisTCIExample, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := whoisReferral(tt.text); got != tt.want { t.Errorf("whoisReferral = %q, want %q", got, tt.want) }
+        })
+    }
+}
@@ -22,6 +22,9 @@ type Config struct {
     AgentImage    string
     DockerNetwork string
     ExternalRedisPort string
+    // ExpiryCheckHours is how often tracked targets are re-checked for expiry dates.
+    ExpiryCheckHours int
+    NotifyWebhookURL string
 }
 
 func getEnv(key, def string) string {
@@ -47,6 +50,8 @@ func Load() Config {
         AgentImage:    getEnv("AGENT_IMAGE", "aeza-agent:latest"),
         DockerNetwork: getEnv("DOCKER_NETWORK", "aeza_default"),
         ExternalRedisPort: getEnv("EXTERNAL_REDIS_PORT", "6379"),
+        ExpiryCheckHours: 24,
+        NotifyWebhookURL: getEnv("NOTIFY_WEBHOOK_URL", ""),
     }
     if v := os.Getenv("REDIS_DB"); v != "" {
         if n, err := strconv.Atoi(v); err == nil {
@@ -65,6 +70,11 @@ func Load() Config {
             cfg.TaskTTLSeconds = n
         }
     }
+    if v := os.Getenv("EXPIRY_CHECK_HOURS"); v != "" {
+        if n, err := strconv.Atoi(v); err == nil && n > 0 {
+            cfg.ExpiryCheckHours = n
+        }
+    }
     return cfg
 }
 
@@ -0,0 +1,205 @@
+package dnsclient
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "net"
+    "strings"
+    "time"
+
+    "github.com/miekg/dns"
+)
+
+// Answer is one resource record of a response in presentation form.
+type Answer struct {
+    Name string `json:"name"`
+    Type string `json:"type"`
+    TTL  uint32 `json:"ttl"`
+    Data string `json:"data"`
+}
+
+// Result is the outcome of a single query against a single server.
+type Result struct {
+    Resolver  string   `json:"resolver"`
+    Server    string   `json:"server"`
+    Name      string   `json:"name"`
+    Type      string   `json:"type"`
+    Rcode     string   `json:"rcode,omitempty"`
+    LatencyMs float64  `json:"latency_ms"`
+    AD        bool     `json:"authenticated_data,omitempty"`
+    Answers   []Answer `json:"answers"`
+    Error     string   `json:"error,omitempty"`
+}
+
+// OK reports whether the server answered with NOERROR (an empty answer is fine).
+func (r Result) OK() bool { return r.Error == "" && r.Rcode == dns.RcodeToString[dns.RcodeSuccess] }
+
+// Server is a resolver endpoint together with the label it was requested by.
+type Server struct {
+    Label string
+    Addr  string
+    // Recursive is false for authoritative servers, which are queried without RD.
+    Recursive bool
+}
+
+type Client struct {
+    Timeout time.Duration
+    // Servers replaces the resolvers from /etc/resolv.conf when set.
+    Servers []string
+}
+
+func New(timeout time.Duration) *Client {
+    if timeout <= 0 { timeout = 3 * time.Second }
+    return &Client{Timeout: timeout}
+}
+
+// Exchange sends m over UDP and retries over TCP when the answer is truncated.
+func (c *Client) Exchange(ctx context.Context, server string, m *dns.Msg) (*dns.Msg, time.Duration, error) {
+    udp := &dns.Client{Net: "udp", Timeout: c.Timeout, UDPSize: dns.DefaultMsgSize}
+    resp, rtt, err := udp.ExchangeContext(ctx, m, server)
+    if err != nil { return nil, rtt, err }
+    if !resp.Truncated { return resp, rtt, nil }
+    tcp := &dns.Client{Net: "tcp", Timeout: c.Timeout}
+    return tcp.ExchangeContext(ctx, m, server)
+}
+
+// NewMsg builds a query with EDNS0; dnssec sets the DO bit.
+func NewMsg(name string, qtype uint16, recursive, dnssec bool) *dns.Msg {
+    m := new(dns.Msg)
+    m.SetQuestion(dns.Fqdn(name), qtype)
+    m.RecursionDesired = recursive
+    m.SetEdns0(dns.DefaultMsgSize, dnssec)
+    return m
+}
+
+// RRData returns the rdata of rr without the owner/TTL/class/type header.
+func RRData(rr dns.RR) string {
+    return strings.TrimSpace(strings.TrimPrefix(rr.String(), rr.Header().String()))
+}
+
+func toAnswers(rrs []dns.RR) []Answer {
+    out := make([]Answer, 0, len(rrs))
+    for _, rr := range rrs {
+        h := rr.Header()
+        if h.Rrtype == dns.TypeOPT { continue }
+        out = append(out, Answer{Name: h.Name, Type: dns.TypeToString[h.Rrtype], TTL: h.Ttl, Data: RRData(rr)})
+    }
+    return out
+}
+
+// Query asks srv for name/qtype and always returns a Result, with Error set on transport failures.
+func (c *Client) Query(ctx context.Context, srv Server, name string, qtype uint16) Result {
+    if qtype == dns.TypePTR {
+        if ip := net.ParseIP(name); ip != nil {
+            if rev, err := dns.ReverseAddr(ip.String()); err == nil { name = rev }
+        }
+    }
+    res := Result{Resolver: srv.Label, Server: srv.Addr, Name: dns.Fqdn(name), Type: dns.TypeToString[qtype], Answers: []Answer{}}
+    start := time.Now()
+    resp, _, err := c.Exchange(ctx, srv.Addr, NewMsg(name, qtype, srv.Recursive, false))
+    res.LatencyMs = math.Round(float64(time.Since(start).Microseconds())/10) / 100
+    if err != nil {
+        res.Error = err.Error()
+        return res
+    }
+    res.Rcode = dns.RcodeToString[resp.Rcode]
+    res.AD = resp.AuthenticatedData
+    res.Answers = toAnswers(resp.Answer)
+    return res
+}
+
+// ParseType converts a record type mnemonic (A, CAA, TYPE65...) to its code.
+func ParseType(s string) (uint16, error) {
+    s = strings.ToUpper(strings.TrimSpace(s))
+    if t, ok := dns.StringToType[s]; ok { return t, nil }
+    var n uint16
+    if _, err := fmt.Sscanf(s, "TYPE%d", &n); err == nil { return n, nil }
+    return 0, fmt.Errorf("unknown record type %q", s)
+}
+
+// SystemServers returns the nameservers from /etc/resolv.conf as host:port.
+func SystemServers() []string {
+    cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+    if err != nil || len(cfg.Servers) == 0 { return []string{"127.0.0.1:53"} }
+    out := make([]string, 0, len(cfg.Servers))
+    for _, s := range cfg.Servers { out = append(out, net.JoinHostPort(s, cfg.Port)) }
+    return out
+}
+
+// systemServers returns c.Servers, or the resolvers from /etc/resolv.conf.
+func (c *Client) systemServers() []string {
+    if len(c.Servers) > 0 { return c.Servers }
+    return SystemServers()
+}
+
+// Lookup queries the system resolvers in order until one of them answers.
+func (c *Client) Lookup(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+    var lastErr error
+    for _, s := range c.systemServers() {
+        resp, _, err := c.Exchange(ctx, s, NewMsg(name, qtype, true, false))
+        if err == nil { return resp, nil }
+        lastErr = err
+    }
+    return nil, lastErr
+}
+
+// FindZone returns the apex of the zone name belongs to, using the SOA in the answer or authority section.
+func (c *Client) FindZone(ctx context.Context, name string) (string, error) {
+    resp, err := c.Lookup(ctx, name, dns.TypeSOA)
+    if err != nil { return "", err }
+    for _, rr := range append(resp.Answer, resp.Ns...) {
+        if soa, ok := rr.(*dns.SOA); ok { return soa.Hdr.Name, nil }
+    }
+    return "", fmt.Errorf("no SOA found for %s", name)
+}
+
+// AuthoritativeServers resolves the NS set of the zone containing name to addresses.
+func (c *Client) AuthoritativeServers(ctx context.Context, name string, limit int) ([]Server, error) {
+    zone, err := c.FindZone(ctx, name)
+    if err != nil { return nil, err }
+    resp, err := c.Lookup(ctx, zone, dns.TypeNS)
+    if err != nil { return nil, err }
+    var out []Server
+    for _, rr := range resp.Answer {
+        ns, ok := rr.(*dns.NS)
+        if !ok { continue }
+        ip := c.lookupAddr(ctx, ns.Ns)
+        if ip == nil { continue }
+        out = append(out, Server{Label: "auth:" + strings.TrimSuffix(ns.Ns, "."), Addr: net.JoinHostPort(ip.String(), "53")})
+        if limit > 0 && len(out) >= limit { break }
+    }
+    if len(out) == 0 { return nil, fmt.Errorf("no authoritative servers found for %s", zone) }
+    return out, nil
+}
+
+// lookupAddr resolves host through the same resolvers as Lookup, preferring IPv4.
+func (c *Client) lookupAddr(ctx context.Context, host string) net.IP {
+    for _, qt := range []uint16{dns.TypeA, dns.TypeAAAA} {
+        resp, err := c.Lookup(ctx, host, qt)
+        if err != nil { continue }
+        for _, rr := range resp.Answer {
+            switch r := rr.(type) {
+            case *dns.A:
+                return r.A
+            case *dns.AAAA:
+                return r.AAAA
+            }
+        }
+    }
+    return nil
+}
+
+// ResolveServers expands a resolver spec: "system", "auth"/"authoritative", or an IP/host with optional port.
+func (c *Client) ResolveServers(ctx context.Context, spec, name string) ([]Server, error) {
+    spec = strings.TrimSpace(spec)
+    switch strings.ToLower(spec) {
+    case "", "system":
+        return []Server{{Label: "system", Addr: c.systemServers()[0], Recursive: true}}, nil
+    case "auth", "authoritative":
+        return c.AuthoritativeServers(ctx, name, 4)
+    }
+    addr := spec
+    if _, _, err := net.SplitHostPort(addr); err != nil { addr = net.JoinHostPort(strings.Trim(spec, "[]"), "53") }
+    return []Server{{Label: spec, Addr: addr, Recursive: true}}, nil
+}
@@ -0,0 +1,104 @@
+package dnsclient
+
+import (
+    "context"
+    "net"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/miekg/dns"
+)
+
+// serveDNS runs h on a loopback UDP port and returns its address.
+func serveDNS(t *testing.T, h dns.Handler) string {
+    pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+    started := make(chan struct{})
+    srv := &dns.Server{PacketConn: pc, Handler: h, NotifyStartedFunc: func() { close(started) }}
+    go func() { _ = srv.ActivateAndServe() }()
+    <-started
+    t.Cleanup(func() { _ = srv.Shutdown() })
+    return pc.LocalAddr().String()
+}
+
+func TestParseType(t *testing.T) {
+    tests := []struct {
+        in      string
+        want    uint16
+        wantErr bool
+    }{
+        {"A", dns.TypeA, false},
+        {" aaaa ", dns.TypeAAAA, false},
+        {"caa", dns.TypeCAA, false},
+        {"HTTPS", dns.TypeHTTPS, false},
+        {"TYPE65", 65, false},
+        {"type257", 257, false},
+        {"TYPE", 0, true},
+        {"TYPE70000", 0, true},
+        {"NOPE", 0, true},
+        {"", 0, true},
+    }
+    for _, tt := range tests {
+        got, err := ParseType(tt.in)
+        if (err != nil) != tt.wantErr || got != tt.want { t.Errorf("ParseType(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.wantErr) }
+    }
+}
+
+// authZone answers like a recursive resolver for example.test and its two nameservers.
+var authZone = dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+    q := req.Question[0]
+    m := new(dns.Msg).SetReply(req)
+    soa, _ := dns.NewRR("example.test. 300 IN SOA ns1.example.test. hostmaster.example.test. 1 7200 900 1209600 300")
+    switch {
+    case q.Name == "example.test." && q.Qtype == dns.TypeSOA:
+        m.Answer = []dns.RR{soa}
+    case q.Name == "example.test." && q.Qtype == dns.TypeNS:
+        ns1, _ := dns.NewRR("example.test. 300 IN NS ns1.example.test.")
+        ns2, _ := dns.NewRR("example.test. 300 IN NS ns2.example.test.")
+        m.Answer = []dns.RR{ns1, ns2}
+    case q.Name == "ns1.example.test." && q.Qtype == dns.TypeA:
+        a, _ := dns.NewRR("ns1.example.test. 300 IN A 192.0.2.1")
+        m.Answer = []dns.RR{a}
+    case q.Name == "ns2.example.test." && q.Qtype == dns.TypeAAAA:
+        aaaa, _ := dns.NewRR("ns2.example.test. 300 IN AAAA 2001:db8::2")
+        m.Answer = []dns.RR{aaaa}
+    case dns.IsSubDomain("example.test.", q.Name):
+        m.Ns = []dns.RR{soa}
+    default:
+        m.Rcode = dns.RcodeNameError
+    }
+    _ = w.WriteMsg(m)
+})
+
+func TestResolveServers(t *testing.T) {
+    fake := serveDNS(t, authZone)
+    tests := []struct {
+        name    string
+        servers []string
+        spec    string
+        want    []Server
+        wantErr bool
+    }{
+        {name: "system", spec: "system", want: []Server{{Label: "system", Addr: SystemServers()[0], Recursive: true}}},
+        {name: "empty spec", spec: " ", want: []Server{{Label: "system", Addr: SystemServers()[0], Recursive: true}}},
+        {name: "configured system", servers: []string{"192.0.2.53:53"}, spec: "System", want: []Server{{Label: "system", Addr: "192.0.2.53:53", Recursive: true}}},
+        {name: "ipv4", spec: "1.1.1.1", want: []Server{{Label: "1.1.1.1", Addr: "1.1.1.1:53", Recursive: true}}},
+        {name: "ipv4 with port", spec: "9.9.9.9:5353", want: []Server{{Label: "9.9.9.9:5353", Addr: "9.9.9.9:5353", Recursive: true}}},
+        {name: "ipv6", spec: "2606:4700::1111", want: []Server{{Label: "2606:4700::1111", Addr: "[2606:4700::1111]:53", Recursive: true}}},
+        {name: "bracketed ipv6", spec: "[2606:4700::1111]", want: []Server{{Label: "[2606:4700::1111]", Addr: "[2606:4700::1111]:53", Recursive: true}}},
+        {name: "hostname", spec: "dns.google", want: []Server{{Label: "dns.google", Addr: "dns.google:53", Recursive: true}}},
+        {name: "auth", servers: []string{fake}, spec: "auth", want: []Server{{Label: "auth:ns1.example.test", Addr: "192.0.2.1:53"}, {Label: "auth:ns2.example.test", Addr: "[2001:db8::2]:53"}}},
+        {name: "auth without a zone", servers: []string{fake}, spec: "authoritative", wantErr: true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := &Client{Timeout: time.Second, Servers: tt.servers}
+            name := "www.example.test"
+            if tt.wantErr { name = "www.other.test" }
+            got, err := c.ResolveServers(context.Background(), tt.spec, name)
+            if (err != nil) != tt.wantErr { t.Fatalf("err = %v, want error %v", err, tt.wantErr) }
+            if !reflect.DeepEqual(got, tt.want) { t.Errorf("servers = %+v, want %+v", got, tt.want) }
+        })
+    }
+}
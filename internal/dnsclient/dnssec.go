@@ -0,0 +1,457 @@
+package dnsclient
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/miekg/dns"
+)
+
+// DNSSEC validation states (RFC 4033 section 5).
+const (
+    Secure        = "secure"
+    Insecure      = "insecure"
+    Bogus         = "bogus"
+    Indeterminate = "indeterminate"
+)
+
+// RootAnchors are the DS records of the root KSKs (KSK-2017 and KSK-2024) published by IANA.
+var RootAnchors = []string{
+    ". 86400 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+    ". 86400 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// ZoneStatus is one link of the chain of trust.
+type ZoneStatus struct {
+    Zone   string `json:"zone"`
+    Status string `json:"status"`
+    // DS lists the delegation signer records as "keytag algorithm digest-type".
+    DS      []string `json:"ds,omitempty"`
+    KeyTags []uint16 `json:"dnskey_tags,omitempty"`
+    Reason  string   `json:"reason,omitempty"`
+}
+
+// ValidationResult is the DNSSEC verdict for one name/type.
+type ValidationResult struct {
+    Name   string       `json:"name"`
+    Type   string       `json:"type"`
+    Server string       `json:"server"`
+    Status string       `json:"status"`
+    Reason string       `json:"reason,omitempty"`
+    Chain  []ZoneStatus `json:"chain"`
+}
+
+// Validator walks the delegation path from the root, fetching DS, DNSKEY and RRSIG
+// records through a recursive resolver with the CD bit set, and checks every link itself.
+type Validator struct {
+    client  *Client
+    server  string
+    anchors []*dns.DS
+    // Now is the clock signatures are checked against.
+    Now func() time.Time
+    // keys caches validated zone keys between lookups of the same run.
+    keys map[string][]*dns.DNSKEY
+}
+
+// NewValidator validates through server (host:port) starting from anchors;
+// nil anchors mean the root trust anchors.
+func NewValidator(client *Client, server string, anchors []*dns.DS) *Validator {
+    if anchors == nil {
+        for _, s := range RootAnchors {
+            rr, err := dns.NewRR(s)
+            if err == nil { anchors = append(anchors, rr.(*dns.DS)) }
+        }
+    }
+    return &Validator{client: client, server: server, anchors: anchors, Now: time.Now, keys: map[string][]*dns.DNSKEY{}}
+}
+
+// bogusError marks a failed check, as opposed to a failure to get an answer at all.
+type bogusError struct{ reason string }
+
+func (e *bogusError) Error() string { return e.reason }
+
+func bogusf(format string, args ...any) error { return &bogusError{fmt.Sprintf(format, args...)} }
+
+func (v *Validator) fetch(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+    m := NewMsg(name, qtype, true, true)
+    m.CheckingDisabled = true
+    resp, _, err := v.client.Exchange(ctx, v.server, m)
+    if err != nil { return nil, fmt.Errorf("%s %s: %w", name, dns.TypeToString[qtype], err) }
+    if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+        return nil, fmt.Errorf("%s %s: %s", name, dns.TypeToString[qtype], dns.RcodeToString[resp.Rcode])
+    }
+    return resp, nil
+}
+
+// rrset picks the records of one owner/type and the signatures covering them.
+func rrset(rrs []dns.RR, name string, qtype uint16) (set []dns.RR, sigs []*dns.RRSIG) {
+    for _, rr := range rrs {
+        h := rr.Header()
+        if !strings.EqualFold(h.Name, name) { continue }
+        if sig, ok := rr.(*dns.RRSIG); ok {
+            if sig.TypeCovered == qtype { sigs = append(sigs, sig) }
+            continue
+        }
+        if h.Rrtype == qtype { set = append(set, rr) }
+    }
+    return set, sigs
+}
+
+// verify accepts set when at least one signature by signer verifies with one of keys
+// and is inside its validity period.
+func (v *Validator) verify(set []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY, signer string) error {
+    _, err := v.verifySig(set, sigs, keys, signer)
+    return err
+}
+
+// verifySig is verify returning the signature that verified.
+func (v *Validator) verifySig(set []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY, signer string) (*dns.RRSIG, error) {
+    if len(set) == 0 { return nil, bogusf("empty RRset") }
+    h := set[0].Header()
+    what := h.Name + " " + dns.TypeToString[h.Rrtype]
+    if len(sigs) == 0 { return nil, bogusf("no RRSIG for %s", what) }
+    now := v.Now()
+    var reason error
+    for _, sig := range sigs {
+        if !strings.EqualFold(sig.SignerName, signer) {
+            reason = bogusf("RRSIG for %s is made by %s, expected %s", what, sig.SignerName, signer)
+            continue
+        }
+        for _, k := range keys {
+            if k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm { continue }
+            if !sig.ValidityPeriod(now) {
+                exp := time.Unix(int64(sig.Expiration), 0).UTC()
+                if now.After(exp) {
+                    reason = bogusf("RRSIG for %s (key %d) expired at %s", what, sig.KeyTag, exp.Format(time.RFC3339))
+                } else {
+                    reason = bogusf("RRSIG for %s (key %d) is not valid until %s", what, sig.KeyTag, time.Unix(int64(sig.Inception), 0).UTC().Format(time.RFC3339))
+                }
+                continue
+            }
+            if err := sig.Verify(k, set); err != nil {
+                reason = bogusf("RRSIG for %s (key %d) does not verify: %v", what, sig.KeyTag, err)
+                continue
+            }
+            return sig, nil
+        }
+        if reason == nil { reason = bogusf("no DNSKEY of %s matches RRSIG key tag %d for %s", signer, sig.KeyTag, what) }
+    }
+    return nil, reason
+}
+
+func dsString(ds *dns.DS) string { return fmt.Sprintf("%d %d %d", ds.KeyTag, ds.Algorithm, ds.DigestType) }
+
+// supportedDS drops DS records with algorithms or digests we cannot check; a zone that
+// only has such records is treated as insecure (RFC 4035 section 5.2).
+func supportedDS(in []*dns.DS) []*dns.DS {
+    var out []*dns.DS
+    for _, ds := range in {
+        switch ds.Algorithm {
+        case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512, dns.ECDSAP256SHA256, dns.ECDSAP384SHA384, dns.ED25519:
+        default:
+            continue
+        }
+        switch ds.DigestType {
+        case dns.SHA1, dns.SHA256, dns.SHA384:
+            out = append(out, ds)
+        }
+    }
+    return out
+}
+
+// zoneKeys fetches the DNSKEY set of zone and authenticates it against the DS set
+// from the parent: a key must match a DS digest and sign the whole DNSKEY RRset.
+func (v *Validator) zoneKeys(ctx context.Context, zone string, dss []*dns.DS, step *ZoneStatus) ([]*dns.DNSKEY, error) {
+    if keys, ok := v.keys[zone]; ok {
+        for _, k := range keys { step.KeyTags = append(step.KeyTags, k.KeyTag()) }
+        return keys, nil
+    }
+    resp, err := v.fetch(ctx, zone, dns.TypeDNSKEY)
+    if err != nil { return nil, err }
+    set, sigs := rrset(resp.Answer, zone, dns.TypeDNSKEY)
+    if len(set) == 0 { return nil, bogusf("DS exists for %s but the zone has no DNSKEY", zone) }
+    keys := make([]*dns.DNSKEY, 0, len(set))
+    for _, rr := range set {
+        k := rr.(*dns.DNSKEY)
+        keys = append(keys, k)
+        step.KeyTags = append(step.KeyTags, k.KeyTag())
+    }
+    var trusted []*dns.DNSKEY
+    for _, ds := range dss {
+        for _, k := range keys {
+            if k.KeyTag() != ds.KeyTag || k.Algorithm != ds.Algorithm { continue }
+            if d := k.ToDS(ds.DigestType); d != nil && strings.EqualFold(d.Digest, ds.Digest) { trusted = append(trusted, k) }
+        }
+    }
+    if len(trusted) == 0 {
+        tags := make([]string, 0, len(dss))
+        for _, ds := range dss { tags = append(tags, fmt.Sprint(ds.KeyTag)) }
+        return nil, bogusf("DS mismatch: no DNSKEY of %s matches DS key tag(s) %s", zone, strings.Join(tags, ", "))
+    }
+    if err := v.verify(set, sigs, trusted, zone); err != nil { return nil, err }
+    v.keys[zone] = keys
+    return keys, nil
+}
+
+// signedDenial returns the NSEC and NSEC3 records of the authority section whose
+// signatures verify with the zone keys.
+func (v *Validator) signedDenial(resp *dns.Msg, zone string, keys []*dns.DNSKEY) (nsec []*dns.NSEC, nsec3 []*dns.NSEC3, err error) {
+    for _, rr := range resp.Ns {
+        t := rr.Header().Rrtype
+        if t != dns.TypeNSEC && t != dns.TypeNSEC3 { continue }
+        set, sigs := rrset(resp.Ns, rr.Header().Name, t)
+        if e := v.verify(set, sigs, keys, zone); e != nil {
+            err = e
+            continue
+        }
+        switch x := rr.(type) {
+        case *dns.NSEC:
+            nsec = append(nsec, x)
+        case *dns.NSEC3:
+            nsec3 = append(nsec3, x)
+        }
+    }
+    if len(nsec) > 0 || len(nsec3) > 0 { return nsec, nsec3, nil }
+    if err == nil { err = bogusf("no NSEC/NSEC3 records in the negative answer") }
+    return nil, nil, err
+}
+
+func hasType(bitmap []uint16, t uint16) bool {
+    for _, b := range bitmap {
+        if b == t { return true }
+    }
+    return false
+}
+
+// canonicalLess orders names as in RFC 4034 section 6.1: label by label from the right.
+func canonicalLess(a, b string) bool {
+    la, lb := dns.SplitDomainName(strings.ToLower(a)), dns.SplitDomainName(strings.ToLower(b))
+    for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+        if la[i] != lb[j] { return la[i] < lb[j] }
+    }
+    return len(la) < len(lb)
+}
+
+// nsecCovers reports whether name falls strictly between the owner and next name of n.
+func nsecCovers(n *dns.NSEC, name string) bool {
+    owner, next := n.Hdr.Name, n.NextDomain
+    if canonicalLess(owner, next) { return canonicalLess(owner, name) && canonicalLess(name, next) }
+    // last NSEC of the zone wraps around to the apex
+    return canonicalLess(owner, name) || canonicalLess(name, next)
+}
+
+// parentOf strips the leftmost label.
+func parentOf(name string) string {
+    idx := dns.Split(name)
+    if len(idx) < 2 { return "." }
+    return name[idx[1]:]
+}
+
+// proveNoData checks that name exists but has no qtype records.
+func proveNoData(nsec []*dns.NSEC, nsec3 []*dns.NSEC3, name string, qtype uint16) bool {
+    for _, n := range nsec {
+        if strings.EqualFold(n.Hdr.Name, name) && !hasType(n.TypeBitMap, qtype) && !hasType(n.TypeBitMap, dns.TypeCNAME) { return true }
+    }
+    for _, n := range nsec3 {
+        if n.Match(name) && !hasType(n.TypeBitMap, qtype) && !hasType(n.TypeBitMap, dns.TypeCNAME) { return true }
+    }
+    return false
+}
+
+// proveNXDomain checks that name and the wildcard at its closest encloser do not exist.
+func proveNXDomain(nsec []*dns.NSEC, nsec3 []*dns.NSEC3, name, zone string) bool {
+    if len(nsec) > 0 {
+        covered := false
+        encloser := zone
+        for _, n := range nsec {
+            if !nsecCovers(n, name) { continue }
+            covered = true
+            // the closest encloser is the longest ancestor shared with either end of the interval
+            for _, s := range []string{n.Hdr.Name, n.NextDomain} {
+                if c := dns.CompareDomainName(name, s); c > dns.CountLabel(encloser) {
+                    labels := dns.SplitDomainName(name)
+                    encloser = dns.Fqdn(strings.Join(labels[len(labels)-c:], "."))
+                }
+            }
+        }
+        if !covered { return false }
+        wildcard := "*." + encloser
+        for _, n := range nsec {
+            if nsecCovers(n, wildcard) { return true }
+        }
+        return false
+    }
+    // NSEC3 closest encloser proof (RFC 5155 section 8.4)
+    for next := name; next != zone && next != "."; next = parentOf(next) {
+        encloser := parentOf(next)
+        matched, nextCovered, wildCovered := false, false, false
+        for _, n := range nsec3 {
+            if n.Match(encloser) { matched = true }
+            if n.Cover(next) { nextCovered = true }
+            if n.Cover("*." + encloser) { wildCovered = true }
+        }
+        if matched { return nextCovered && wildCovered }
+    }
+    return false
+}
+
+// proveNoCloser checks that an answer expanded from the wildcard at the closest
+// encloser (the last labels labels of name) could not come from a closer name: an
+// NSEC covers name, or an NSEC3 covers the next closer name (RFC 4035 section 5.3.4,
+// RFC 5155 section 8.8).
+func proveNoCloser(nsec []*dns.NSEC, nsec3 []*dns.NSEC3, name string, labels int) bool {
+    for _, n := range nsec {
+        if nsecCovers(n, name) { return true }
+    }
+    l := dns.SplitDomainName(name)
+    if labels >= len(l) { return false }
+    nextCloser := dns.Fqdn(strings.Join(l[len(l)-labels-1:], "."))
+    for _, n := range nsec3 {
+        if n.Cover(nextCloser) { return true }
+    }
+    return false
+}
+
+// proveNoDS checks that a delegation to child is unsigned: the NSEC/NSEC3 for the
+// child shows no DS, or an opt-out NSEC3 covers it.
+func proveNoDS(nsec []*dns.NSEC, nsec3 []*dns.NSEC3, child string) bool {
+    for _, n := range nsec {
+        if strings.EqualFold(n.Hdr.Name, child) && !hasType(n.TypeBitMap, dns.TypeDS) && !hasType(n.TypeBitMap, dns.TypeSOA) { return true }
+    }
+    for _, n := range nsec3 {
+        if n.Match(child) && !hasType(n.TypeBitMap, dns.TypeDS) && !hasType(n.TypeBitMap, dns.TypeSOA) { return true }
+        if n.Flags&1 == 1 && n.Cover(child) { return true }
+    }
+    return false
+}
+
+// isZoneCut reports whether name is the apex of a delegated zone.
+func (v *Validator) isZoneCut(ctx context.Context, name string) (bool, error) {
+    resp, err := v.fetch(ctx, name, dns.TypeNS)
+    if err != nil { return false, err }
+    set, _ := rrset(resp.Answer, name, dns.TypeNS)
+    return len(set) > 0, nil
+}
+
+// maxCNAMEHops bounds the CNAME chain followed by Validate.
+const maxCNAMEHops = 8
+
+// Validate checks name/qtype from the trust anchor down. Failures to reach the
+// resolver yield Indeterminate; any broken link yields Bogus with its reason.
+// A CNAME is followed, and the answer is only as secure as its target.
+func (v *Validator) Validate(ctx context.Context, name string, qtype uint16) ValidationResult {
+    return v.validate(ctx, name, qtype, 0)
+}
+
+// checkAnswer verifies a positive answer; one expanded from a wildcard also needs the
+// proof that the name itself does not exist.
+func (v *Validator) checkAnswer(resp *dns.Msg, set []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY, zone, name string) (wildcard bool, err error) {
+    sig, err := v.verifySig(set, sigs, keys, zone)
+    if err != nil { return false, err }
+    if int(sig.Labels) >= dns.CountLabel(name) { return false, nil }
+    nsec, nsec3, err := v.signedDenial(resp, zone, keys)
+    if err != nil { return true, bogusf("wildcard answer for %s: %v", name, err) }
+    if !proveNoCloser(nsec, nsec3, name, int(sig.Labels)) { return true, bogusf("wildcard answer for %s without proof that no closer name exists", name) }
+    return true, nil
+}
+
+func (v *Validator) validate(ctx context.Context, name string, qtype uint16, hops int) ValidationResult {
+    name = dns.Fqdn(strings.ToLower(name))
+    res := ValidationResult{Name: name, Type: dns.TypeToString[qtype], Server: v.server, Chain: []ZoneStatus{}}
+    fail := func(err error) ValidationResult {
+        res.Status = Indeterminate
+        var b *bogusError
+        if errors.As(err, &b) { res.Status = Bogus }
+        res.Reason = err.Error()
+        if n := len(res.Chain); n > 0 && res.Chain[n-1].Status == "" {
+            res.Chain[n-1].Status, res.Chain[n-1].Reason = res.Status, res.Reason
+        }
+        return res
+    }
+
+    zone := "."
+    root := ZoneStatus{Zone: zone}
+    for _, ds := range v.anchors { root.DS = append(root.DS, dsString(ds)) }
+    res.Chain = append(res.Chain, root)
+    keys, err := v.zoneKeys(ctx, zone, supportedDS(v.anchors), &res.Chain[0])
+    if err != nil { return fail(err) }
+    res.Chain[0].Status = Secure
+
+    labels := dns.SplitDomainName(name)
+    for i := len(labels) - 1; i >= 0; i-- {
+        child := dns.Fqdn(strings.Join(labels[i:], "."))
+        resp, err := v.fetch(ctx, child, dns.TypeDS)
+        if err != nil { return fail(err) }
+        set, sigs := rrset(resp.Answer, child, dns.TypeDS)
+        if len(set) == 0 {
+            if resp.Rcode == dns.RcodeNameError { break }
+            cut, err := v.isZoneCut(ctx, child)
+            if err != nil { return fail(err) }
+            if !cut { continue }
+            res.Chain = append(res.Chain, ZoneStatus{Zone: child})
+            nsec, nsec3, err := v.signedDenial(resp, zone, keys)
+            if err != nil { return fail(bogusf("missing DS for %s: %v", child, err)) }
+            if !proveNoDS(nsec, nsec3, child) { return fail(bogusf("missing DS for %s without proof of an unsigned delegation", child)) }
+            res.Status, res.Reason = Insecure, "unsigned delegation at "+child
+            res.Chain[len(res.Chain)-1].Status, res.Chain[len(res.Chain)-1].Reason = Insecure, "no DS in "+zone
+            return res
+        }
+        step := ZoneStatus{Zone: child}
+        dss := make([]*dns.DS, 0, len(set))
+        for _, rr := range set {
+            dss = append(dss, rr.(*dns.DS))
+            step.DS = append(step.DS, dsString(rr.(*dns.DS)))
+        }
+        res.Chain = append(res.Chain, step)
+        if err := v.verify(set, sigs, keys, zone); err != nil { return fail(bogusf("DS for %s: %v", child, err)) }
+        usable := supportedDS(dss)
+        if len(usable) == 0 {
+            res.Status, res.Reason = Insecure, "unsupported DNSSEC algorithm or digest at "+child
+            res.Chain[len(res.Chain)-1].Status = Insecure
+            return res
+        }
+        keys, err = v.zoneKeys(ctx, child, usable, &res.Chain[len(res.Chain)-1])
+        if err != nil { return fail(err) }
+        res.Chain[len(res.Chain)-1].Status = Secure
+        zone = child
+    }
+
+    resp, err := v.fetch(ctx, name, qtype)
+    if err != nil { return fail(err) }
+    set, sigs := rrset(resp.Answer, name, qtype)
+    if len(set) == 0 && qtype != dns.TypeCNAME {
+        if cname, csigs := rrset(resp.Answer, name, dns.TypeCNAME); len(cname) > 0 {
+            if _, err := v.checkAnswer(resp, cname, csigs, keys, zone, name); err != nil { return fail(err) }
+            target := strings.ToLower(cname[0].(*dns.CNAME).Target)
+            res.Reason = "CNAME to " + target
+            if hops >= maxCNAMEHops {
+                res.Status, res.Reason = Indeterminate, res.Reason+": CNAME chain too long"
+                return res
+            }
+            t := v.validate(ctx, target, qtype, hops+1)
+            res.Status = t.Status
+            if t.Reason != "" { res.Reason += ": " + t.Reason }
+            return res
+        }
+    }
+    if len(set) > 0 {
+        wildcard, err := v.checkAnswer(resp, set, sigs, keys, zone, name)
+        if err != nil { return fail(err) }
+        res.Status = Secure
+        if wildcard { res.Reason = "wildcard expansion, no closer name exists" }
+        return res
+    }
+    nsec, nsec3, err := v.signedDenial(resp, zone, keys)
+    if err != nil { return fail(bogusf("negative answer for %s %s: %v", name, res.Type, err)) }
+    if resp.Rcode == dns.RcodeNameError {
+        if !proveNXDomain(nsec, nsec3, name, zone) { return fail(bogusf("NXDOMAIN for %s is not proven by NSEC/NSEC3", name)) }
+        res.Status, res.Reason = Secure, "authenticated denial of existence (NXDOMAIN)"
+        return res
+    }
+    if !proveNoData(nsec, nsec3, name, qtype) { return fail(bogusf("NODATA for %s %s is not proven by NSEC/NSEC3", name, res.Type)) }
+    res.Status, res.Reason = Secure, "authenticated denial of existence (NODATA)"
+    return res
+}
@@ -0,0 +1,276 @@
+package dnsclient
+
+import (
+    "context"
+    "crypto"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/miekg/dns"
+)
+
+// testZone is a zone signed locally with one ECDSA key.
+type testZone struct {
+    apex  string
+    key   *dns.DNSKEY
+    priv  crypto.Signer
+    nsec3 bool
+    rrs   []dns.RR
+    // denial holds the NSEC/NSEC3 chain and its signatures once signed
+    denial []dns.RR
+}
+
+func newTestZone(t *testing.T, apex string, nsec3 bool) *testZone {
+    t.Helper()
+    key := &dns.DNSKEY{Hdr: dns.RR_Header{Name: apex, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600}, Flags: 257, Protocol: 3, Algorithm: dns.ECDSAP256SHA256}
+    priv, err := key.Generate(256)
+    if err != nil { t.Fatal(err) }
+    z := &testZone{apex: apex, key: key, priv: priv.(crypto.Signer), nsec3: nsec3}
+    z.rrs = append(z.rrs, key)
+    ns, admin := dns.Fqdn("ns."+strings.TrimSuffix(apex, ".")), dns.Fqdn("admin."+strings.TrimSuffix(apex, "."))
+    z.add(t, apex+" 3600 IN SOA "+ns+" "+admin+" 1 7200 3600 1209600 300", apex+" 3600 IN NS "+ns)
+    return z
+}
+
+func (z *testZone) add(t *testing.T, records ...string) {
+    t.Helper()
+    for _, r := range records {
+        rr, err := dns.NewRR(r)
+        if err != nil { t.Fatalf("%s: %v", r, err) }
+        z.rrs = append(z.rrs, rr)
+    }
+}
+
+// delegate adds the NS (and, for a signed child, DS) records of child.
+func (z *testZone) delegate(t *testing.T, child string, signed *testZone) {
+    z.add(t, child+" 3600 IN NS ns."+child)
+    if signed != nil {
+        ds := signed.key.ToDS(dns.SHA256)
+        ds.Hdr.Ttl = 3600
+        z.rrs = append(z.rrs, ds)
+    }
+}
+
+func (z *testZone) isDelegation(name string, rrtype uint16) bool {
+    return rrtype == dns.TypeNS && !strings.EqualFold(name, z.apex)
+}
+
+// sign builds the denial chain and signs every authoritative RRset with signatures
+// valid from inception to expiration.
+func (z *testZone) sign(t *testing.T, inception, expiration time.Time) {
+    t.Helper()
+    types := map[string][]uint16{}
+    for _, rr := range z.rrs {
+        h := rr.Header()
+        types[h.Name] = append(types[h.Name], h.Rrtype)
+        if z.nsec3 {
+            // empty non-terminals get an NSEC3 too
+            for p := parentOf(h.Name); dns.IsSubDomain(z.apex, p) && p != z.apex; p = parentOf(p) {
+                if _, ok := types[p]; !ok { types[p] = nil }
+            }
+        }
+    }
+    names := make([]string, 0, len(types))
+    for n := range types { names = append(names, n) }
+    bitmap := func(name string, extra ...uint16) []uint16 {
+        out := append([]uint16{}, extra...)
+        signed := false
+        for _, t := range types[name] {
+            out = append(out, t)
+            if !z.isDelegation(name, t) { signed = true }
+        }
+        if signed { out = append(out, dns.TypeRRSIG) }
+        sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+        return out
+    }
+    var chain []dns.RR
+    hdr := func(name string, rrtype uint16) dns.RR_Header { return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: 300} }
+    if z.nsec3 {
+        hashes := map[string]string{}
+        for _, n := range names { hashes[dns.HashName(n, dns.SHA1, 0, "")] = n }
+        sorted := make([]string, 0, len(hashes))
+        for h := range hashes { sorted = append(sorted, h) }
+        sort.Strings(sorted)
+        for i, h := range sorted {
+            chain = append(chain, &dns.NSEC3{
+                Hdr: hdr(dns.Fqdn(h+"."+strings.TrimSuffix(z.apex, ".")), dns.TypeNSEC3), Hash: dns.SHA1, HashLength: 20,
+                NextDomain: sorted[(i+1)%len(sorted)], TypeBitMap: bitmap(hashes[h]),
+            })
+        }
+    } else {
+        sort.Slice(names, func(i, j int) bool { return canonicalLess(names[i], names[j]) })
+        for i, n := range names {
+            chain = append(chain, &dns.NSEC{Hdr: hdr(n, dns.TypeNSEC), NextDomain: names[(i+1)%len(names)], TypeBitMap: bitmap(n, dns.TypeNSEC)})
+        }
+    }
+
+    sets := map[string][]dns.RR{}
+    var order []string
+    for _, rr := range append(z.rrs, chain...) {
+        h := rr.Header()
+        if z.isDelegation(h.Name, h.Rrtype) { continue }
+        k := strings.ToLower(h.Name) + "/" + dns.TypeToString[h.Rrtype]
+        if _, ok := sets[k]; !ok { order = append(order, k) }
+        sets[k] = append(sets[k], rr)
+    }
+    for _, k := range order {
+        set := sets[k]
+        sig := &dns.RRSIG{
+            Hdr:        dns.RR_Header{Name: set[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: set[0].Header().Ttl},
+            Inception:  uint32(inception.Unix()), Expiration: uint32(expiration.Unix()),
+            KeyTag:     z.key.KeyTag(), SignerName: z.apex, Algorithm: z.key.Algorithm,
+        }
+        if err := sig.Sign(z.priv, set); err != nil { t.Fatalf("sign %s: %v", k, err) }
+        if set[0].Header().Rrtype == dns.TypeNSEC || set[0].Header().Rrtype == dns.TypeNSEC3 {
+            z.denial = append(z.denial, set[0], sig)
+        } else {
+            z.rrs = append(z.rrs, sig)
+        }
+    }
+}
+
+// find returns the records of name with type qtype and their signatures.
+func (z *testZone) find(name string, qtype uint16) []dns.RR {
+    var out []dns.RR
+    for _, rr := range z.rrs {
+        h := rr.Header()
+        if !strings.EqualFold(h.Name, name) { continue }
+        if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == qtype || h.Rrtype == qtype { out = append(out, rr) }
+    }
+    return out
+}
+
+func (z *testZone) exists(name string) bool {
+    for _, rr := range z.rrs {
+        if n := rr.Header().Name; strings.EqualFold(n, name) || dns.IsSubDomain(name, n) { return true }
+    }
+    return false
+}
+
+// testResolver answers like a validating-capable recursive resolver with CD set:
+// records, signatures and denial proofs straight from the zones.
+type testResolver struct {
+    zones []*testZone
+    // wildcardProof adds the denial chain to answers synthesized from a wildcard
+    wildcardProof bool
+}
+
+func (r *testResolver) zoneFor(name string, ds bool) *testZone {
+    var best *testZone
+    for _, z := range r.zones {
+        if !dns.IsSubDomain(z.apex, name) || ds && strings.EqualFold(z.apex, name) { continue }
+        if best == nil || dns.CountLabel(z.apex) > dns.CountLabel(best.apex) { best = z }
+    }
+    return best
+}
+
+func (r *testResolver) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+    q := req.Question[0]
+    name := strings.ToLower(q.Name)
+    m := new(dns.Msg).SetReply(req)
+    z := r.zoneFor(name, q.Qtype == dns.TypeDS)
+    if rrs := z.find(name, q.Qtype); len(rrs) > 0 {
+        m.Answer = rrs
+    } else if rrs := z.find(name, dns.TypeCNAME); len(rrs) > 0 {
+        m.Answer = rrs
+    } else if z.exists(name) {
+        m.Ns = z.denial
+    } else if rrs := z.find("*."+parentOf(name), q.Qtype); len(rrs) > 0 {
+        for _, rr := range rrs {
+            rr = dns.Copy(rr)
+            rr.Header().Name = name
+            m.Answer = append(m.Answer, rr)
+        }
+        if r.wildcardProof { m.Ns = z.denial }
+    } else {
+        m.Rcode = dns.RcodeNameError
+        m.Ns = z.denial
+    }
+    _ = w.WriteMsg(m)
+}
+
+// serve runs r on a loopback UDP port and returns its address.
+func (r *testResolver) serve(t *testing.T) string { return serveDNS(t, r) }
+
+// testHierarchy signs root -> test. -> a few zones showing every validation outcome.
+func testHierarchy(t *testing.T) (zones []*testZone, anchor *dns.DS) {
+    now := time.Now()
+    valid := func(z *testZone) { z.sign(t, now.Add(-time.Hour), now.Add(24*time.Hour)) }
+
+    root := newTestZone(t, ".", false)
+    tld := newTestZone(t, "test.", false)
+    example := newTestZone(t, "example.test.", false)
+    example.add(t,
+        "www.example.test. 300 IN A 192.0.2.1",
+        "*.dyn.example.test. 300 IN A 192.0.2.9",
+        "alias.example.test. 300 IN CNAME www.example.test.",
+        "out.example.test. 300 IN CNAME host.plain.test.",
+    )
+    hashed := newTestZone(t, "hashed.test.", true)
+    hashed.add(t, "www.hashed.test. 300 IN A 192.0.2.2", "*.dyn.hashed.test. 300 IN A 192.0.2.3")
+    expired := newTestZone(t, "expired.test.", false)
+    expired.add(t, "www.expired.test. 300 IN A 192.0.2.4")
+    broken := newTestZone(t, "broken.test.", false)
+    broken.add(t, "www.broken.test. 300 IN A 192.0.2.5")
+
+    for _, z := range []*testZone{example, hashed, expired, broken} { tld.delegate(t, z.apex, z) }
+    tld.delegate(t, "plain.test.", nil)
+    for _, rr := range tld.rrs {
+        // a DS that matches no key of its zone
+        if ds, ok := rr.(*dns.DS); ok && ds.Hdr.Name == "broken.test." { ds.Digest = strings.Repeat("00", 32) }
+    }
+    root.delegate(t, "test.", tld)
+
+    for _, z := range []*testZone{example, hashed, broken, tld, root} { valid(z) }
+    expired.sign(t, now.Add(-48*time.Hour), now.Add(-24*time.Hour))
+    return []*testZone{root, tld, example, hashed, expired, broken}, root.key.ToDS(dns.SHA256)
+}
+
+func TestValidate(t *testing.T) {
+    zones, anchor := testHierarchy(t)
+    tests := []struct {
+        name          string
+        qtype         uint16
+        wildcardProof bool
+        status        string
+        reason        string
+    }{
+        {"www.example.test.", dns.TypeA, false, Secure, ""},
+        {"nope.example.test.", dns.TypeA, false, Secure, "NXDOMAIN"},
+        {"www.example.test.", dns.TypeMX, false, Secure, "NODATA"},
+        {"nope.hashed.test.", dns.TypeA, false, Secure, "NXDOMAIN"},
+        {"www.hashed.test.", dns.TypeMX, false, Secure, "NODATA"},
+        {"www.expired.test.", dns.TypeA, false, Bogus, "expired at"},
+        {"www.broken.test.", dns.TypeA, false, Bogus, "DS mismatch"},
+        {"host.plain.test.", dns.TypeA, false, Insecure, "unsigned delegation at plain.test."},
+        {"any.dyn.example.test.", dns.TypeA, true, Secure, "wildcard expansion"},
+        {"any.dyn.example.test.", dns.TypeA, false, Bogus, "wildcard answer for any.dyn.example.test."},
+        {"x.dyn.hashed.test.", dns.TypeA, true, Secure, "wildcard expansion"},
+        {"x.dyn.hashed.test.", dns.TypeA, false, Bogus, "wildcard answer for x.dyn.hashed.test."},
+        {"alias.example.test.", dns.TypeA, false, Secure, "CNAME to www.example.test."},
+        {"out.example.test.", dns.TypeA, false, Insecure, "CNAME to host.plain.test.: unsigned delegation at plain.test."},
+    }
+    for _, tt := range tests {
+        desc := tt.name + " " + dns.TypeToString[tt.qtype]
+        if tt.wildcardProof { desc += " with proof" }
+        t.Run(desc, func(t *testing.T) {
+            addr := (&testResolver{zones: zones, wildcardProof: tt.wildcardProof}).serve(t)
+            v := NewValidator(New(time.Second), addr, []*dns.DS{anchor})
+            r := v.Validate(context.Background(), tt.name, tt.qtype)
+            if r.Status != tt.status || !strings.Contains(r.Reason, tt.reason) {
+                t.Errorf("Validate = %s (%s), want %s (%s); chain %+v", r.Status, r.Reason, tt.status, tt.reason, r.Chain)
+            }
+        })
+    }
+}
+
+func TestValidateExpiredAnchorKey(t *testing.T) {
+    zones, anchor := testHierarchy(t)
+    addr := (&testResolver{zones: zones}).serve(t)
+    v := NewValidator(New(time.Second), addr, []*dns.DS{anchor})
+    v.Now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+    r := v.Validate(context.Background(), "www.example.test.", dns.TypeA)
+    if r.Status != Bogus || len(r.Chain) != 1 || r.Chain[0].Status != Bogus { t.Errorf("Validate = %s (%s), chain %+v", r.Status, r.Reason, r.Chain) }
+}
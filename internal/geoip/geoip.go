@@ -0,0 +1,106 @@
+package geoip
+
+import (
+    "net"
+    "strconv"
+    "strings"
+
+    "github.com/oschwald/maxminddb-golang"
+)
+
+// Info is the location and network owner of an IP. Field names follow the ipapi.co
+// layout the frontend already understands.
+type Info struct {
+    IP          string   `json:"ip"`
+    City        string   `json:"city,omitempty"`
+    Region      string   `json:"region,omitempty"`
+    CountryName string   `json:"country_name,omitempty"`
+    CountryCode string   `json:"country_code,omitempty"`
+    Latitude    *float64 `json:"latitude,omitempty"`
+    Longitude   *float64 `json:"longitude,omitempty"`
+    Timezone    string   `json:"timezone,omitempty"`
+    ASN         string   `json:"asn,omitempty"`
+    Org         string   `json:"org,omitempty"`
+}
+
+// record covers the fields of GeoLite2/GeoIP2 City, Country and ASN databases
+// (and the DB-IP equivalents, which share the layout).
+type record struct {
+    City struct {
+        Names map[string]string `maxminddb:"names"`
+    } `maxminddb:"city"`
+    Subdivisions []struct {
+        Names map[string]string `maxminddb:"names"`
+    } `maxminddb:"subdivisions"`
+    Country struct {
+        ISOCode string            `maxminddb:"iso_code"`
+        Names   map[string]string `maxminddb:"names"`
+    } `maxminddb:"country"`
+    Location struct {
+        Latitude  *float64 `maxminddb:"latitude"`
+        Longitude *float64 `maxminddb:"longitude"`
+        TimeZone  string   `maxminddb:"time_zone"`
+    } `maxminddb:"location"`
+    ASN   uint   `maxminddb:"autonomous_system_number"`
+    ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// DB answers lookups from one or more local .mmdb files, e.g. a City and an ASN database.
+type DB struct {
+    readers []*maxminddb.Reader
+}
+
+// Open loads every non-empty path. A nil DB is valid and returns no data.
+func Open(paths ...string) (*DB, error) {
+    db := &DB{}
+    for _, p := range paths {
+        p = strings.TrimSpace(p)
+        if p == "" { continue }
+        r, err := maxminddb.Open(p)
+        if err != nil {
+            _ = db.Close()
+            return nil, err
+        }
+        db.readers = append(db.readers, r)
+    }
+    return db, nil
+}
+
+func (d *DB) Close() error {
+    if d == nil { return nil }
+    for _, r := range d.readers { _ = r.Close() }
+    d.readers = nil
+    return nil
+}
+
+// Enabled reports whether at least one database is loaded.
+func (d *DB) Enabled() bool { return d != nil && len(d.readers) > 0 }
+
+// Lookup merges what every database knows about ip. It returns nil when nothing matched.
+func (d *DB) Lookup(ip net.IP) *Info {
+    if !d.Enabled() || ip == nil { return nil }
+    info := &Info{IP: ip.String()}
+    found := false
+    for _, r := range d.readers {
+        var rec record
+        _, ok, err := r.LookupNetwork(ip, &rec)
+        if err != nil || !ok { continue }
+        found = true
+        if info.City == "" { info.City = rec.City.Names["en"] }
+        if info.Region == "" && len(rec.Subdivisions) > 0 { info.Region = rec.Subdivisions[0].Names["en"] }
+        if info.CountryCode == "" { info.CountryCode = rec.Country.ISOCode }
+        if info.CountryName == "" { info.CountryName = rec.Country.Names["en"] }
+        if info.Latitude == nil { info.Latitude = rec.Location.Latitude }
+        if info.Longitude == nil { info.Longitude = rec.Location.Longitude }
+        if info.Timezone == "" { info.Timezone = rec.Location.TimeZone }
+        if info.ASN == "" && rec.ASN != 0 { info.ASN = "AS" + strconv.FormatUint(uint64(rec.ASN), 10) }
+        if info.Org == "" { info.Org = rec.ASOrg }
+    }
+    if !found { return nil }
+    return info
+}
+
+// LookupString is Lookup for textual addresses.
+func (d *DB) LookupString(ip string) *Info {
+    return d.Lookup(net.ParseIP(ip))
+}
@@ -0,0 +1,88 @@
+package geoip
+
+import (
+    "encoding/binary"
+    "math"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+// mmdb encodes a value in the MaxMind DB data format. Only the types the tests need are covered.
+func mmdb(v any) []byte {
+    switch v := v.(type) {
+    case string:
+        if len(v) >= 29 { return append([]byte{2<<5 | 29, byte(len(v) - 29)}, v...) } // longer sizes take an extra byte
+        return append([]byte{2<<5 | byte(len(v))}, v...)
+    case float64:
+        return binary.BigEndian.AppendUint64([]byte{3<<5 | 8}, math.Float64bits(v))
+    case uint16:
+        return binary.BigEndian.AppendUint16([]byte{5<<5 | 2}, v)
+    case uint32:
+        return binary.BigEndian.AppendUint32([]byte{6<<5 | 4}, v)
+    case []any:
+        // arrays are an extended type: 11 is stored as 11-7 in the next byte
+        b := []byte{byte(len(v)), 11 - 7}
+        for _, e := range v { b = append(b, mmdb(e)...) }
+        return b
+    case map[string]any:
+        b := []byte{7<<5 | byte(len(v))}
+        for k, e := range v { b = append(append(b, mmdb(k)...), mmdb(e)...) }
+        return b
+    }
+    panic("unsupported type")
+}
+
+// writeDB writes an IPv4 database with a single tree node: 0.0.0.0/1 maps to rec,
+// 128.0.0.0/1 has no data.
+func writeDB(t *testing.T, rec map[string]any) string {
+    const nodeCount = 1
+    // record values above the node count point into the data section, past the 16-byte separator
+    b := []byte{0, 0, nodeCount + 16, 0, 0, nodeCount}
+    b = append(b, make([]byte, 16)...)
+    b = append(b, mmdb(rec)...)
+    b = append(b, "\xab\xcd\xefMaxMind.com"...)
+    b = append(b, mmdb(map[string]any{"node_count": uint32(nodeCount), "record_size": uint16(24), "ip_version": uint16(4)})...)
+    path := filepath.Join(t.TempDir(), "test.mmdb")
+    if err := os.WriteFile(path, b, 0o644); err != nil { t.Fatal(err) }
+    return path
+}
+
+func en(name string) map[string]any { return map[string]any{"names": map[string]any{"en": name, "ru": "-"}} }
+
+func TestLookup(t *testing.T) {
+    city := writeDB(t, map[string]any{
+        "city":         en("Amsterdam"),
+        "subdivisions": []any{en("North Holland")},
+        "country":      map[string]any{"iso_code": "NL", "names": map[string]any{"en": "Netherlands"}},
+        "location":     map[string]any{"latitude": 52.37, "longitude": 4.89, "time_zone": "Europe/Amsterdam"},
+    })
+    asn := writeDB(t, map[string]any{"autonomous_system_number": uint32(210644), "autonomous_system_organization": "AEZA INTERNATIONAL LTD", "country": map[string]any{"iso_code": "DE"}})
+    db, err := Open(city, " ", asn)
+    if err != nil { t.Fatal(err) }
+    defer db.Close()
+    if !db.Enabled() { t.Fatal("db with two files is not enabled") }
+
+    lat, lon := 52.37, 4.89
+    want := &Info{
+        IP: "77.83.1.1", City: "Amsterdam", Region: "North Holland", CountryName: "Netherlands", CountryCode: "NL",
+        Latitude: &lat, Longitude: &lon, Timezone: "Europe/Amsterdam", ASN: "AS210644", Org: "AEZA INTERNATIONAL LTD",
+    }
+    if got := db.LookupString("77.83.1.1"); !reflect.DeepEqual(got, want) { t.Errorf("Lookup = %+v, want %+v", got, want) }
+    if got := db.LookupString("192.0.2.1"); got != nil { t.Errorf("Lookup of an address without data = %+v", got) }
+    if got := db.LookupString("not an ip"); got != nil { t.Errorf("Lookup of an invalid address = %+v", got) }
+}
+
+func TestDisabled(t *testing.T) {
+    empty, err := Open("", "  ")
+    if err != nil { t.Fatal(err) }
+    var nilDB *DB
+    for name, db := range map[string]*DB{"nil": nilDB, "no paths": empty} {
+        if db.Enabled() { t.Errorf("%s: Enabled", name) }
+        if got := db.LookupString("77.83.1.1"); got != nil { t.Errorf("%s: Lookup = %+v", name, got) }
+        if err := db.Close(); err != nil { t.Errorf("%s: Close = %v", name, err) }
+    }
+    if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil { t.Error("Open of a missing file succeeded") }
+    if _, err := Open(writeDB(t, map[string]any{}), filepath.Join(t.TempDir(), "missing.mmdb")); err == nil { t.Error("Open with one missing file succeeded") }
+}
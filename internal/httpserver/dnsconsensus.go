@@ -0,0 +1,175 @@
+package httpserver
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    "aeza/internal/dnsclient"
+    "aeza/internal/storage"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+)
+
+// dnsAgentAnswer is what one agent saw for one (type, resolver) pair.
+type dnsAgentAnswer struct {
+    AgentID      string     `json:"agent_id"`
+    Region       string     `json:"region"`
+    Rcode        string     `json:"rcode,omitempty"`
+    Error        string     `json:"error,omitempty"`
+    TTL          *uint32    `json:"ttl,omitempty"`
+    TTLRemaining *int64     `json:"ttl_remaining,omitempty"`
+    ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+    CheckedAt    time.Time  `json:"checked_at"`
+}
+
+// dnsVariant is a distinct answer set and the agents that observed it.
+type dnsVariant struct {
+    Answers  []string         `json:"answers"`
+    Agents   []dnsAgentAnswer `json:"agents"`
+    Majority bool             `json:"majority"`
+}
+
+type dnsConsensusGroup struct {
+    Name      string       `json:"name"`
+    Type      string       `json:"type"`
+    Resolver  string       `json:"resolver"`
+    Consensus bool         `json:"consensus"`
+    Variants  []dnsVariant `json:"variants"`
+    // Outliers are agents whose answers differ from the majority; empty when there is none.
+    Outliers  []string     `json:"outliers"`
+}
+
+type dnsConsensusResponse struct {
+    TaskID string              `json:"task_id"`
+    Target string              `json:"target"`
+    Agents int                 `json:"agents"`
+    Groups []dnsConsensusGroup `json:"groups"`
+}
+
+// dnsQueriesOf extracts per-query results from stored dns details. Results of agents
+// that predate per-resolver reporting are mapped from the flat per-type lists; those
+// carry no TTLs, which is reported through the second return value.
+func dnsQueriesOf(details any) ([]dnsclient.Result, bool) {
+    b, err := json.Marshal(details)
+    if err != nil { return nil, false }
+    var d struct { Queries []dnsclient.Result `json:"queries"` }
+    if err := json.Unmarshal(b, &d); err == nil && len(d.Queries) > 0 { return d.Queries, true }
+    var legacy map[string]any
+    if err := json.Unmarshal(b, &legacy); err != nil { return nil, false }
+    name, _ := legacy["name"].(string)
+    var out []dnsclient.Result
+    for _, t := range []string{"A", "AAAA", "MX", "NS", "TXT"} {
+        vals, ok := legacy[t].([]any)
+        if !ok { continue }
+        r := dnsclient.Result{Resolver: "system", Name: name, Type: t, Rcode: "NOERROR"}
+        for _, v := range vals {
+            if s, ok := v.(string); ok { r.Answers = append(r.Answers, dnsclient.Answer{Type: t, Data: s}) }
+        }
+        out = append(out, r)
+    }
+    return out, false
+}
+
+// answerSet is the comparable form of a query outcome: sorted "TYPE data" strings,
+// or the rcode/error when the query failed.
+func answerSet(r dnsclient.Result) []string {
+    if r.Error != "" { return []string{"error"} }
+    if r.Rcode != "" && r.Rcode != "NOERROR" { return []string{r.Rcode} }
+    out := make([]string, 0, len(r.Answers))
+    for _, a := range r.Answers { out = append(out, a.Type+" "+a.Data) }
+    sort.Strings(out)
+    return out
+}
+
+// buildDNSConsensus groups dns results by (name, type, resolver) and splits each group
+// into variants of identical answer sets. The largest variant is the majority only when
+// no other variant is as large: a tie has no majority and therefore no outliers.
+func buildDNSConsensus(results []storage.CheckResult, now time.Time) []dnsConsensusGroup {
+    type key struct{ name, typ, resolver string }
+    groups := map[key]map[string]*dnsVariant{}
+    var order []key
+    for _, res := range results {
+        if strings.ToLower(res.Method) != "dns" { continue }
+        queries, hasTTL := dnsQueriesOf(res.Details)
+        for _, q := range queries {
+            k := key{strings.TrimSuffix(strings.ToLower(q.Name), "."), q.Type, q.Resolver}
+            if groups[k] == nil {
+                groups[k] = map[string]*dnsVariant{}
+                order = append(order, k)
+            }
+            set := answerSet(q)
+            sig := strings.Join(set, "\n")
+            v := groups[k][sig]
+            if v == nil {
+                v = &dnsVariant{Answers: set}
+                groups[k][sig] = v
+            }
+            a := dnsAgentAnswer{AgentID: res.AgentID, Region: res.Region, Rcode: q.Rcode, Error: q.Error, CheckedAt: res.CheckedAt}
+            if hasTTL && len(q.Answers) > 0 {
+                ttl := q.Answers[0].TTL
+                for _, ans := range q.Answers { if ans.TTL < ttl { ttl = ans.TTL } }
+                exp := res.CheckedAt.Add(time.Duration(ttl) * time.Second)
+                remaining := int64(exp.Sub(now).Seconds())
+                if remaining < 0 { remaining = 0 }
+                a.TTL, a.ExpiresAt, a.TTLRemaining = &ttl, &exp, &remaining
+            }
+            v.Agents = append(v.Agents, a)
+        }
+    }
+
+    out := make([]dnsConsensusGroup, 0, len(order))
+    for _, k := range order {
+        g := dnsConsensusGroup{Name: k.name, Type: k.typ, Resolver: k.resolver, Outliers: []string{}}
+        for _, v := range groups[k] { g.Variants = append(g.Variants, *v) }
+        sort.Slice(g.Variants, func(i, j int) bool {
+            if len(g.Variants[i].Agents) != len(g.Variants[j].Agents) { return len(g.Variants[i].Agents) > len(g.Variants[j].Agents) }
+            return strings.Join(g.Variants[i].Answers, "\n") < strings.Join(g.Variants[j].Answers, "\n")
+        })
+        if len(g.Variants) == 1 || len(g.Variants[0].Agents) > len(g.Variants[1].Agents) {
+            g.Variants[0].Majority = true
+            for _, v := range g.Variants[1:] {
+                for _, a := range v.Agents { g.Outliers = append(g.Outliers, a.AgentID) }
+            }
+        }
+        g.Consensus = len(g.Variants) == 1
+        out = append(out, g)
+    }
+    sort.SliceStable(out, func(i, j int) bool {
+        if out[i].Name != out[j].Name { return out[i].Name < out[j].Name }
+        if out[i].Type != out[j].Type { return out[i].Type < out[j].Type }
+        return out[i].Resolver < out[j].Resolver
+    })
+    return out
+}
+
+func (s *Server) getDNSConsensus(c *gin.Context) {
+    id, err := uuid.Parse(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+        return
+    }
+    task, err := s.db.GetTask(c.Request.Context(), id)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+        return
+    }
+    results, err := s.db.ListResultsByTask(c.Request.Context(), id)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    agents := map[string]struct{}{}
+    for _, r := range results {
+        if strings.ToLower(r.Method) == "dns" { agents[r.AgentID] = struct{}{} }
+    }
+    c.JSON(http.StatusOK, dnsConsensusResponse{
+        TaskID: task.ID.String(),
+        Target: task.Target,
+        Agents: len(agents),
+        Groups: buildDNSConsensus(results, time.Now().UTC()),
+    })
+}
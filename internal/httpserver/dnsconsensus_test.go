@@ -0,0 +1,112 @@
+package httpserver
+
+import (
+    "reflect"
+    "testing"
+    "time"
+
+    "aeza/internal/dnsclient"
+    "aeza/internal/storage"
+)
+
+// dnsResult is a stored dns result with one A query per address list.
+func dnsResult(agent string, checkedAt time.Time, ttl uint32, addrs ...string) storage.CheckResult {
+    q := dnsclient.Result{Resolver: "system", Name: "example.com.", Type: "A", Rcode: "NOERROR"}
+    for _, a := range addrs { q.Answers = append(q.Answers, dnsclient.Answer{Name: "example.com.", Type: "A", TTL: ttl, Data: a}) }
+    return storage.CheckResult{AgentID: agent, Region: "eu", Method: "dns", CheckedAt: checkedAt, Details: map[string]any{"queries": []dnsclient.Result{q}}}
+}
+
+func TestBuildDNSConsensus(t *testing.T) {
+    now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name      string
+        results   []storage.CheckResult
+        consensus bool
+        majority  []bool
+        outliers  []string
+    }{
+        {
+            name: "agreement",
+            results: []storage.CheckResult{
+                dnsResult("a1", now, 300, "192.0.2.1", "192.0.2.2"),
+                dnsResult("a2", now, 300, "192.0.2.2", "192.0.2.1"),
+                dnsResult("a3", now, 300, "192.0.2.1", "192.0.2.2"),
+            },
+            consensus: true,
+            majority:  []bool{true},
+            outliers:  []string{},
+        },
+        {
+            name: "split",
+            results: []storage.CheckResult{
+                dnsResult("a1", now, 300, "192.0.2.1"),
+                dnsResult("a2", now, 300, "198.51.100.1"),
+                dnsResult("a3", now, 300, "192.0.2.1"),
+            },
+            majority: []bool{true, false},
+            outliers: []string{"a2"},
+        },
+        {
+            name: "tie",
+            results: []storage.CheckResult{
+                dnsResult("a1", now, 300, "198.51.100.1"),
+                dnsResult("a2", now, 300, "192.0.2.1"),
+            },
+            majority: []bool{false, false},
+            outliers: []string{},
+        },
+        {
+            name: "tie for first place",
+            results: []storage.CheckResult{
+                dnsResult("a1", now, 300, "192.0.2.1"),
+                dnsResult("a2", now, 300, "198.51.100.1"),
+                dnsResult("a3", now, 300, "192.0.2.1"),
+                dnsResult("a4", now, 300, "198.51.100.1"),
+                dnsResult("a5", now, 300, "203.0.113.1"),
+            },
+            majority: []bool{false, false, false},
+            outliers: []string{},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            groups := buildDNSConsensus(tt.results, now)
+            if len(groups) != 1 { t.Fatalf("got %d groups, want 1", len(groups)) }
+            g := groups[0]
+            var majority []bool
+            for _, v := range g.Variants { majority = append(majority, v.Majority) }
+            if g.Consensus != tt.consensus || !reflect.DeepEqual(majority, tt.majority) || !reflect.DeepEqual(g.Outliers, tt.outliers) {
+                t.Errorf("consensus = %v, majority = %v, outliers = %q; want %v, %v, %q", g.Consensus, majority, g.Outliers, tt.consensus, tt.majority, tt.outliers)
+            }
+        })
+    }
+}
+
+func TestBuildDNSConsensusTTLRemaining(t *testing.T) {
+    now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+    results := []storage.CheckResult{
+        dnsResult("fresh", now.Add(-100*time.Second), 300, "192.0.2.1"),
+        dnsResult("expired", now.Add(-time.Hour), 300, "192.0.2.1"),
+        // the lowest TTL of the set counts
+        func() storage.CheckResult {
+            r := dnsResult("mixed", now.Add(-10*time.Second), 300, "192.0.2.1")
+            q := r.Details.(map[string]any)["queries"].([]dnsclient.Result)
+            q[0].Answers = append(q[0].Answers, dnsclient.Answer{Type: "A", TTL: 60, Data: "192.0.2.1"})
+            return r
+        }(),
+        // legacy flat details carry no TTL
+        {AgentID: "legacy", Method: "dns", CheckedAt: now, Details: map[string]any{"name": "example.com.", "A": []any{"192.0.2.1"}}},
+    }
+    want := map[string]int64{"fresh": 200, "expired": 0, "mixed": 50, "legacy": -1}
+    for _, g := range buildDNSConsensus(results, now) {
+        for _, v := range g.Variants {
+            for _, a := range v.Agents {
+                got := int64(-1)
+                if a.TTLRemaining != nil { got = *a.TTLRemaining }
+                if got != want[a.AgentID] { t.Errorf("%s: ttl_remaining = %d, want %d", a.AgentID, got, want[a.AgentID]) }
+                delete(want, a.AgentID)
+            }
+        }
+    }
+    if len(want) != 0 { t.Errorf("agents missing from the groups: %v", want) }
+}
@@ -0,0 +1,181 @@
+package httpserver
+
+import (
+    "context"
+    "log"
+    "math"
+    "net/http"
+    "strings"
+    "time"
+
+    "aeza/internal/notify"
+    "aeza/internal/storage"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+)
+
+// expiryThresholds are the reminder steps in days before expiry.
+var expiryThresholds = []int{30, 14, 7, 1}
+
+// expiryMethods are the check methods whose results carry expiry dates.
+var expiryMethods = []string{"whois", "tls"}
+
+func daysUntil(t, now time.Time) int {
+    return int(math.Floor(t.Sub(now).Hours() / 24))
+}
+
+// dueThreshold returns the reminder step that days has crossed and that has not been
+// sent yet. Only the smallest crossed step fires, so a late first check sends one reminder.
+func dueThreshold(days int, notified *int) (int, bool) {
+    due := -1
+    for _, t := range expiryThresholds {
+        if days <= t && (due < 0 || t < due) { due = t }
+    }
+    if due < 0 { return 0, false }
+    if notified != nil && *notified <= due { return 0, false }
+    return due, true
+}
+
+// expiryFromResult pulls the expiry date out of whois ("expires") and tls ("not_after") details.
+func expiryFromResult(method string, details any) (kind, subject string, expires time.Time, ok bool) {
+    d, isMap := details.(map[string]any)
+    if !isMap { return "", "", time.Time{}, false }
+    var raw string
+    switch strings.ToLower(method) {
+    case "whois":
+        kind, raw = storage.ExpiryDomain, asString(d["expires"])
+        subject = asString(d["query"])
+    case "tls":
+        kind, raw = storage.ExpiryCertificate, asString(d["not_after"])
+        subject = asString(d["server_name"])
+    default:
+        return "", "", time.Time{}, false
+    }
+    if raw == "" { return "", "", time.Time{}, false }
+    t, err := time.Parse(time.RFC3339Nano, raw)
+    if err != nil { return "", "", time.Time{}, false }
+    return kind, subject, t.UTC(), true
+}
+
+func asString(v any) string {
+    s, _ := v.(string)
+    return s
+}
+
+// recordExpiry stores the expiry date of a whois/tls result when its task targets a tracked target.
+func (s *Server) recordExpiry(ctx context.Context, res *storage.CheckResult) {
+    kind, subject, expires, ok := expiryFromResult(res.Method, res.Details)
+    if !ok { return }
+    task, err := s.db.GetTask(ctx, res.TaskID)
+    if err != nil { return }
+    target, err := s.db.FindTrackedTarget(ctx, strings.TrimSpace(task.Target))
+    if err != nil { return }
+    taskID := res.TaskID
+    if err := s.db.UpsertExpiration(ctx, &storage.Expiration{
+        TargetID:     target.ID,
+        Kind:         kind,
+        Subject:      subject,
+        ExpiresAt:    expires,
+        SourceTaskID: &taskID,
+        SourceAgent:  res.AgentID,
+        CheckedAt:    res.CheckedAt,
+    }); err != nil {
+        log.Printf("expiry upsert for %s: %v", target.Target, err)
+    }
+}
+
+// sendExpiryReminders notifies about every expiration that crossed a new threshold.
+func (s *Server) sendExpiryReminders(ctx context.Context) {
+    list, err := s.db.ListExpirations(ctx)
+    if err != nil {
+        log.Printf("list expirations: %v", err)
+        return
+    }
+    now := time.Now().UTC()
+    for _, e := range list {
+        days := daysUntil(e.ExpiresAt, now)
+        threshold, due := dueThreshold(days, e.NotifiedThreshold)
+        if !due { continue }
+        err := s.notifier.NotifyExpiry(ctx, notify.Expiry{
+            Target: e.Target, Kind: e.Kind, Subject: e.Subject, ExpiresAt: e.ExpiresAt, DaysLeft: days, Threshold: threshold,
+        })
+        if err != nil {
+            // not marked, so the reminder is retried on the next round
+            log.Printf("expiry reminder for %s: %v", e.Target, err)
+            continue
+        }
+        _ = s.db.MarkExpirationNotified(ctx, e, threshold)
+    }
+}
+
+// runExpiryTracker periodically re-checks tracked targets and sends due reminders.
+func (s *Server) runExpiryTracker() {
+    t := time.NewTicker(10 * time.Minute)
+    defer t.Stop()
+    for {
+        ctx := context.Background()
+        interval := time.Duration(s.cfg.ExpiryCheckHours) * time.Hour
+        if targets, err := s.db.ListTrackedTargets(ctx); err == nil {
+            for _, tt := range targets {
+                if tt.LastCheckedAt != nil && time.Since(*tt.LastCheckedAt) < interval { continue }
+                if _, err := s.enqueueCheck(ctx, tt.Target, expiryMethods, nil, nil); err != nil {
+                    log.Printf("expiry check for %s: %v", tt.Target, err)
+                    continue
+                }
+                _ = s.db.TouchTrackedTarget(ctx, tt.ID, time.Now().UTC())
+            }
+        }
+        s.sendExpiryReminders(ctx)
+        <-t.C
+    }
+}
+
+type expirationView struct {
+    storage.Expiration
+    DaysRemaining int `json:"days_remaining"`
+}
+
+// getExpirations lists known domain and certificate expiry dates, soonest first.
+func (s *Server) getExpirations(c *gin.Context) {
+    list, err := s.db.ListExpirations(c.Request.Context())
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    now := time.Now().UTC()
+    out := make([]expirationView, 0, len(list))
+    for _, e := range list { out = append(out, expirationView{Expiration: e, DaysRemaining: daysUntil(e.ExpiresAt, now)}) }
+    c.JSON(http.StatusOK, out)
+}
+
+func (s *Server) adminListTrackedTargets(c *gin.Context) {
+    list, err := s.db.ListTrackedTargets(c.Request.Context())
+    if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+    if list == nil { list = []storage.TrackedTarget{} }
+    c.JSON(http.StatusOK, list)
+}
+
+func (s *Server) adminAddTrackedTarget(c *gin.Context) {
+    var req struct {
+        Target string `json:"target" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+    t := &storage.TrackedTarget{Target: strings.ToLower(strings.TrimSpace(req.Target))}
+    if t.Target == "" { c.JSON(http.StatusBadRequest, gin.H{"error": "empty target"}); return }
+    if err := s.db.AddTrackedTarget(c.Request.Context(), t); err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+    // first dates arrive with the results of this check
+    if _, err := s.enqueueCheck(c.Request.Context(), t.Target, expiryMethods, nil, nil); err == nil {
+        now := time.Now().UTC()
+        _ = s.db.TouchTrackedTarget(c.Request.Context(), t.ID, now)
+        t.LastCheckedAt = &now
+    }
+    c.JSON(http.StatusCreated, t)
+}
+
+func (s *Server) adminDeleteTrackedTarget(c *gin.Context) {
+    id, err := uuid.Parse(c.Param("id"))
+    if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+    if err := s.db.DeleteTrackedTarget(c.Request.Context(), id); err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+    c.Status(http.StatusNoContent)
+}
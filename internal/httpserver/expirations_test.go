@@ -0,0 +1,97 @@
+package httpserver
+
+import (
+    "reflect"
+    "testing"
+    "time"
+
+    "aeza/internal/storage"
+)
+
+func intPtr(v int) *int { return &v }
+
+func TestDueThreshold(t *testing.T) {
+    tests := []struct {
+        name     string
+        days     int
+        notified *int
+        want     int
+        due      bool
+    }{
+        {"far away", 45, nil, 0, false},
+        {"first step", 30, nil, 30, true},
+        {"between steps", 20, intPtr(30), 0, false},
+        {"second step", 14, intPtr(30), 14, true},
+        {"third step", 7, intPtr(14), 7, true},
+        {"last step", 1, intPtr(7), 1, true},
+        {"expired", -3, intPtr(7), 1, true},
+        {"all sent", 0, intPtr(1), 0, false},
+        {"late first check sends only the smallest step", 5, nil, 7, true},
+        {"skipped steps are not sent afterwards", 12, intPtr(7), 0, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, due := dueThreshold(tt.days, tt.notified)
+            if got != tt.want || due != tt.due { t.Errorf("dueThreshold(%d) = %d, %v, want %d, %v", tt.days, got, due, tt.want, tt.due) }
+        })
+    }
+}
+
+// TestDueThresholdSchedule walks a certificate through two lifetimes. After the renewal
+// ListExpirations reports no notified threshold, since the new date is later than the one reminded about.
+func TestDueThresholdSchedule(t *testing.T) {
+    var notified *int
+    var sent []int
+    days := []int{40, 30, 29, 15, 14, 8, 7, 2, 1, 0}
+    step := func(d int) {
+        if th, due := dueThreshold(d, notified); due {
+            sent = append(sent, th)
+            notified = intPtr(th)
+        }
+    }
+    for _, d := range days { step(d) }
+    // renewed for another 90 days
+    notified = nil
+    for _, d := range append([]int{90, 31}, days...) { step(d) }
+    want := []int{30, 14, 7, 1, 30, 14, 7, 1}
+    if !reflect.DeepEqual(sent, want) { t.Errorf("sent %v, want %v", sent, want) }
+}
+
+func TestExpiryFromResult(t *testing.T) {
+    tests := []struct {
+        name    string
+        method  string
+        details any
+        kind    string
+        subject string
+        expires string
+        ok      bool
+    }{
+        {
+            name:    "whois",
+            method:  "whois",
+            details: map[string]any{"query": "example.com", "expires": "2025-08-13T04:00:00Z", "not_after": "2030-01-01T00:00:00Z"},
+            kind:    storage.ExpiryDomain, subject: "example.com", expires: "2025-08-13T04:00:00Z", ok: true,
+        },
+        {
+            name:    "tls",
+            method:  "TLS",
+            details: map[string]any{"server_name": "www.example.com", "not_after": "2025-01-15T23:59:59.5+03:00", "expires": "2030-01-01T00:00:00Z"},
+            kind:    storage.ExpiryCertificate, subject: "www.example.com", expires: "2025-01-15T20:59:59.5Z", ok: true,
+        },
+        {name: "whois without expiry", method: "whois", details: map[string]any{"query": "example.com", "not_after": "2030-01-01T00:00:00Z"}},
+        {name: "tls without not_after", method: "tls", details: map[string]any{"expires": "2030-01-01T00:00:00Z"}},
+        {name: "unparsable date", method: "whois", details: map[string]any{"expires": "13-Aug-2025"}},
+        {name: "other method", method: "http", details: map[string]any{"expires": "2030-01-01T00:00:00Z"}},
+        {name: "no details", method: "tls", details: nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            kind, subject, expires, ok := expiryFromResult(tt.method, tt.details)
+            if ok != tt.ok || kind != tt.kind || subject != tt.subject {
+                t.Fatalf("expiryFromResult = %q, %q, %v, %v; want %q, %q, %v", kind, subject, expires, ok, tt.kind, tt.subject, tt.ok)
+            }
+            if ok && expires.Format(time.RFC3339Nano) != tt.expires { t.Errorf("expires = %s, want %s", expires.Format(time.RFC3339Nano), tt.expires) }
+        })
+    }
+}
@@ -11,7 +11,9 @@ import (
     "log"
     "net"
 
+    "aeza/internal/checker"
     "aeza/internal/config"
+    "aeza/internal/notify"
     "aeza/internal/queue"
     "aeza/internal/storage"
 
@@ -27,12 +29,11 @@ type Server struct {
     rds  *queue.RedisClient
     gin  *gin.Engine
     hub  *wsHub
+    notifier notify.Notifier
 }
 
+// NewRouter wires the API handlers; the schema must already be in place (EnsureSchema).
 func NewRouter(cfg config.Config, db *storage.Postgres, rds *queue.RedisClient) *gin.Engine {
-    // ensure schema exists
-    _ = db.EnsureSchema()
-
     g := gin.New()
     g.Use(gin.Recovery())
     g.Use(cors.New(cors.Config{
@@ -44,7 +45,10 @@ func NewRouter(cfg config.Config, db *storage.Postgres, rds *queue.RedisClient)
         MaxAge:           12 * time.Hour,
     }))
 
-    s := &Server{cfg: cfg, db: db, rds: rds, gin: g}
+    s := &Server{cfg: cfg, db: db, rds: rds, gin: g, notifier: notify.Log{}}
+    if cfg.NotifyWebhookURL != "" {
+        s.notifier = notify.Multi{notify.Log{}, notify.Webhook{URL: cfg.NotifyWebhookURL}}
+    }
 
     g.GET("/healthz", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
 
@@ -52,11 +56,16 @@ func NewRouter(cfg config.Config, db *storage.Postgres, rds *queue.RedisClient)
     {
         api.POST("/check", s.postCheck)
         api.GET("/check/:id", s.getCheck)
+        api.POST("/check/:id/cancel", s.cancelCheck)
+        api.GET("/check/:id/dns-consensus", s.getDNSConsensus)
+        api.GET("/check/:id/ssh-hostkeys", s.getSSHHostKeys)
         api.POST("/results", s.postResults)
         api.GET("/ws", s.wsHandler)
         api.POST("/agent/heartbeat", s.postHeartbeat)
         api.POST("/agent/log", s.postAgentLog)
         api.GET("/agents", s.publicListAgents)
+        api.GET("/methods", s.listMethods)
+        api.GET("/expirations", s.getExpirations)
     }
 
     admin := g.Group("/api/admin", s.adminAuth)
@@ -67,8 +76,13 @@ func NewRouter(cfg config.Config, db *storage.Postgres, rds *queue.RedisClient)
         admin.DELETE("/agents/:id", s.adminDeleteAgent)
         admin.POST("/agents/:id/reset-token", s.adminResetAgentToken)
         admin.GET("/agents/:id/run-cmd", s.adminGetRunCommand)
+        admin.GET("/expirations/targets", s.adminListTrackedTargets)
+        admin.POST("/expirations/targets", s.adminAddTrackedTarget)
+        admin.DELETE("/expirations/targets/:id", s.adminDeleteTrackedTarget)
     }
 
+    go s.runExpiryTracker()
+
     // background janitor: close overdue tasks and synthesize missing results
     go func(){
         t := time.NewTicker(2 * time.Second)
@@ -89,7 +103,7 @@ func NewRouter(cfg config.Config, db *storage.Postgres, rds *queue.RedisClient)
                     for _, m := range task.Methods {
                         key := a.Name+"|"+strings.ToLower(m)
                         if _, ok := existing[key]; ok { continue }
-                        // synthesize firewall-like error
+                        // synthesize firewall-like error; a result that arrives meanwhile wins
                         _ = db.InsertResult(context.Background(), &storage.CheckResult{
                             TaskID: task.ID,
                             AgentID: a.Name,
@@ -103,7 +117,10 @@ func NewRouter(cfg config.Config, db *storage.Postgres, rds *queue.RedisClient)
                         })
                     }
                 }
+                _, _, _ = db.RecountReceived(context.Background(), task.ID)
                 _ = db.UpdateTaskStatus(context.Background(), task.ID, storage.TaskStatusFinished)
+                // agents still busy with the task stop wasting time on it
+                _ = rds.PublishCancel(context.Background(), task.ID)
             }
         }
     }()
@@ -157,6 +174,10 @@ func externalRedisAddr(publicBase string, redisPort string) string {
 type postCheckRequest struct {
     Target  string   `json:"target" binding:"required"`
     Methods []string `json:"methods" binding:"required,min=1"`
+    // Options are per-method parameters, e.g. {"http": {"method": "HEAD", "expected_status": ["200"]}}
+    Options map[string]json.RawMessage `json:"options"`
+    // TimeoutsMs caps single methods in milliseconds, e.g. {"http": 30000}; the task TTL caps them all.
+    TimeoutsMs map[string]int `json:"timeouts_ms"`
 }
 
 type postCheckResponse struct {
@@ -171,12 +192,11 @@ func (s *Server) postCheck(c *gin.Context) {
         return
     }
 
-    // normalize methods to lower-case, basic allow-list
-    allowed := map[string]struct{}{ "http":{}, "dns":{}, "tcp":{}, "icmp":{}, "udp":{}, "whois":{}, "traceroute":{} }
+    // normalize methods to lower-case; only methods the agents know are accepted
     methods := make([]string, 0, len(req.Methods))
     for _, m := range req.Methods {
         lm := strings.ToLower(strings.TrimSpace(m))
-        if _, ok := allowed[lm]; ok {
+        if _, ok := checker.Lookup(lm); ok {
             methods = append(methods, lm)
         }
     }
@@ -185,41 +205,109 @@ func (s *Server) postCheck(c *gin.Context) {
         c.JSON(http.StatusBadRequest, gin.H{"error": "no valid methods"})
         return
     }
+    // keep options and timeouts only for requested methods
+    var options map[string]json.RawMessage
+    var timeouts map[string]int
+    for _, m := range methods {
+        if raw, ok := req.Options[m]; ok {
+            if options == nil { options = map[string]json.RawMessage{} }
+            options[m] = raw
+        }
+        if ms := req.TimeoutsMs[m]; ms > 0 {
+            if timeouts == nil { timeouts = map[string]int{} }
+            timeouts[m] = ms
+        }
+    }
+
+    task, err := s.enqueueCheck(c.Request.Context(), req.Target, methods, options, timeouts)
+    if err != nil {
+        log.Printf("InsertTask error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
 
+    c.JSON(http.StatusAccepted, postCheckResponse{TaskID: task.ID.String()})
+}
+
+// enqueueCheck stores a task and fans it out to every active agent.
+func (s *Server) enqueueCheck(ctx context.Context, target string, methods []string, options map[string]json.RawMessage, timeouts map[string]int) (*storage.CheckTask, error) {
     // expected results = active agents * methods
     numAgents := s.cfg.AgentsCount
-    if n, err := s.db.CountActiveAgents(c.Request.Context()); err == nil && n > 0 { numAgents = n }
+    if n, err := s.db.CountActiveAgents(ctx); err == nil && n > 0 { numAgents = n }
     expected := numAgents * len(methods)
     deadline := time.Now().UTC().Add(time.Duration(s.cfg.TaskTTLSeconds) * time.Second)
 
-    task := &storage.CheckTask{Target: req.Target, Methods: methods, ExpectedResults: expected, Deadline: &deadline}
-    if err := s.db.InsertTask(c.Request.Context(), task); err != nil {
-        log.Printf("InsertTask error: %v", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-        return
-    }
+    task := &storage.CheckTask{Target: target, Methods: methods, ExpectedResults: expected, Deadline: &deadline}
+    if err := s.db.InsertTask(ctx, task); err != nil { return nil, err }
 
-    _ = s.db.UpdateTaskStatus(c.Request.Context(), task.ID, storage.TaskStatusQueued)
+    _ = s.db.UpdateTaskStatus(ctx, task.ID, storage.TaskStatusQueued)
 
     // Fan-out per active agent so каждый агент выполнит все методы
-    as, _ := s.db.ListAgents(c.Request.Context())
+    as, _ := s.db.ListAgents(ctx)
     agentIDs := make([]string, 0, len(as)*2)
     for _, a := range as {
         if a.Revoked { continue }
         if a.Name != "" { agentIDs = append(agentIDs, a.Name) }
         if a.Token != "" { agentIDs = append(agentIDs, a.Token) }
     }
-    _ = s.rds.FanOutTask(c.Request.Context(), agentIDs, queue.TaskJob{
+    _ = s.rds.FanOutTask(ctx, agentIDs, queue.TaskJob{
         TaskID:      task.ID,
         Target:      task.Target,
         Methods:     task.Methods,
+        Options:     options,
         RequestedAt: time.Now().UTC(),
+        Deadline:    task.Deadline,
+        Timeouts:    timeouts,
     })
 
     // сразу ставим статус running после помещения в очередь
-    _ = s.db.UpdateTaskStatus(c.Request.Context(), task.ID, storage.TaskStatusRunning)
+    _ = s.db.UpdateTaskStatus(ctx, task.ID, storage.TaskStatusRunning)
+    return task, nil
+}
 
-    c.JSON(http.StatusAccepted, postCheckResponse{TaskID: task.ID.String()})
+type methodInfo struct {
+    Name   string          `json:"name"`
+    Params []checker.Param `json:"params"`
+}
+
+// listMethods describes the check methods and their options.
+func (s *Server) listMethods(c *gin.Context) {
+    all := checker.All()
+    out := make([]methodInfo, 0, len(all))
+    for _, m := range all { out = append(out, methodInfo{Name: m.Name(), Params: m.Params()}) }
+    c.JSON(http.StatusOK, out)
+}
+
+// cancelCheck closes a queued or running task and tells the agents to drop its jobs.
+// Results that arrive afterwards are refused.
+func (s *Server) cancelCheck(c *gin.Context) {
+    id, err := uuid.Parse(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+        return
+    }
+    task, err := s.db.GetTask(c.Request.Context(), id)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+        return
+    }
+    cancelled, err := s.db.CancelTask(c.Request.Context(), id)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    if !cancelled {
+        c.JSON(http.StatusConflict, gin.H{"error": "task already " + string(task.Status)})
+        return
+    }
+    if err := s.rds.PublishCancel(c.Request.Context(), id); err != nil {
+        log.Printf("publish cancel %s: %v", id, err)
+    }
+    if s.hub != nil {
+        evt := map[string]any{"type": "status", "task_id": id.String(), "status": storage.TaskStatusCancelled}
+        if b, err := json.Marshal(evt); err == nil { s.hub.broadcast(b) }
+    }
+    c.JSON(http.StatusOK, gin.H{"task_id": id.String(), "status": storage.TaskStatusCancelled})
 }
 
 type getCheckResponse struct {
@@ -295,6 +383,10 @@ func (s *Server) postResults(c *gin.Context) {
         c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task_id"})
         return
     }
+    if t, err := s.db.GetTask(c.Request.Context(), taskID); err == nil && t.Status == storage.TaskStatusCancelled {
+        c.JSON(http.StatusConflict, gin.H{"error": "task cancelled"})
+        return
+    }
 
     checkedAt := time.Now().UTC()
     if req.CheckedAt != "" {
@@ -307,7 +399,7 @@ func (s *Server) postResults(c *gin.Context) {
         TaskID:     taskID,
         AgentID:    req.AgentID,
         Region:     req.Region,
-        Method:     req.Method,
+        Method:     strings.ToLower(req.Method),
         Success:    req.Success,
         LatencyMs:  req.LatencyMs,
         StatusCode: req.StatusCode,
@@ -315,13 +407,15 @@ func (s *Server) postResults(c *gin.Context) {
         CheckedAt:  checkedAt,
         Details:    req.Details,
     }
-    if err := s.db.InsertResult(c.Request.Context(), res); err != nil {
+    // a retried submission replaces the earlier row instead of adding one
+    if err := s.db.UpsertResult(c.Request.Context(), res); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
+    s.recordExpiry(c.Request.Context(), res)
 
-    // Progress aggregation
-    exp, rec, err := s.db.IncrementReceived(c.Request.Context(), taskID)
+    // Progress aggregation over distinct (agent, method) rows
+    exp, rec, err := s.db.RecountReceived(c.Request.Context(), taskID)
     if err == nil {
         if rec >= exp {
             _ = s.db.UpdateTaskStatus(c.Request.Context(), taskID, storage.TaskStatusFinished)
@@ -485,6 +579,7 @@ func (s *Server) adminCreateAgent(c *gin.Context) {
             "-e", "REGION="+a.Region,
             "-e", "AGENT_ID="+a.Name,
             "-e", "AGENT_TOKEN="+a.Token,
+            "-v", name+"-spool:/var/lib/agent",
             s.cfg.AgentImage,
         ).Run()
     }(a.Name)
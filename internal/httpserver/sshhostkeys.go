@@ -0,0 +1,115 @@
+package httpserver
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strings"
+
+    "aeza/internal/storage"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+)
+
+type sshKeyAgent struct {
+    AgentID    string `json:"agent_id"`
+    Region     string `json:"region"`
+    RemoteAddr string `json:"remote_addr,omitempty"`
+}
+
+// sshKeyVariant is one host key fingerprint and the agents that were shown it.
+type sshKeyVariant struct {
+    Fingerprint string        `json:"fingerprint"`
+    Agents      []sshKeyAgent `json:"agents"`
+    Majority    bool          `json:"majority"`
+}
+
+type sshKeyGroup struct {
+    Type       string          `json:"type"`
+    Consistent bool            `json:"consistent"`
+    Variants   []sshKeyVariant `json:"variants"`
+    // Outliers saw a different key than the majority: a man in the middle or a
+    // host that is not the same machine everywhere.
+    Outliers []string `json:"outliers"`
+}
+
+type sshHostKeysResponse struct {
+    TaskID     string        `json:"task_id"`
+    Target     string        `json:"target"`
+    Agents     int           `json:"agents"`
+    Consistent bool          `json:"consistent"`
+    Groups     []sshKeyGroup `json:"groups"`
+}
+
+// buildSSHHostKeys compares the host keys reported by ssh results, per key type.
+func buildSSHHostKeys(results []storage.CheckResult) (groups []sshKeyGroup, agents int) {
+    byType := map[string]map[string]*sshKeyVariant{}
+    for _, res := range results {
+        if strings.ToLower(res.Method) != "ssh" { continue }
+        b, err := json.Marshal(res.Details)
+        if err != nil { continue }
+        var d struct {
+            RemoteAddr string `json:"remote_addr"`
+            HostKey    struct {
+                Type        string `json:"type"`
+                Fingerprint string `json:"fingerprint_sha256"`
+            } `json:"host_key"`
+        }
+        if json.Unmarshal(b, &d) != nil || d.HostKey.Fingerprint == "" { continue }
+        agents++
+        if byType[d.HostKey.Type] == nil { byType[d.HostKey.Type] = map[string]*sshKeyVariant{} }
+        v := byType[d.HostKey.Type][d.HostKey.Fingerprint]
+        if v == nil {
+            v = &sshKeyVariant{Fingerprint: d.HostKey.Fingerprint}
+            byType[d.HostKey.Type][d.HostKey.Fingerprint] = v
+        }
+        v.Agents = append(v.Agents, sshKeyAgent{AgentID: res.AgentID, Region: res.Region, RemoteAddr: d.RemoteAddr})
+    }
+
+    groups = make([]sshKeyGroup, 0, len(byType))
+    for typ, variants := range byType {
+        g := sshKeyGroup{Type: typ, Outliers: []string{}}
+        for _, v := range variants { g.Variants = append(g.Variants, *v) }
+        sort.Slice(g.Variants, func(i, j int) bool {
+            if len(g.Variants[i].Agents) != len(g.Variants[j].Agents) { return len(g.Variants[i].Agents) > len(g.Variants[j].Agents) }
+            return g.Variants[i].Fingerprint < g.Variants[j].Fingerprint
+        })
+        g.Variants[0].Majority = true
+        for _, v := range g.Variants[1:] {
+            for _, a := range v.Agents { g.Outliers = append(g.Outliers, a.AgentID) }
+        }
+        g.Consistent = len(g.Variants) == 1
+        groups = append(groups, g)
+    }
+    sort.Slice(groups, func(i, j int) bool { return groups[i].Type < groups[j].Type })
+    return groups, agents
+}
+
+func (s *Server) getSSHHostKeys(c *gin.Context) {
+    id, err := uuid.Parse(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+        return
+    }
+    task, err := s.db.GetTask(c.Request.Context(), id)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+        return
+    }
+    results, err := s.db.ListResultsByTask(c.Request.Context(), id)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    groups, agents := buildSSHHostKeys(results)
+    consistent := true
+    for _, g := range groups { consistent = consistent && g.Consistent }
+    c.JSON(http.StatusOK, sshHostKeysResponse{
+        TaskID:     task.ID.String(),
+        Target:     task.Target,
+        Agents:     agents,
+        Consistent: consistent,
+        Groups:     groups,
+    })
+}
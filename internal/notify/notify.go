@@ -0,0 +1,78 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "time"
+)
+
+// Expiry is a reminder that a domain or certificate is about to expire.
+type Expiry struct {
+    Target    string    `json:"target"`
+    Kind      string    `json:"kind"`
+    Subject   string    `json:"subject"`
+    ExpiresAt time.Time `json:"expires_at"`
+    DaysLeft  int       `json:"days_left"`
+    // Threshold is the reminder step (30, 14, 7 or 1 days) that was crossed.
+    Threshold int `json:"threshold"`
+}
+
+func (e Expiry) String() string {
+    if e.DaysLeft < 0 {
+        return fmt.Sprintf("%s %s (%s) expired %d days ago, on %s", e.Kind, e.Subject, e.Target, -e.DaysLeft, e.ExpiresAt.Format("2006-01-02"))
+    }
+    return fmt.Sprintf("%s %s (%s) expires in %d days, on %s", e.Kind, e.Subject, e.Target, e.DaysLeft, e.ExpiresAt.Format("2006-01-02"))
+}
+
+// Notifier delivers expiry reminders somewhere people will see them.
+type Notifier interface {
+    NotifyExpiry(ctx context.Context, e Expiry) error
+}
+
+// Log writes reminders to the API log.
+type Log struct{}
+
+func (Log) NotifyExpiry(_ context.Context, e Expiry) error {
+    log.Printf("expiry reminder: %s", e)
+    return nil
+}
+
+// Webhook POSTs every reminder as JSON to URL, with a human-readable "text" field
+// so that Slack/Mattermost-style incoming webhooks can show it as is.
+type Webhook struct {
+    URL    string
+    Client *http.Client
+}
+
+func (w Webhook) NotifyExpiry(ctx context.Context, e Expiry) error {
+    body, err := json.Marshal(struct {
+        Expiry
+        Text string `json:"text"`
+    }{e, e.String()})
+    if err != nil { return err }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+    if err != nil { return err }
+    req.Header.Set("Content-Type", "application/json")
+    client := w.Client
+    if client == nil { client = &http.Client{Timeout: 10 * time.Second} }
+    resp, err := client.Do(req)
+    if err != nil { return err }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 300 { return fmt.Errorf("webhook returned %s", resp.Status) }
+    return nil
+}
+
+// Multi fans a reminder out to several notifiers and returns the first error.
+type Multi []Notifier
+
+func (m Multi) NotifyExpiry(ctx context.Context, e Expiry) error {
+    var first error
+    for _, n := range m {
+        if err := n.NotifyExpiry(ctx, e); err != nil && first == nil { first = err }
+    }
+    return first
+}
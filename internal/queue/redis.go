@@ -11,6 +11,10 @@ import (
 
 const defaultTaskQueueKey = "check_tasks"
 
+// CancelChannel is the pub/sub channel task cancellations are published on; the
+// message is the task ID.
+const CancelChannel = "check_cancel"
+
 func agentQueueKey(agentID string) string { return "check_tasks:" + agentID }
 
 type RedisClient struct {
@@ -31,7 +35,13 @@ type TaskJob struct {
     TaskID      uuid.UUID `json:"task_id"`
     Target      string    `json:"target"`
     Methods     []string  `json:"methods"`
+    // Options holds per-method parameters keyed by method name; each method decodes its own entry.
+    Options     map[string]json.RawMessage `json:"options,omitempty"`
     RequestedAt time.Time `json:"requested_at"`
+    // Deadline is when the task closes; work still running then is abandoned.
+    Deadline    *time.Time `json:"deadline,omitempty"`
+    // Timeouts caps single methods, in milliseconds, keyed by method name.
+    Timeouts    map[string]int `json:"timeouts_ms,omitempty"`
 }
 
 func (r *RedisClient) EnqueueTask(ctx context.Context, job TaskJob) error {
@@ -56,3 +66,8 @@ func (r *RedisClient) FanOutTask(ctx context.Context, agentIDs []string, job Tas
 
 
 
+
+// PublishCancel tells the agents to abandon the jobs of a task.
+func (r *RedisClient) PublishCancel(ctx context.Context, taskID uuid.UUID) error {
+    return r.client.Publish(ctx, CancelChannel, taskID.String()).Err()
+}
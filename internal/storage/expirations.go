@@ -0,0 +1,133 @@
+package storage
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// Expiration kinds.
+const (
+    ExpiryDomain      = "domain"
+    ExpiryCertificate = "certificate"
+)
+
+// TrackedTarget is a target whose domain and certificate expiry dates are followed.
+type TrackedTarget struct {
+    ID            uuid.UUID  `json:"id"`
+    Target        string     `json:"target"`
+    LastCheckedAt *time.Time `json:"last_checked_at"`
+    CreatedAt     time.Time  `json:"created_at"`
+}
+
+// Expiration is the latest known expiry date of one kind for a tracked target.
+type Expiration struct {
+    TargetID     uuid.UUID  `json:"target_id"`
+    Target       string     `json:"target"`
+    Kind         string     `json:"kind"`
+    Subject      string     `json:"subject"`
+    ExpiresAt    time.Time  `json:"expires_at"`
+    SourceTaskID *uuid.UUID `json:"source_task_id"`
+    SourceAgent  string     `json:"source_agent"`
+    CheckedAt    time.Time  `json:"checked_at"`
+    // NotifiedThreshold is the smallest reminder threshold (in days) already sent for
+    // ExpiresAt or a later date.
+    NotifiedThreshold *int `json:"notified_threshold"`
+}
+
+func (p *Postgres) AddTrackedTarget(ctx context.Context, t *TrackedTarget) error {
+    t.ID = uuid.New()
+    t.CreatedAt = time.Now().UTC()
+    row := p.pool.QueryRow(ctx, `
+        INSERT INTO tracked_targets (id, target, created_at) VALUES ($1,$2,$3)
+        ON CONFLICT (target) DO UPDATE SET target=EXCLUDED.target
+        RETURNING id, last_checked_at, created_at
+    `, t.ID, t.Target, t.CreatedAt)
+    return row.Scan(&t.ID, &t.LastCheckedAt, &t.CreatedAt)
+}
+
+func (p *Postgres) DeleteTrackedTarget(ctx context.Context, id uuid.UUID) error {
+    ct, err := p.pool.Exec(ctx, `DELETE FROM tracked_targets WHERE id=$1`, id)
+    if err != nil { return err }
+    if ct.RowsAffected() == 0 { return errors.New("target not found") }
+    return nil
+}
+
+func (p *Postgres) ListTrackedTargets(ctx context.Context) ([]TrackedTarget, error) {
+    rows, err := p.pool.Query(ctx, `SELECT id, target, last_checked_at, created_at FROM tracked_targets ORDER BY target ASC`)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []TrackedTarget
+    for rows.Next() {
+        var t TrackedTarget
+        if err := rows.Scan(&t.ID, &t.Target, &t.LastCheckedAt, &t.CreatedAt); err != nil { return nil, err }
+        out = append(out, t)
+    }
+    return out, rows.Err()
+}
+
+// FindTrackedTarget looks a target up by its (case-insensitive) text.
+func (p *Postgres) FindTrackedTarget(ctx context.Context, target string) (*TrackedTarget, error) {
+    row := p.pool.QueryRow(ctx, `SELECT id, target, last_checked_at, created_at FROM tracked_targets WHERE lower(target)=lower($1)`, target)
+    var t TrackedTarget
+    if err := row.Scan(&t.ID, &t.Target, &t.LastCheckedAt, &t.CreatedAt); err != nil { return nil, err }
+    return &t, nil
+}
+
+func (p *Postgres) TouchTrackedTarget(ctx context.Context, id uuid.UUID, at time.Time) error {
+    _, err := p.pool.Exec(ctx, `UPDATE tracked_targets SET last_checked_at=$2 WHERE id=$1`, id, at)
+    return err
+}
+
+// UpsertExpiration stores the expiry date of the latest task. Agents may see different
+// certificates (load balancers, anycast), so within one task the earliest date wins.
+// Reminders are not reset here: they stand until the date moves past the one they were
+// sent for (see ListExpirations).
+func (p *Postgres) UpsertExpiration(ctx context.Context, e *Expiration) error {
+    _, err := p.pool.Exec(ctx, `
+        INSERT INTO expirations (target_id, kind, subject, expires_at, source_task_id, source_agent, checked_at)
+        VALUES ($1,$2,$3,$4,$5,$6,$7)
+        ON CONFLICT (target_id, kind) DO UPDATE SET
+            subject=EXCLUDED.subject,
+            expires_at=EXCLUDED.expires_at,
+            source_task_id=EXCLUDED.source_task_id,
+            source_agent=EXCLUDED.source_agent,
+            checked_at=EXCLUDED.checked_at
+        WHERE expirations.source_task_id IS DISTINCT FROM EXCLUDED.source_task_id
+            OR EXCLUDED.expires_at < expirations.expires_at
+    `, e.TargetID, e.Kind, e.Subject, e.ExpiresAt, e.SourceTaskID, e.SourceAgent, e.CheckedAt)
+    return err
+}
+
+// ListExpirations returns all known expiry dates, soonest first. A renewal (a date past
+// the one reminders were sent for) starts the reminders over.
+func (p *Postgres) ListExpirations(ctx context.Context) ([]Expiration, error) {
+    rows, err := p.pool.Query(ctx, `
+        SELECT e.target_id, t.target, e.kind, e.subject, e.expires_at, e.source_task_id, e.source_agent, e.checked_at,
+            CASE WHEN e.expires_at <= e.notified_expires_at THEN e.notified_threshold END
+        FROM expirations e JOIN tracked_targets t ON t.id = e.target_id
+        ORDER BY e.expires_at ASC
+    `)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []Expiration
+    for rows.Next() {
+        var e Expiration
+        if err := rows.Scan(&e.TargetID, &e.Target, &e.Kind, &e.Subject, &e.ExpiresAt, &e.SourceTaskID, &e.SourceAgent, &e.CheckedAt, &e.NotifiedThreshold); err != nil {
+            return nil, err
+        }
+        out = append(out, e)
+    }
+    return out, rows.Err()
+}
+
+// MarkExpirationNotified records a sent reminder, unless the date changed in the meantime.
+func (p *Postgres) MarkExpirationNotified(ctx context.Context, e Expiration, threshold int) error {
+    _, err := p.pool.Exec(ctx, `
+        UPDATE expirations SET notified_threshold=$4, notified_expires_at=expires_at
+        WHERE target_id=$1 AND kind=$2 AND expires_at=$3
+    `, e.TargetID, e.Kind, e.ExpiresAt, threshold)
+    return err
+}
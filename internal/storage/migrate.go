@@ -48,6 +48,41 @@ func (p *Postgres) EnsureSchema() error {
         );
         ALTER TABLE results ADD COLUMN IF NOT EXISTS details JSONB;
         CREATE INDEX IF NOT EXISTS idx_results_task_id ON results(task_id);
+        -- one result per task, agent and method: duplicates from retried submissions
+        -- are folded into the latest one before the constraint is added
+        DO $$
+        BEGIN
+            IF to_regclass('idx_results_task_agent_method') IS NULL THEN
+                UPDATE results SET method = lower(method) WHERE method <> lower(method);
+                DELETE FROM results a USING results b
+                    WHERE a.task_id = b.task_id AND a.agent_id = b.agent_id AND a.method = b.method
+                    AND (a.created_at, a.id) < (b.created_at, b.id);
+                CREATE UNIQUE INDEX idx_results_task_agent_method ON results(task_id, agent_id, method);
+                UPDATE tasks t SET received_results = (SELECT COUNT(*) FROM results r WHERE r.task_id = t.id);
+            END IF;
+        END $$;
+        CREATE TABLE IF NOT EXISTS tracked_targets (
+            id UUID PRIMARY KEY,
+            target TEXT NOT NULL UNIQUE,
+            last_checked_at TIMESTAMPTZ NULL,
+            created_at TIMESTAMPTZ NOT NULL
+        );
+        CREATE TABLE IF NOT EXISTS expirations (
+            target_id UUID NOT NULL REFERENCES tracked_targets(id) ON DELETE CASCADE,
+            kind TEXT NOT NULL,
+            subject TEXT NOT NULL,
+            expires_at TIMESTAMPTZ NOT NULL,
+            source_task_id UUID NULL,
+            source_agent TEXT NOT NULL DEFAULT '',
+            checked_at TIMESTAMPTZ NOT NULL,
+            notified_threshold INTEGER NULL,
+            PRIMARY KEY (target_id, kind)
+        );
+        CREATE INDEX IF NOT EXISTS idx_expirations_expires_at ON expirations(expires_at);
+        -- the date the sent reminders were about; they stand until the date moves past it
+        ALTER TABLE expirations ADD COLUMN IF NOT EXISTS notified_expires_at TIMESTAMPTZ NULL;
+        UPDATE expirations SET notified_expires_at = expires_at
+            WHERE notified_threshold IS NOT NULL AND notified_expires_at IS NULL;
     `)
     return err
 }
@@ -32,10 +32,11 @@ func (p *Postgres) Close() {
 type TaskStatus string
 
 const (
-    TaskStatusQueued   TaskStatus = "queued"
-    TaskStatusRunning  TaskStatus = "running"
-    TaskStatusFinished TaskStatus = "finished"
-    TaskStatusFailed   TaskStatus = "failed"
+    TaskStatusQueued    TaskStatus = "queued"
+    TaskStatusRunning   TaskStatus = "running"
+    TaskStatusFinished  TaskStatus = "finished"
+    TaskStatusFailed    TaskStatus = "failed"
+    TaskStatusCancelled TaskStatus = "cancelled"
 )
 
 type CheckTask struct {
@@ -165,19 +166,33 @@ func (p *Postgres) GetTask(ctx context.Context, id uuid.UUID) (*CheckTask, error
     return &t, nil
 }
 
+// UpdateTaskStatus moves a task to status. A cancelled task stays cancelled: results
+// racing the cancellation must not bring it back to running or finished.
 func (p *Postgres) UpdateTaskStatus(ctx context.Context, id uuid.UUID, status TaskStatus) error {
     ct, err := p.pool.Exec(ctx, `
-        UPDATE tasks SET status=$2, updated_at=NOW() WHERE id=$1
+        UPDATE tasks SET status=$2, updated_at=NOW() WHERE id=$1 AND status<>'cancelled'
     `, id, status)
     if err != nil {
         return err
     }
     if ct.RowsAffected() == 0 {
-        return errors.New("task not found")
+        var exists bool
+        if err := p.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM tasks WHERE id=$1)`, id).Scan(&exists); err != nil { return err }
+        if !exists { return errors.New("task not found") }
     }
     return nil
 }
 
+// CancelTask marks a queued or running task cancelled. It reports false when the task
+// had already finished.
+func (p *Postgres) CancelTask(ctx context.Context, id uuid.UUID) (bool, error) {
+    ct, err := p.pool.Exec(ctx, `
+        UPDATE tasks SET status='cancelled', updated_at=NOW() WHERE id=$1 AND status IN ('queued', 'running')
+    `, id)
+    if err != nil { return false, err }
+    return ct.RowsAffected() > 0, nil
+}
+
 // ListExpiredRunningTasks returns tasks that are still running but past their deadline.
 func (p *Postgres) ListExpiredRunningTasks(ctx context.Context) ([]CheckTask, error) {
     rows, err := p.pool.Query(ctx, `
@@ -199,10 +214,12 @@ func (p *Postgres) ListExpiredRunningTasks(ctx context.Context) ([]CheckTask, er
     return out, rows.Err()
 }
 
-func (p *Postgres) IncrementReceived(ctx context.Context, id uuid.UUID) (int, int, error) {
+// RecountReceived sets received_results to the number of result rows of the task, one
+// per agent and method, and returns it with expected_results.
+func (p *Postgres) RecountReceived(ctx context.Context, id uuid.UUID) (int, int, error) {
     row := p.pool.QueryRow(ctx, `
         UPDATE tasks
-        SET received_results = received_results + 1, updated_at = NOW()
+        SET received_results = (SELECT COUNT(*) FROM results WHERE task_id=$1), updated_at = NOW()
         WHERE id=$1
         RETURNING expected_results, received_results
     `, id)
@@ -226,6 +243,7 @@ func (p *Postgres) SetExpectedAndDeadline(ctx context.Context, id uuid.UUID, exp
     return nil
 }
 
+// InsertResult stores a result unless the agent already reported the method for the task.
 func (p *Postgres) InsertResult(ctx context.Context, r *CheckResult) error {
     r.ID = uuid.New()
     now := time.Now().UTC()
@@ -233,10 +251,30 @@ func (p *Postgres) InsertResult(ctx context.Context, r *CheckResult) error {
     _, err := p.pool.Exec(ctx, `
         INSERT INTO results (id, task_id, agent_id, region, method, success, latency_ms, status_code, message, checked_at, created_at, details)
         VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+        ON CONFLICT (task_id, agent_id, method) DO NOTHING
     `, r.ID, r.TaskID, r.AgentID, r.Region, r.Method, r.Success, r.LatencyMs, r.StatusCode, r.Message, r.CheckedAt, r.CreatedAt, r.Details)
     return err
 }
 
+// UpsertResult stores a result, replacing the one the agent reported earlier for the
+// same task and method, so a retried submission is stored once. The row keeps its id
+// and created_at; r gets them back.
+func (p *Postgres) UpsertResult(ctx context.Context, r *CheckResult) error {
+    return p.pool.QueryRow(ctx, `
+        INSERT INTO results (id, task_id, agent_id, region, method, success, latency_ms, status_code, message, checked_at, created_at, details)
+        VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+        ON CONFLICT (task_id, agent_id, method) DO UPDATE SET
+            region=EXCLUDED.region,
+            success=EXCLUDED.success,
+            latency_ms=EXCLUDED.latency_ms,
+            status_code=EXCLUDED.status_code,
+            message=EXCLUDED.message,
+            checked_at=EXCLUDED.checked_at,
+            details=EXCLUDED.details
+        RETURNING id, created_at
+    `, uuid.New(), r.TaskID, r.AgentID, r.Region, r.Method, r.Success, r.LatencyMs, r.StatusCode, r.Message, r.CheckedAt, time.Now().UTC(), r.Details).Scan(&r.ID, &r.CreatedAt)
+}
+
 func (p *Postgres) ListResultsByTask(ctx context.Context, taskID uuid.UUID) ([]CheckResult, error) {
     rows, err := p.pool.Query(ctx, `
         SELECT id, task_id, agent_id, region, method, success, latency_ms, status_code, message, checked_at, created_at, details
@@ -67,6 +67,7 @@ docker run -d --restart unless-stopped \
     -e REGION=$REGION \
     -e AGENT_ID=$AGENT_NAME \
     -e AGENT_TOKEN=$AGENT_TOKEN \
+    -v $AGENT_NAME-spool:/var/lib/agent \
     aeza-agent:latest
 
 echo -n -e "Welcome to Family!\n"
@@ -1,5 +1,5 @@
 import React, { useEffect, useMemo, useRef, useState } from 'react';
-import { createCheck, getCheck, openResultsWS, adminListAgentsBasic, adminCreateAgentBasic, adminDeleteAgentBasic, adminGetRunCmdBasic, adminResetTokenBasic } from './services/api';
+import { createCheck, getCheck, cancelCheck, openResultsWS, adminListAgentsBasic, adminCreateAgentBasic, adminDeleteAgentBasic, adminGetRunCmdBasic, adminResetTokenBasic } from './services/api';
 import './app.css';
 
 const ALL_METHODS = ['http','dns','tcp','icmp','udp','whois'];
@@ -44,7 +44,7 @@ export default function App() {
 
   useEffect(() => {
     wsRef.current = openResultsWS((evt) => {
-      if (evt?.type === 'result' && evt.task_id && evt.task_id === taskId) {
+      if ((evt?.type === 'result' || evt?.type === 'status') && evt.task_id && evt.task_id === taskId) {
         getCheck(taskId).then(setTask).catch(()=>{});
       }
       if (evt?.type === 'log' && evt.task_id && evt.task_id === taskId) {
@@ -72,6 +72,15 @@ export default function App() {
     }
   };
 
+  const onCancel = async () => {
+    try {
+      await cancelCheck(taskId);
+      setTask(await getCheck(taskId));
+    } catch (e) {
+      alert('Не удалось отменить задачу');
+    }
+  };
+
   const [route, setRoute] = useState(() => (typeof window!=='undefined' ? window.location.pathname : '/'));
   useEffect(()=>{
     const onPop = ()=> setRoute(window.location.pathname);
@@ -156,7 +165,12 @@ export default function App() {
 
       {task && (
         <div className="info-display">
-          <h3>Статус: <StatusBadge value={task.status} /></h3>
+          <div style={{ display:'flex', gap:12, alignItems:'center' }}>
+            <h3>Статус: <StatusBadge value={task.status} /></h3>
+            {(task.status === 'queued' || task.status === 'running') && (
+              <button className="btn-ghost" onClick={onCancel}>Отменить</button>
+            )}
+          </div>
           <div className="pill" style={{ marginTop: 8, display:'inline-flex' }}>Цель: {formatTargetForDisplay(task.target)}</div>
           <div style={{ marginTop: 8 }}>Методы: {task.methods?.map(labelForMethod).join(', ')}</div>
           <div style={{ marginTop: 6 }}>Прогресс: {task.received_results ?? task.received}/{task.expected_results ?? task.expected}</div>
@@ -495,7 +509,7 @@ function AdminPanel({ show, onClose }){
 
 function StatusBadge({ value }) {
   const v = (value||'').toLowerCase();
-  const map = { queued: 'В очереди', running: 'Выполняется', finished: 'Завершено', failed: 'Ошибка' };
+  const map = { queued: 'В очереди', running: 'Выполняется', finished: 'Завершено', failed: 'Ошибка', cancelled: 'Отменено' };
   const color = v==='finished' ? '#16a34a' : v==='running' ? '#2563eb' : v==='failed' ? '#dc2626' : '#6b7280';
   return <span style={{ padding:'2px 8px', borderRadius:8, background: color, color:'#fff' }}>{map[v] || '—'}</span>;
 }
@@ -635,9 +649,27 @@ function renderResult(r) {
       {ok ? 'OK' : 'Ошибка'}{parts.length?`: ${parts.join(', ')}`:''} {ok ? '✅' : '❌'}
       {r.details && r.method==='dns' && (
         <div style={{ color:'#e5e7eb', marginTop:6 }}>
-          {Object.entries(r.details).map(([k,v])=> (
+          {Object.entries(r.details).filter(([k,v])=> v === null || typeof v !== 'object' || (Array.isArray(v) && v.every(x => typeof x !== 'object'))).map(([k,v])=> (
             <div key={k}><b>{k}:</b> {Array.isArray(v)? v.join(', ') : String(v)}</div>
           ))}
+          {Array.isArray(r.details.dnssec) && r.details.dnssec.map(d => (
+            <div key={'dnssec-'+d.type}><b>DNSSEC {d.type}:</b> {d.status}{d.reason ? ` (${d.reason})` : ''}</div>
+          ))}
+        </div>
+      )}
+      {r.details && r.method==='tcp' && Array.isArray(r.details.ports) && r.details.ports.length > 1 && (
+        <div style={{ color:'#e5e7eb', marginTop:6 }}>
+          {r.details.ports.map(p => (
+            <div key={p.port}><b>{p.port}:</b> {p.status}{p.status==='open' ? `, ${p.connect_ms} мс` : ''}{p.banner ? ` — ${p.banner}` : ''}</div>
+          ))}
+        </div>
+      )}
+      {r.details && r.method==='ssh' && r.details.host_key && (
+        <div style={{ color:'#e5e7eb', marginTop:6 }}>
+          <div><b>{r.details.host_key.type}:</b> {r.details.host_key.fingerprint_sha256}</div>
+          {Array.isArray(r.details.weak) && r.details.weak.map(w => (
+            <div key={w.category+w.algorithm}><b>{w.category}:</b> {w.algorithm} ({w.reason})</div>
+          ))}
         </div>
       )}
       {/* HTTP headers hidden per request; show only status code above */}
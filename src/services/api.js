@@ -1,10 +1,10 @@
 const API_BASE = process.env.REACT_APP_API_BASE || (typeof window !== 'undefined' ? window.location.origin : 'http://localhost:8080');
 
-export async function createCheck(target, methods) {
+export async function createCheck(target, methods, options) {
   const resp = await fetch(`${API_BASE}/api/check`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify({ target, methods })
+    body: JSON.stringify({ target, methods, options })
   });
   if (!resp.ok) throw new Error('Failed to create check');
   return resp.json();
@@ -16,6 +16,12 @@ export async function getCheck(id) {
   return resp.json();
 }
 
+export async function cancelCheck(id) {
+  const resp = await fetch(`${API_BASE}/api/check/${id}/cancel`, { method: 'POST' });
+  if (!resp.ok) throw new Error('Failed to cancel check');
+  return resp.json();
+}
+
 export function openResultsWS(onMessage) {
   const wsUrl = API_BASE.replace(/^http(s?):/, (m, s) => (s ? 'wss:' : 'ws:')) + '/api/ws';
   const ws = new WebSocket(wsUrl);